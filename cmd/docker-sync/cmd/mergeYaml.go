@@ -5,8 +5,8 @@ import (
 	"os"
 	"time"
 
+	"github.com/Altinity/docker-sync/config"
 	"github.com/spf13/cobra"
-	"gopkg.in/yaml.v3"
 )
 
 var yamlFiles []string
@@ -21,32 +21,16 @@ var mergeYamlCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		outFile := cmd.Flag("output").Value.String()
 
-		base := make(map[string]interface{})
-		currentMap := make(map[string]interface{})
-
-		for _, fname := range yamlFiles {
-			data, err := os.ReadFile(fname)
-			if err != nil {
-				cmd.Annotations["error"] = err.Error()
-				return
-			}
-			if err := yaml.Unmarshal(data, &currentMap); err != nil {
-				cmd.Annotations["error"] = err.Error()
-				return
-			}
-			base = mergeMaps(base, currentMap)
-		}
-
-		config, err := yaml.Marshal(base)
+		merged, err := config.MergeYAML(yamlFiles)
 		if err != nil {
 			cmd.Annotations["error"] = err.Error()
 			return
 		}
 
 		if outFile == "" {
-			fmt.Println(string(config))
+			fmt.Println(string(merged))
 		} else {
-			if err := os.WriteFile(outFile, config, 0o644); err != nil {
+			if err := os.WriteFile(outFile, merged, 0o644); err != nil {
 				cmd.Annotations["error"] = err.Error()
 				return
 			}
@@ -68,22 +52,3 @@ func init() {
 	mergeYamlCmd.Flags().StringP("output", "o", "", "File to write config to (default is stdout)")
 	mergeYamlCmd.Flags().StringSliceVarP(&yamlFiles, "yamlFiles", "f", []string{}, "Yaml files to merge")
 }
-
-func mergeMaps(a, b map[string]interface{}) map[string]interface{} {
-	out := make(map[string]interface{}, len(a))
-	for k, v := range a {
-		out[k] = v
-	}
-	for k, v := range b {
-		if v, ok := v.(map[string]interface{}); ok {
-			if bv, ok := out[k]; ok {
-				if bv, ok := bv.(map[string]interface{}); ok {
-					out[k] = mergeMaps(bv, v)
-					continue
-				}
-			}
-		}
-		out[k] = v
-	}
-	return out
-}
@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	dockersync "github.com/Altinity/docker-sync"
+	"github.com/Altinity/docker-sync/config"
+	"github.com/Altinity/docker-sync/internal/sync"
+	"github.com/Altinity/docker-sync/logging"
+	"github.com/Altinity/docker-sync/structs"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var planCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "Show the copies, overwrites and deletions a sync would perform",
+	PreRun: func(cmd *cobra.Command, args []string) {
+		cmd.Annotations = make(map[string]string)
+		cmd.Annotations["error"] = ""
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer cancel()
+
+		logging.ReloadGlobalLogger()
+
+		if err := runPlan(ctx, cmd, config.SyncImages.Images()); err != nil {
+			cmd.Annotations["error"] = err.Error()
+		}
+	},
+	PostRun: func(cmd *cobra.Command, args []string) {
+		if cmd.Annotations["error"] != "" {
+			fmt.Fprintf(cmd.ErrOrStderr(), "Error: %s\n", cmd.Annotations["error"])
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(planCmd)
+
+	addPlanFlags(planCmd)
+}
+
+// addPlanFlags adds the flags used to print a plan.
+func addPlanFlags(cmd *cobra.Command) {
+	cmd.Flags().String("format", "table", "Plan output format (table or json)")
+}
+
+// runPlan computes the plan for the images and writes it to the command output.
+func runPlan(ctx context.Context, cmd *cobra.Command, images []*structs.Image) error {
+	format, _ := cmd.Flags().GetString("format")
+	if format != "table" && format != "json" {
+		return fmt.Errorf("unknown format %q, must be one of [table json]", format)
+	}
+
+	actions, err := dockersync.Plan(ctx, images)
+
+	// Group actions by target
+	slices.SortStableFunc(actions, func(a, b sync.Action) int {
+		if c := strings.Compare(a.Target, b.Target); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Tag, b.Tag)
+	})
+
+	if format == "json" {
+		if actions == nil {
+			actions = []sync.Action{}
+		}
+
+		enc := json.NewEncoder(cmd.OutOrStdout())
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(actions); err != nil {
+			return err
+		}
+	} else {
+		if err := writePlanTable(cmd.OutOrStdout(), actions); err != nil {
+			return err
+		}
+	}
+
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msg("Plan is incomplete")
+	}
+
+	return err
+}
+
+func writePlanTable(w io.Writer, actions []sync.Action) error {
+	if len(actions) == 0 {
+		_, err := fmt.Fprintln(w, "No changes.")
+		return err
+	}
+
+	counts := make(map[sync.ActionType]int)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TARGET\tTAG\tACTION\tSOURCE")
+
+	for _, action := range actions {
+		counts[action.Type]++
+
+		// Referrers found through the referrers API are copied by digest
+		tag := action.Tag
+		if tag == "" {
+			tag = "@" + action.Digest
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", action.Target, tag, action.Type, action.Image)
+	}
+
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintf(w, "\nPlan: %d to copy, %d to overwrite, %d to delete.\n",
+		counts[sync.ActionCopy], counts[sync.ActionOverwrite], counts[sync.ActionDelete])
+
+	return err
+}
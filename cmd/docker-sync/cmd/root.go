@@ -14,30 +14,38 @@ import (
 	"github.com/spf13/cobra"
 )
 
-var cfgFile string
+var (
+	cfgFile   string
+	mergeFrom []string
+)
 
 var rootCmd = &cobra.Command{
 	Use:   "docker-sync",
 	Short: "Keep your Docker images in sync",
+	// The configuration is read once the flags are parsed
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		initConfig(cmd)
+	},
 	PreRun: func(cmd *cobra.Command, args []string) {
 		cmd.Annotations = make(map[string]string)
 		cmd.Annotations["error"] = ""
 	},
 	Run: func(cmd *cobra.Command, args []string) {
-		ctx, cancel := context.WithCancel(context.Background())
+		// The first signal drains the syncs in progress, a second one terminates immediately
+		ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 		defer cancel()
-
-		c := make(chan os.Signal, 1)
-		signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
-		go func() {
-			<-c
-			cancel()
-			time.Sleep(1 * time.Second)
-			os.Exit(0)
-		}()
+		context.AfterFunc(ctx, cancel)
 
 		logging.ReloadGlobalLogger()
 
+		if dryRun, _ := cmd.Flags().GetBool("dry-run"); dryRun {
+			if err := runPlan(ctx, cmd, config.SyncImages.Images()); err != nil {
+				cmd.Annotations["error"] = err.Error()
+			}
+
+			return
+		}
+
 		log.Info().Msg("Starting Docker Sync")
 
 		if err := dockersync.Run(ctx); err != nil {
@@ -60,8 +68,6 @@ var rootCmd = &cobra.Command{
 }
 
 func Execute() {
-	initConfig()
-
 	err := rootCmd.Execute()
 	if err != nil {
 		os.Exit(1)
@@ -70,10 +76,19 @@ func Execute() {
 
 func init() {
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is config.yaml)")
+	rootCmd.PersistentFlags().StringSliceVar(&mergeFrom, "merge-from", nil, "YAML files merged in order as the config file, instead of --config")
+
+	rootCmd.Flags().Bool("dry-run", false, "Print the actions a sync would perform without performing them")
+	addPlanFlags(rootCmd)
 }
 
-func initConfig() {
-	if err := config.InitConfig(cfgFile); err != nil {
+func initConfig(cmd *cobra.Command) {
+	if err := config.InitConfig(cfgFile, mergeFrom...); err != nil {
+		// validate reports the errors of the config files, with their file and line
+		if cmd == validateCmd {
+			return
+		}
+
 		log.Fatal().Err(err).Msg("Failed to initialize config")
 	}
 
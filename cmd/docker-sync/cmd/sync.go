@@ -7,7 +7,6 @@ import (
 	"path/filepath"
 	"strings"
 	"syscall"
-	"time"
 
 	dockersync "github.com/Altinity/docker-sync"
 	"github.com/Altinity/docker-sync/config"
@@ -31,6 +30,7 @@ type syncAuth struct {
 	Password string `yaml:"password"`
 	Token    string `yaml:"token"`
 	Helper   string `yaml:"helper"`
+	AuthFile string `yaml:"authFile"`
 }
 
 type syncRegistry struct {
@@ -52,18 +52,15 @@ type syncConfig struct {
 var syncCmd = &cobra.Command{
 	Use:   "sync",
 	Short: "Sync a single image",
+	PreRun: func(cmd *cobra.Command, args []string) {
+		cmd.Annotations = make(map[string]string)
+		cmd.Annotations["error"] = ""
+	},
 	Run: func(cmd *cobra.Command, args []string) {
-		ctx, cancel := context.WithCancel(context.Background())
+		// The first signal drains the syncs in progress, a second one terminates immediately
+		ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 		defer cancel()
-
-		c := make(chan os.Signal, 1)
-		signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
-		go func() {
-			<-c
-			cancel()
-			time.Sleep(1 * time.Second)
-			os.Exit(0)
-		}()
+		context.AfterFunc(ctx, cancel)
 
 		log.Info().Msg("Starting Docker Sync")
 
@@ -88,11 +85,13 @@ var syncCmd = &cobra.Command{
 
 		var registries []syncRegistry
 
+		sourceAuthFile, _ := cmd.Flags().GetString("source-auth-file")
 		sourceHelper, _ := cmd.Flags().GetString("source-helper")
 		sourcePassword, _ := cmd.Flags().GetString("source-password")
 		sourceToken, _ := cmd.Flags().GetString("source-token")
 		sourceUsername, _ := cmd.Flags().GetString("source-username")
 
+		targetAuthFile, _ := cmd.Flags().GetString("target-auth-file")
 		targetHelper, _ := cmd.Flags().GetString("target-helper")
 		targetPassword, _ := cmd.Flags().GetString("target-password")
 		targetToken, _ := cmd.Flags().GetString("target-token")
@@ -100,15 +99,22 @@ var syncCmd = &cobra.Command{
 
 		imgHelper := structs.Image{}
 
-		sourceUrl := imgHelper.GetRegistry(source)
+		var sourceUrl string
+		if strings.HasPrefix(source, "r2:") || strings.HasPrefix(source, "s3:") {
+			fields := strings.Split(source, ":")
+			sourceUrl = strings.Join(fields[:3], ":")
+		} else {
+			sourceUrl = imgHelper.GetRegistry(source)
+		}
 
-		if sourceUrl != "" && (sourceUsername != "" || sourcePassword != "" || sourceToken != "" || sourceHelper != "") {
+		if sourceUrl != "" && (sourceUsername != "" || sourcePassword != "" || sourceToken != "" || sourceHelper != "" || sourceAuthFile != "") {
 			registries = append(registries, syncRegistry{
 				Auth: syncAuth{
 					Username: sourceUsername,
 					Password: sourcePassword,
 					Token:    sourceToken,
 					Helper:   sourceHelper,
+					AuthFile: sourceAuthFile,
 				},
 				Name: "source",
 				URL:  sourceUrl,
@@ -123,13 +129,14 @@ var syncCmd = &cobra.Command{
 			targetUrl = imgHelper.GetRegistry(target)
 		}
 
-		if targetUrl != "" && (targetUsername != "" || targetPassword != "" || targetToken != "" || targetHelper != "") {
+		if targetUrl != "" && (targetUsername != "" || targetPassword != "" || targetToken != "" || targetHelper != "" || targetAuthFile != "") {
 			registries = append(registries, syncRegistry{
 				Auth: syncAuth{
 					Username: targetUsername,
 					Password: targetPassword,
 					Token:    targetToken,
 					Helper:   targetHelper,
+					AuthFile: targetAuthFile,
 				},
 				Name: "target",
 				URL:  targetUrl,
@@ -161,6 +168,14 @@ var syncCmd = &cobra.Command{
 
 		images := config.SyncImages.Images()
 
+		if dryRun, _ := cmd.Flags().GetBool("dry-run"); dryRun {
+			if err := runPlan(ctx, cmd, images); err != nil {
+				cmd.Annotations["error"] = err.Error()
+			}
+
+			return
+		}
+
 		if err := dockersync.RunOnce(ctx, images); err != nil {
 			cmd.Annotations["error"] = err.Error()
 			log.Error().
@@ -188,11 +203,13 @@ func init() {
 
 	syncCmd.Flags().StringP("ecr-region", "", os.Getenv("AWS_REGION"), "AWS region for ECR")
 
+	syncCmd.Flags().StringP("source-auth-file", "", os.Getenv("DOCKER_SYNC_SOURCE_AUTH_FILE"), "Source registry Docker config.json")
 	syncCmd.Flags().StringP("source-helper", "", os.Getenv("DOCKER_SYNC_SOURCE_HELPER"), "Source registry helper")
 	syncCmd.Flags().StringP("source-password", "", os.Getenv("DOCKER_SYNC_SOURCE_PASSWORD"), "Source registry password")
 	syncCmd.Flags().StringP("source-token", "", os.Getenv("DOCKER_SYNC_SOURCE_TOKEN"), "Source registry token")
 	syncCmd.Flags().StringP("source-username", os.Getenv("DOCKER_SYNC_SOURCE_USERNAME"), "", "Source registry username")
 
+	syncCmd.Flags().StringP("target-auth-file", "", os.Getenv("DOCKER_SYNC_TARGET_AUTH_FILE"), "target registry Docker config.json")
 	syncCmd.Flags().StringP("target-helper", "", os.Getenv("DOCKER_SYNC_TARGET_HELPER"), "target registry helper")
 	syncCmd.Flags().StringP("target-password", "", os.Getenv("DOCKER_SYNC_TARGET_PASSWORD"), "target registry password")
 	syncCmd.Flags().StringP("target-token", "", os.Getenv("DOCKER_SYNC_TARGET_TOKEN"), "target registry token")
@@ -200,5 +217,8 @@ func init() {
 
 	syncCmd.Flags().BoolP("purge", "p", false, "Purge tags not in the source registry")
 
+	syncCmd.Flags().Bool("dry-run", false, "Print the actions a sync would perform without performing them")
+	addPlanFlags(syncCmd)
+
 	// For more registries and advanced options, please use a configuration file
 }
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Altinity/docker-sync/config"
+	"github.com/spf13/cobra"
+)
+
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Strictly check the config file, or print its JSON Schema",
+	PreRun: func(cmd *cobra.Command, args []string) {
+		cmd.Annotations = make(map[string]string)
+		cmd.Annotations["error"] = ""
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		if schema, _ := cmd.Flags().GetBool("schema"); schema {
+			b, err := config.Schema()
+			if err != nil {
+				cmd.Annotations["error"] = err.Error()
+				return
+			}
+
+			fmt.Fprintln(cmd.OutOrStdout(), string(b))
+
+			return
+		}
+
+		warnings, err := config.CheckFiles()
+
+		for _, warning := range warnings {
+			fmt.Fprintf(cmd.ErrOrStderr(), "warning: %s\n", warning)
+		}
+
+		if err != nil {
+			for _, e := range flattenErrors(err) {
+				fmt.Fprintf(cmd.ErrOrStderr(), "error: %s\n", e)
+			}
+
+			cmd.Annotations["error"] = "invalid configuration"
+
+			return
+		}
+
+		fmt.Fprintln(cmd.OutOrStdout(), "Configuration is valid")
+	},
+	PostRun: func(cmd *cobra.Command, args []string) {
+		if cmd.Annotations["error"] != "" {
+			fmt.Fprintf(cmd.ErrOrStderr(), "Error: %s\n", cmd.Annotations["error"])
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(validateCmd)
+
+	validateCmd.Flags().Bool("schema", false, "Print a JSON Schema of the config file instead of checking it")
+}
+
+// flattenErrors returns the errors joined by errors.Join, one per line of the report.
+func flattenErrors(err error) []error {
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return []error{err}
+	}
+
+	var errs []error
+	for _, e := range joined.Unwrap() {
+		errs = append(errs, flattenErrors(e)...)
+	}
+
+	return errs
+}
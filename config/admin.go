@@ -0,0 +1,33 @@
+package config
+
+var (
+	// region Admin.
+
+	// AdminEnabled indicates whether the health, readiness and status endpoints are served.
+	AdminEnabled = NewKey("admin.enabled",
+		WithDefaultValue(false),
+		WithValidBool())
+
+	// AdminAddress specifies the network address of the admin server. When it is the Prometheus address, the
+	// endpoints are served by the Prometheus server.
+	AdminAddress = NewKey("admin.address",
+		WithDefaultValue("127.0.0.1:8080"),
+		WithValidNetHostPort())
+
+	// AdminToken is the bearer token of the admin API. The API is disabled without a token.
+	AdminToken = NewKey("admin.token",
+		WithDefaultValue(""),
+		WithValidString())
+
+	// AdminWebhooksSecret is the shared secret verifying the registry webhooks. Webhooks are disabled without a
+	// secret.
+	AdminWebhooksSecret = NewKey("admin.webhooks.secret",
+		WithDefaultValue(""),
+		WithValidString())
+
+	// AdminStallTimeout is how long the sync can make no progress before /healthz fails.
+	AdminStallTimeout = NewKey("admin.stallTimeout",
+		WithDefaultValue("1h"),
+		WithValidDuration())
+	// endregion.
+)
@@ -1,18 +1,47 @@
 package config
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 
 	"github.com/spf13/viper"
 )
 
 var keys = make(map[string]*Key)
 
+var (
+	// configFiles are the files the configuration was read from, watched for changes.
+	configFiles []string
+	// mergeConfig is set when configFiles are merged instead of read as a single file.
+	mergeConfig bool
+)
+
 // InitConfig initializes the application's configuration system. It loads
-// settings from a specified file, environment variables, or search paths, and
-// listens for changes to dynamically update the configuration.
-func InitConfig(cfgFile string) error {
+// settings from a specified file, environment variables, or search paths. When
+// mergeFrom files are given, they are merged in order instead, like the
+// mergeYaml command does. The files read are watched by Watch.
+func InitConfig(cfgFile string, mergeFrom ...string) error {
 	viper.SetEnvPrefix("DOCKERSYNC")
+	viper.AutomaticEnv()
+
+	if len(mergeFrom) > 0 {
+		configFiles = mergeFrom
+		mergeConfig = true
+
+		data, err := MergeYAML(mergeFrom)
+		if err != nil {
+			return err
+		}
+
+		viper.SetConfigType("yaml")
+
+		return viper.ReadConfig(bytes.NewReader(data))
+	}
 
 	if cfgFile != "" {
 		// Use config file from the flag.
@@ -24,14 +53,99 @@ func InitConfig(cfgFile string) error {
 		viper.SetConfigType("yaml")
 	}
 
-	viper.AutomaticEnv()
+	err := viper.ReadInConfig()
 
-	if err := viper.ReadInConfig(); err != nil {
-		var configFileNotFoundError viper.ConfigFileNotFoundError
-		if !errors.As(err, &configFileNotFoundError) {
-			return err
-		}
+	// The file is recorded even if it can't be read, so CheckFiles reports its errors
+	configFiles = nil
+	mergeConfig = false
+
+	if f := viper.ConfigFileUsed(); f != "" {
+		configFiles = []string{f}
+	}
+
+	var configFileNotFoundError viper.ConfigFileNotFoundError
+	if err != nil && !errors.As(err, &configFileNotFoundError) {
+		return err
 	}
 
 	return nil
 }
+
+// ReadConfig reads the configuration files again. The new configuration is
+// validated before it replaces the current one, so an invalid edit leaves the
+// last valid configuration active. Keys are updated by Reload.
+func ReadConfig() error {
+	if len(configFiles) == 0 {
+		return nil
+	}
+
+	var data []byte
+	var err error
+	configType := "yaml"
+
+	if mergeConfig {
+		data, err = MergeYAML(configFiles)
+	} else {
+		data, err = os.ReadFile(configFiles[0])
+		if ext := strings.TrimPrefix(filepath.Ext(configFiles[0]), "."); ext != "" {
+			configType = ext
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	v, err := newViper(data, configType)
+	if err != nil {
+		return err
+	}
+
+	if err := Validate(v); err != nil {
+		return err
+	}
+
+	return viper.ReadConfig(bytes.NewReader(data))
+}
+
+// newViper returns a viper instance with the defaults and environment of the
+// global one, reading the given configuration.
+func newViper(data []byte, configType string) (*viper.Viper, error) {
+	v := viper.New()
+	v.SetEnvPrefix("DOCKERSYNC")
+	v.AutomaticEnv()
+	v.SetConfigType(configType)
+
+	for _, k := range keys {
+		if k.Default != nil {
+			v.SetDefault(k.Name, k.Default)
+		}
+	}
+
+	if err := v.ReadConfig(bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("failed to parse configuration: %w", err)
+	}
+
+	return v, nil
+}
+
+// Validate checks the values of all keys in a configuration.
+func Validate(v *viper.Viper) error {
+	var errs []error
+
+	names := make([]string, 0, len(keys))
+	for name := range keys {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		for _, f := range keys[name].ValidationFuncs {
+			if err := f(v.Get(name)); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				break
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+// resetConfig forgets the configuration files read by a test.
+func resetConfig(t *testing.T) {
+	t.Cleanup(func() {
+		configFiles = nil
+		mergeConfig = false
+		_ = viper.ReadConfig(strings.NewReader(""))
+	})
+}
+
+func TestReadConfig(t *testing.T) {
+	resetConfig(t)
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte("sync:\n  interval: 10m\n"), 0o644))
+	assert.NoError(t, InitConfig(path))
+	assert.Equal(t, "10m", viper.GetString("sync.interval"))
+
+	// Invalid edits leave the current configuration active
+	assert.NoError(t, os.WriteFile(path, []byte("sync:\n  interval: often\n"), 0o644))
+	err := ReadConfig()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "sync.interval")
+	assert.Equal(t, "10m", viper.GetString("sync.interval"))
+
+	assert.NoError(t, os.WriteFile(path, []byte("sync:\n  interval: 5m\n"), 0o644))
+	assert.NoError(t, ReadConfig())
+	assert.Equal(t, "5m", viper.GetString("sync.interval"))
+}
+
+func TestMergeConfig(t *testing.T) {
+	resetConfig(t)
+
+	dir := t.TempDir()
+	configMap := filepath.Join(dir, "config_map.yaml")
+	secret := filepath.Join(dir, "secret.yaml")
+
+	assert.NoError(t, os.WriteFile(configMap, []byte("sync:\n  interval: 10m\n  maxErrors: 3\n"), 0o644))
+	assert.NoError(t, os.WriteFile(secret, []byte("sync:\n  maxErrors: 7\n"), 0o644))
+
+	assert.NoError(t, InitConfig("", configMap, secret))
+	assert.Equal(t, "10m", viper.GetString("sync.interval"))
+	assert.Equal(t, 7, viper.GetInt("sync.maxErrors"))
+
+	assert.NoError(t, os.WriteFile(configMap, []byte("sync:\n  interval: 1h\n  maxErrors: 3\n"), 0o644))
+	assert.NoError(t, ReadConfig())
+	assert.Equal(t, "1h", viper.GetString("sync.interval"))
+	assert.Equal(t, 7, viper.GetInt("sync.maxErrors"))
+}
+
+func TestWatch(t *testing.T) {
+	resetConfig(t)
+
+	watchDebounce = 10 * time.Millisecond
+	defer func() {
+		watchDebounce = time.Second
+	}()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte("sync:\n  interval: 10m\n"), 0o644))
+	assert.NoError(t, InitConfig(path))
+
+	changes, err := Watch(t.Context())
+	assert.NoError(t, err)
+
+	// Files replaced by a rename, as editors and Kubernetes do, are followed
+	tmp := path + ".tmp"
+	assert.NoError(t, os.WriteFile(tmp, []byte("sync:\n  interval: 5m\n"), 0o644))
+	assert.NoError(t, os.Rename(tmp, path))
+
+	select {
+	case <-changes:
+	case <-time.After(5 * time.Second):
+		t.Fatal("configuration change was not notified")
+	}
+}
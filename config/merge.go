@@ -0,0 +1,49 @@
+package config
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// MergeYAML merges YAML files, later files overriding the keys of earlier ones. Maps are merged recursively,
+// other values are replaced.
+func MergeYAML(files []string) ([]byte, error) {
+	base := make(map[string]interface{})
+
+	for _, fname := range files {
+		data, err := os.ReadFile(fname)
+		if err != nil {
+			return nil, err
+		}
+
+		currentMap := make(map[string]interface{})
+		if err := yaml.Unmarshal(data, &currentMap); err != nil {
+			return nil, fmt.Errorf("%s: %w", fname, err)
+		}
+
+		base = mergeMaps(base, currentMap)
+	}
+
+	return yaml.Marshal(base)
+}
+
+func mergeMaps(a, b map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(a))
+	for k, v := range a {
+		out[k] = v
+	}
+	for k, v := range b {
+		if v, ok := v.(map[string]interface{}); ok {
+			if bv, ok := out[k]; ok {
+				if bv, ok := bv.(map[string]interface{}); ok {
+					out[k] = mergeMaps(bv, v)
+					continue
+				}
+			}
+		}
+		out[k] = v
+	}
+	return out
+}
@@ -13,6 +13,29 @@ var (
 		WithDefaultValue(100),
 		WithValidInt())
 
+	// SyncS3GCMinAge is the minimum age of an unreachable object before purge deletes it, so the objects of a push
+	// that has not uploaded its tag manifest yet are kept.
+	SyncS3GCMinAge = NewKey("sync.s3.gc.minAge",
+		WithDefaultValue(time.Hour),
+		WithValidDuration())
+
+	// SyncS3GCLock serializes garbage collection and pushes to a repository with lock objects in the bucket. It is
+	// enabled by default, as sync.s3.gc.minAge doesn't protect blobs an in-flight push reuses.
+	SyncS3GCLock = NewKey("sync.s3.gc.lock",
+		WithDefaultValue(true),
+		WithValidBool())
+
+	// SyncS3GCLockTimeout is the age after which a lock object that was not refreshed is considered abandoned.
+	SyncS3GCLockTimeout = NewKey("sync.s3.gc.lockTimeout",
+		WithDefaultValue(10*time.Minute),
+		WithValidDuration())
+
+	// SyncS3RegistryIndex writes the v2/<repository>/tags/list and v2/_catalog objects after each sync, so
+	// clients can list the tags and repositories of buckets.
+	SyncS3RegistryIndex = NewKey("sync.s3.registryIndex",
+		WithDefaultValue(true),
+		WithValidBool())
+
 	// SyncS3ObjectCacheEnabled enables S3 object-seem cache.
 	SyncS3ObjectCacheEnabled = NewKey("sync.s3.objectCache.enabled",
 		WithDefaultValue(true),
@@ -0,0 +1,139 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+// jsonSchema is the subset of JSON Schema used to describe the configuration.
+type jsonSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	AdditionalProperties interface{}            `json:"additionalProperties,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	Enum                 []string               `json:"enum,omitempty"`
+	Default              interface{}            `json:"default,omitempty"`
+}
+
+// Schema returns a JSON Schema of the configuration file, generated from the registered keys, for editors.
+func Schema() ([]byte, error) {
+	root := &jsonSchema{
+		Schema:               "https://json-schema.org/draft/2020-12/schema",
+		Title:                "docker-sync configuration",
+		Type:                 "object",
+		Properties:           make(map[string]*jsonSchema),
+		AdditionalProperties: false,
+	}
+
+	names := make([]string, 0, len(keys))
+	for name := range keys {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		fields := strings.Split(name, ".")
+
+		parent := root
+		for _, field := range fields[:len(fields)-1] {
+			child, ok := parent.Properties[field]
+			if !ok || child.Properties == nil {
+				child = &jsonSchema{
+					Type:                 "object",
+					Properties:           make(map[string]*jsonSchema),
+					AdditionalProperties: false,
+				}
+				parent.Properties[field] = child
+			}
+
+			parent = child
+		}
+
+		parent.Properties[fields[len(fields)-1]] = keySchema(keys[name])
+	}
+
+	return json.MarshalIndent(root, "", "  ")
+}
+
+func keySchema(k *Key) *jsonSchema {
+	if t, ok := keyTypes[k.Name]; ok {
+		s := typeSchema(t)
+		s.Default = k.Default
+
+		return s
+	}
+
+	s := &jsonSchema{
+		Enum:    k.AllowedValues,
+		Default: k.Default,
+	}
+
+	switch d := k.Default.(type) {
+	case bool:
+		s.Type = "boolean"
+	case int, int64, uint64:
+		s.Type = "integer"
+	case float64:
+		s.Type = "number"
+	case time.Duration:
+		s.Type = "string"
+		s.Default = d.String()
+	default:
+		s.Type = "string"
+	}
+
+	return s
+}
+
+// typeSchema describes a type by its JSON encoding.
+func typeSchema(t reflect.Type) *jsonSchema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		s := &jsonSchema{
+			Type:                 "object",
+			Properties:           make(map[string]*jsonSchema),
+			AdditionalProperties: false,
+		}
+
+		for i := range t.NumField() {
+			field := t.Field(i)
+
+			tag, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if tag == "-" || !field.IsExported() {
+				continue
+			}
+			if tag == "" {
+				tag = field.Name
+			}
+
+			s.Properties[tag] = typeSchema(field.Type)
+		}
+
+		return s
+	case reflect.Slice:
+		return &jsonSchema{
+			Type:  "array",
+			Items: typeSchema(t.Elem()),
+		}
+	case reflect.Map:
+		return &jsonSchema{
+			Type:                 "object",
+			AdditionalProperties: typeSchema(t.Elem()),
+		}
+	case reflect.Bool:
+		return &jsonSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int64, reflect.Uint64:
+		return &jsonSchema{Type: "integer"}
+	default:
+		return &jsonSchema{Type: "string"}
+	}
+}
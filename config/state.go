@@ -0,0 +1,21 @@
+package config
+
+import "time"
+
+var (
+	// SyncStateBackend is where the digest last synced for each tag and target is stored: none, file or bucket.
+	SyncStateBackend = NewKey("sync.state.backend",
+		WithDefaultValue("none"),
+		WithAllowedStrings([]string{"none", "file", "bucket"}))
+
+	// SyncStatePath is the state file, or the state object as <r2|s3>:<region/endpoint>:<bucket>:<key> with the
+	// bucket backend.
+	SyncStatePath = NewKey("sync.state.path",
+		WithDefaultValue("docker-sync-state.json"),
+		WithValidString())
+
+	// SyncStateTTL is the age after which a state entry is checked against the target again. Zero means never.
+	SyncStateTTL = NewKey("sync.state.ttl",
+		WithDefaultValue(24*time.Hour),
+		WithValidDuration())
+)
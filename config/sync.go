@@ -13,6 +13,52 @@ var (
 		WithDefaultValue("30m"),
 		WithValidDuration())
 
+	// SyncDrainTimeout is how long syncs in progress are given to finish on shutdown before they are aborted.
+	SyncDrainTimeout = NewKey("sync.drainTimeout",
+		WithDefaultValue("5m"),
+		WithValidDuration())
+
+	// SyncMaxConcurrentImages is the maximum number of images synchronized at the same time.
+	SyncMaxConcurrentImages = NewKey("sync.maxConcurrentImages",
+		WithDefaultValue(1),
+		WithValidPositiveInt())
+
+	// SyncMaxConcurrentTags is the maximum number of tags of a single image synchronized at the same time.
+	SyncMaxConcurrentTags = NewKey("sync.maxConcurrentTags",
+		WithDefaultValue(1),
+		WithValidPositiveInt())
+
+	// SyncMaxConcurrentPerSourceRegistry is the maximum number of concurrent copies from a single source
+	// registry. Zero means unlimited.
+	SyncMaxConcurrentPerSourceRegistry = NewKey("sync.maxConcurrentPerSourceRegistry",
+		WithDefaultValue(0),
+		WithValidPositiveInt())
+
+	// SyncMaxConcurrentPerTargetRegistry is the maximum number of concurrent copies to a single target
+	// registry or bucket. Zero means unlimited.
+	SyncMaxConcurrentPerTargetRegistry = NewKey("sync.maxConcurrentPerTargetRegistry",
+		WithDefaultValue(0),
+		WithValidPositiveInt())
+
+	// SyncMaxConcurrentCopies is the maximum number of concurrent copies of all images and tags. Zero means
+	// unlimited, bounded only by maxConcurrentImages × maxConcurrentTags × targets.
+	SyncMaxConcurrentCopies = NewKey("sync.maxConcurrentCopies",
+		WithDefaultValue(0),
+		WithValidPositiveInt())
+
+	// SyncCompareDigests skips mutable tags whose source digest matches the target digest.
+	SyncCompareDigests = NewKey("sync.compareDigests",
+		WithDefaultValue(true),
+		WithValidBool())
+
+	// SyncPolicy is the signature policy source images must satisfy before they are copied, unless overridden
+	// by the image.
+	SyncPolicy = NewKey("sync.policy",
+		WithDefaultValue(map[string]interface{}{
+			"type": "insecureAcceptAnything",
+		}),
+		WithValidSignaturePolicy())
+
 	// SyncRegistries specifies the repositories to use for pulling and pushing images.
 	SyncRegistries = NewKey("sync.registries",
 		WithDefaultValue([]map[string]interface{}{
@@ -7,10 +7,12 @@ import (
 	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/Altinity/docker-sync/structs"
+	"github.com/robfig/cron/v3"
 	"github.com/spf13/cast"
 	"github.com/spf13/viper"
 )
@@ -20,7 +22,9 @@ type Key struct {
 	Default         interface{}
 	Value           interface{}
 	ValidationFuncs []func(interface{}) error
-	mutex           sync.Mutex
+	// AllowedValues are the values accepted by WithAllowedStrings, listed in the JSON Schema.
+	AllowedValues []string
+	mutex         sync.Mutex
 }
 
 type KeyOption func(*Key)
@@ -123,6 +127,29 @@ func (k *Key) Images() []*structs.Image {
 	return images
 }
 
+func (k *Key) SignaturePolicy() *structs.SignaturePolicy {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	m, err := cast.ToStringMapE(k.Value)
+	if err != nil {
+		return nil
+	}
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil
+	}
+
+	var policy structs.SignaturePolicy
+
+	if err := json.Unmarshal(b, &policy); err != nil {
+		return nil
+	}
+
+	return &policy
+}
+
 func (k *Key) Repositories() []*structs.Repository {
 	k.mutex.Lock()
 	defer k.mutex.Unlock()
@@ -226,7 +253,7 @@ func WithValidationFunc(f func(interface{}) error) KeyOption {
 
 // WithAllowedStrings sets the allowed values for the configuration key.
 func WithAllowedStrings(values []string) KeyOption {
-	return WithValidationFunc(func(v interface{}) error {
+	validate := WithValidationFunc(func(v interface{}) error {
 		s, err := cast.ToStringE(v)
 		if err != nil {
 			return err
@@ -240,6 +267,11 @@ func WithAllowedStrings(values []string) KeyOption {
 
 		return fmt.Errorf("value %q is not allowed, must be one of %v", s, values)
 	})
+
+	return func(k *Key) {
+		k.AllowedValues = values
+		validate(k)
+	}
 }
 
 // WithAllowedInts sets the allowed values for the configuration key.
@@ -475,16 +507,111 @@ func WithValidImages() KeyOption {
 				return fmt.Errorf("source is required")
 			}
 
+			if strings.HasPrefix(image.Source, "r2:") || strings.HasPrefix(image.Source, "s3:") {
+				if fields := strings.Split(image.Source, ":"); len(fields) != 4 {
+					return fmt.Errorf("invalid source %q, format is <r2|s3>:<region/endpoint>:<bucket>:<image>", image.Source)
+				}
+			}
+
 			if len(image.Targets) == 0 {
 				return fmt.Errorf("at least one target is required")
 			}
 
+			switch image.ImmutableTagDrift {
+			case "", "ignore", "warn", "resync":
+			default:
+				return fmt.Errorf("invalid immutableTagDrift %q, must be one of [ignore warn resync]", image.ImmutableTagDrift)
+			}
+
+			switch image.PlatformIndex {
+			case "", "preserve", "rewrite":
+			default:
+				return fmt.Errorf("invalid platformIndex %q, must be one of [preserve rewrite]", image.PlatformIndex)
+			}
+
+			for _, platform := range image.Platforms {
+				if fields := strings.Split(platform, "/"); len(fields) < 2 || len(fields) > 3 {
+					return fmt.Errorf("invalid platform %q, format is <os>/<arch>[/<variant>]", platform)
+				}
+			}
+
+			if image.Policy != nil {
+				if err := validateSignaturePolicy(image.Policy); err != nil {
+					return fmt.Errorf("invalid policy for %s: %w", image.Source, err)
+				}
+			}
+
+			if image.Interval != "" && image.Schedule != "" {
+				return fmt.Errorf("interval and schedule are mutually exclusive for %s", image.Source)
+			}
+
+			if image.Interval != "" {
+				if d, err := time.ParseDuration(image.Interval); err != nil || d <= 0 {
+					return fmt.Errorf("invalid interval %q for %s, must be a positive duration", image.Interval, image.Source)
+				}
+			}
+
+			if image.Schedule != "" {
+				if _, err := cron.ParseStandard(image.Schedule); err != nil {
+					return fmt.Errorf("invalid schedule %q for %s: %w", image.Schedule, image.Source, err)
+				}
+			}
+
 		}
 
 		return nil
 	})
 }
 
+// WithValidSignaturePolicy checks if the value is a valid signature policy.
+func WithValidSignaturePolicy() KeyOption {
+	return WithValidationFunc(func(v interface{}) error {
+		m, err := cast.ToStringMapE(v)
+		if err != nil {
+			return err
+		}
+
+		b, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+
+		var policy structs.SignaturePolicy
+
+		if err := json.Unmarshal(b, &policy); err != nil {
+			return err
+		}
+
+		return validateSignaturePolicy(&policy)
+	})
+}
+
+func validateSignaturePolicy(policy *structs.SignaturePolicy) error {
+	if policy.Path != "" {
+		if _, err := os.Stat(policy.Path); err != nil {
+			return fmt.Errorf("invalid policy path: %w", err)
+		}
+
+		return nil
+	}
+
+	switch policy.Type {
+	case "", "insecureAcceptAnything":
+	case "sigstoreSigned", "signedBy":
+		if policy.KeyPath == "" {
+			return fmt.Errorf("keyPath is required for %s policies", policy.Type)
+		}
+
+		if _, err := os.Stat(policy.KeyPath); err != nil {
+			return fmt.Errorf("invalid keyPath: %w", err)
+		}
+	default:
+		return fmt.Errorf("invalid policy type %q, must be one of [insecureAcceptAnything sigstoreSigned signedBy]", policy.Type)
+	}
+
+	return nil
+}
+
 // WithValidRepositories checks if the value is a valid repository.
 func WithValidRepositories() KeyOption {
 	return WithValidationFunc(func(v interface{}) error {
@@ -517,6 +644,38 @@ func WithValidRepositories() KeyOption {
 			if repo.URL == "" {
 				return fmt.Errorf("url is required")
 			}
+
+			if repo.S3 != nil {
+				if repo.S3.Endpoint != "" {
+					u, err := url.Parse(repo.S3.Endpoint)
+					if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+						return fmt.Errorf("invalid s3 endpoint %q for %s, must be a http(s) URL", repo.S3.Endpoint, repo.Name)
+					}
+				}
+
+				if repo.S3.CAFile != "" {
+					if _, err := os.Stat(repo.S3.CAFile); err != nil {
+						return fmt.Errorf("invalid s3 caFile for %s: %w", repo.Name, err)
+					}
+				}
+
+				switch repo.S3.ACL {
+				case "", "none", "private", "public-read", "public-read-write", "authenticated-read",
+					"aws-exec-read", "bucket-owner-read", "bucket-owner-full-control":
+				default:
+					return fmt.Errorf("invalid s3 acl %q for %s", repo.S3.ACL, repo.Name)
+				}
+
+				switch repo.S3.ServerSideEncryption {
+				case "", "AES256", "aws:kms", "aws:kms:dsse":
+				default:
+					return fmt.Errorf("invalid s3 serverSideEncryption %q for %s, must be one of [AES256 aws:kms aws:kms:dsse]", repo.S3.ServerSideEncryption, repo.Name)
+				}
+
+				if repo.S3.KMSKeyID != "" && !strings.HasPrefix(repo.S3.ServerSideEncryption, "aws:kms") {
+					return fmt.Errorf("s3 kmsKeyId for %s requires serverSideEncryption aws:kms", repo.Name)
+				}
+			}
 		}
 
 		return nil
@@ -1,6 +1,8 @@
 package config
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -197,6 +199,10 @@ func TestWithValidImages(t *testing.T) {
 		validImages := []map[string]interface{}{
 			{"source": "src1", "targets": []string{"target1"}},
 			{"source": "src2", "targets": []string{"target2", "target3"}},
+			{"source": "r2:account:bucket:altinity/image", "targets": []string{"target1"}},
+			{"source": "oci:/srv/mirror/image", "targets": []string{"target1"}},
+			{"source": "src3", "targets": []string{"target1"}, "interval": "5m"},
+			{"source": "src4", "targets": []string{"target1"}, "schedule": "0 */6 * * *"},
 		}
 		err := k.ValidationFuncs[0](validImages)
 		assert.NoError(t, err)
@@ -219,6 +225,42 @@ func TestWithValidImages(t *testing.T) {
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "at least one target is required")
 	})
+
+	t.Run("Invalid Images - Unknown ImmutableTagDrift", func(t *testing.T) {
+		invalidImages := []map[string]interface{}{
+			{"source": "src1", "targets": []string{"target1"}, "immutableTagDrift": "fail"},
+		}
+		err := k.ValidationFuncs[0](invalidImages)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid immutableTagDrift")
+	})
+
+	t.Run("Invalid Images - Schedule", func(t *testing.T) {
+		invalidImages := []map[string]interface{}{
+			{"source": "src1", "targets": []string{"target1"}, "schedule": "every day"},
+		}
+		err := k.ValidationFuncs[0](invalidImages)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid schedule")
+	})
+
+	t.Run("Invalid Images - Interval and Schedule", func(t *testing.T) {
+		invalidImages := []map[string]interface{}{
+			{"source": "src1", "targets": []string{"target1"}, "interval": "5m", "schedule": "@weekly"},
+		}
+		err := k.ValidationFuncs[0](invalidImages)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "mutually exclusive")
+	})
+
+	t.Run("Invalid Images - Bucket Source", func(t *testing.T) {
+		invalidImages := []map[string]interface{}{
+			{"source": "s3:us-east-1:bucket", "targets": []string{"target1"}},
+		}
+		err := k.ValidationFuncs[0](invalidImages)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid source")
+	})
 }
 
 func TestWithValidRepositories(t *testing.T) {
@@ -243,6 +285,24 @@ func TestWithValidRepositories(t *testing.T) {
 		assert.Contains(t, err.Error(), "name is required")
 	})
 
+	t.Run("Invalid Repositories - S3 Endpoint", func(t *testing.T) {
+		invalidRepos := []map[string]interface{}{
+			{"name": "minio", "url": "s3:minio:bucket", "s3": map[string]interface{}{"endpoint": "minio:9000"}},
+		}
+		err := k.ValidationFuncs[0](invalidRepos)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid s3 endpoint")
+	})
+
+	t.Run("Invalid Repositories - S3 Encryption", func(t *testing.T) {
+		invalidRepos := []map[string]interface{}{
+			{"name": "s3", "url": "s3:us-east-1:bucket", "s3": map[string]interface{}{"kmsKeyId": "alias/docker-sync"}},
+		}
+		err := k.ValidationFuncs[0](invalidRepos)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "requires serverSideEncryption aws:kms")
+	})
+
 	t.Run("Invalid Repositories - Missing URL", func(t *testing.T) {
 		invalidRepos := []map[string]interface{}{
 			{"name": "repo1"},
@@ -253,8 +313,36 @@ func TestWithValidRepositories(t *testing.T) {
 	})
 }
 
+func TestWithValidSignaturePolicy(t *testing.T) {
+	k := &Key{}
+	WithValidSignaturePolicy()(k)
+
+	keyPath := filepath.Join(t.TempDir(), "cosign.pub")
+	assert.NoError(t, os.WriteFile(keyPath, []byte("key"), 0o644))
+
+	t.Run("Valid Policies", func(t *testing.T) {
+		assert.NoError(t, k.ValidationFuncs[0](map[string]interface{}{"type": "insecureAcceptAnything"}))
+		assert.NoError(t, k.ValidationFuncs[0](map[string]interface{}{"type": "sigstoreSigned", "keyPath": keyPath}))
+		assert.NoError(t, k.ValidationFuncs[0](map[string]interface{}{"path": keyPath}))
+	})
+
+	t.Run("Invalid Policy - Unknown Type", func(t *testing.T) {
+		err := k.ValidationFuncs[0](map[string]interface{}{"type": "reject"})
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid policy type")
+	})
+
+	t.Run("Invalid Policy - Missing Key", func(t *testing.T) {
+		err := k.ValidationFuncs[0](map[string]interface{}{"type": "signedBy"})
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "keyPath is required")
+	})
+}
+
 func TestKey_register(t *testing.T) {
 	viper.Reset()
+	registered := keys
+	t.Cleanup(func() { keys = registered })
 	keys = make(map[string]*Key)
 
 	k := &Key{
@@ -271,6 +359,8 @@ func TestKey_register(t *testing.T) {
 
 func TestNewKey_Integration(t *testing.T) {
 	viper.Reset()
+	registered := keys
+	t.Cleanup(func() { keys = registered })
 	keys = make(map[string]*Key)
 
 	k := NewKey("test_key",
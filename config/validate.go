@@ -0,0 +1,322 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/Altinity/docker-sync/structs"
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// keyTypes are the types of the keys holding structured values, used to check their fields and to generate
+// their JSON Schema.
+var keyTypes = map[string]reflect.Type{
+	"sync.images":     reflect.TypeOf([]structs.Image{}),
+	"sync.registries": reflect.TypeOf([]structs.Repository{}),
+	"sync.policy":     reflect.TypeOf(structs.SignaturePolicy{}),
+}
+
+// CheckFiles strictly checks the configuration files read by InitConfig, including files it failed to parse. See
+// Check.
+func CheckFiles() ([]string, error) {
+	if len(configFiles) == 0 {
+		return nil, errors.New("no configuration file found")
+	}
+
+	if mergeConfig {
+		data, err := MergeYAML(configFiles)
+		if err != nil {
+			return nil, err
+		}
+
+		return Check(data, "yaml")
+	}
+
+	data, err := os.ReadFile(configFiles[0])
+	if err != nil {
+		return nil, err
+	}
+
+	configType := strings.TrimPrefix(filepath.Ext(configFiles[0]), ".")
+	if configType == "" {
+		configType = "yaml"
+	}
+
+	warnings, err := Check(data, configType)
+
+	// Syntax errors give the line in the file
+	var parseErr viper.ConfigParseError
+	if errors.As(err, &parseErr) {
+		return nil, fmt.Errorf("%s: %w", configFiles[0], err)
+	}
+
+	return warnings, err
+}
+
+// Check strictly checks a configuration. Besides the validation of each key, unknown keys and fields, source
+// and target formats, tag patterns and missing registry credentials are reported. Registries without a
+// sync.registries entry use the default keychain, so they are returned as warnings.
+func Check(data []byte, configType string) ([]string, error) {
+	v, err := newViper(data, configType)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	var warnings []string
+
+	if err := Validate(v); err != nil {
+		errs = append(errs, err)
+	}
+
+	switch configType {
+	case "yaml", "yml", "json":
+		raw := make(map[string]interface{})
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return nil, fmt.Errorf("failed to parse configuration: %w", err)
+		}
+
+		errs = append(errs, unknownKeys(raw, "")...)
+	}
+
+	var images []structs.Image
+	var registries []structs.Repository
+
+	if err := decodeKey(v, SyncImages.Name, &images); err != nil {
+		errs = append(errs, fmt.Errorf("%s: %w", SyncImages.Name, err))
+	}
+	if err := decodeKey(v, SyncRegistries.Name, &registries); err != nil {
+		errs = append(errs, fmt.Errorf("%s: %w", SyncRegistries.Name, err))
+	}
+
+	for i, image := range images {
+		path := fmt.Sprintf("%s[%d]", SyncImages.Name, i)
+
+		if image.Source != "" {
+			if err := checkReference(image.Source); err != nil {
+				errs = append(errs, fmt.Errorf("%s.source: %w", path, err))
+			}
+		}
+
+		for j, target := range image.Targets {
+			if err := checkReference(target); err != nil {
+				errs = append(errs, fmt.Errorf("%s.targets[%d]: %w", path, j, err))
+				continue
+			}
+
+			warning, err := checkAuth(&image, target, registries)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s.targets[%d]: %w", path, j, err))
+			}
+			if warning != "" {
+				warnings = append(warnings, fmt.Sprintf("%s.targets[%d]: %s", path, j, warning))
+			}
+		}
+
+		for _, field := range []struct {
+			name     string
+			patterns []string
+		}{
+			{"tags", image.Tags},
+			{"mutableTags", image.MutableTags},
+			{"ignoredTags", image.IgnoredTags},
+		} {
+			for j, pattern := range field.patterns {
+				if _, err := filepath.Match(pattern, ""); err != nil {
+					errs = append(errs, fmt.Errorf("%s.%s[%d]: invalid pattern %q", path, field.name, j, pattern))
+				}
+			}
+		}
+	}
+
+	slices.Sort(warnings)
+
+	return slices.Compact(warnings), errors.Join(errs...)
+}
+
+func decodeKey(v *viper.Viper, name string, out interface{}) error {
+	b, err := json.Marshal(v.Get(name))
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, out)
+}
+
+// unknownKeys reports the keys of a configuration that are not registered. Keys are matched case-insensitively,
+// as viper does.
+func unknownKeys(m map[string]interface{}, prefix string) []error {
+	var errs []error
+
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		path := name
+		if prefix != "" {
+			path = prefix + "." + name
+		}
+
+		var key *Key
+		var parent bool
+
+		for k := range keys {
+			switch {
+			case strings.EqualFold(k, path):
+				key = keys[k]
+			case len(k) > len(path) && strings.EqualFold(k[:len(path)+1], path+"."):
+				parent = true
+			}
+		}
+
+		switch {
+		case key != nil:
+			if t, ok := keyTypes[key.Name]; ok {
+				errs = append(errs, unknownFields(m[name], t, key.Name)...)
+			}
+		case parent:
+			if child, ok := m[name].(map[string]interface{}); ok {
+				errs = append(errs, unknownKeys(child, path)...)
+			}
+		default:
+			errs = append(errs, fmt.Errorf("unknown key %q", path))
+		}
+	}
+
+	return errs
+}
+
+// unknownFields reports the fields of a structured value that don't exist in its type. Fields are matched
+// case-insensitively, as encoding/json does.
+func unknownFields(v interface{}, t reflect.Type, path string) []error {
+	var errs []error
+
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+
+		names := make([]string, 0, len(m))
+		for name := range m {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+
+		for _, name := range names {
+			field, ok := structField(t, name)
+			if !ok {
+				errs = append(errs, fmt.Errorf("unknown field %q", path+"."+name))
+				continue
+			}
+
+			errs = append(errs, unknownFields(m[name], field.Type, path+"."+name)...)
+		}
+	case reflect.Slice:
+		s, ok := v.([]interface{})
+		if !ok {
+			return nil
+		}
+
+		for i, item := range s {
+			errs = append(errs, unknownFields(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	}
+
+	return errs
+}
+
+// structField returns the field of a struct decoded from a JSON name.
+func structField(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := range t.NumField() {
+		field := t.Field(i)
+
+		tag, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if tag == "-" || !field.IsExported() {
+			continue
+		}
+		if tag == "" {
+			tag = field.Name
+		}
+
+		if strings.EqualFold(tag, name) {
+			return field, true
+		}
+	}
+
+	return reflect.StructField{}, false
+}
+
+// checkReference checks the format of a source or target.
+func checkReference(ref string) error {
+	switch {
+	case strings.HasPrefix(ref, "r2:") || strings.HasPrefix(ref, "s3:"):
+		fields := strings.Split(ref, ":")
+		if len(fields) != 4 || slices.Contains(fields, "") {
+			return fmt.Errorf("invalid bucket %q, format is <r2|s3>:<region/endpoint>:<bucket>:<image>", ref)
+		}
+	case strings.HasPrefix(ref, "oci:"):
+		if strings.TrimPrefix(ref, "oci:") == "" {
+			return fmt.Errorf("invalid layout %q, format is oci:<path>", ref)
+		}
+	default:
+		named, err := reference.ParseNormalizedNamed(ref)
+		if err != nil {
+			return fmt.Errorf("invalid repository %q: %w", ref, err)
+		}
+
+		if _, ok := named.(reference.Tagged); ok {
+			return fmt.Errorf("invalid repository %q, tags are selected with tags", ref)
+		}
+		if _, ok := named.(reference.Digested); ok {
+			return fmt.Errorf("invalid repository %q, digests are not supported", ref)
+		}
+	}
+
+	return nil
+}
+
+// checkAuth looks up the sync.registries entry of a target, the same way the sync does. Buckets can't be
+// accessed without credentials, registries fall back to the default keychain.
+func checkAuth(image *structs.Image, target string, registries []structs.Repository) (string, error) {
+	switch {
+	case strings.HasPrefix(target, "oci:"):
+		return "", nil
+	case strings.HasPrefix(target, "r2:") || strings.HasPrefix(target, "s3:"):
+		url := strings.Join(strings.Split(target, ":")[:3], ":")
+
+		for _, r := range registries {
+			if r.URL == url && r.Auth.Username != "" && r.Auth.Password != "" {
+				return "", nil
+			}
+		}
+
+		return "", fmt.Errorf("no sync.registries entry with a username and password for %s", url)
+	default:
+		url := image.GetRegistry(target)
+
+		for _, r := range registries {
+			if r.URL == url {
+				return "", nil
+			}
+		}
+
+		return fmt.Sprintf("no sync.registries entry for %s, the default keychain is used", url), nil
+	}
+}
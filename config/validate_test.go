@@ -0,0 +1,159 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheck(t *testing.T) {
+	registries := `
+  registries:
+    - name: R2
+      url: r2:account:bucket
+      auth:
+        username: key
+        password: secret
+    - name: GHCR
+      url: ghcr.io
+`
+
+	testCases := []struct {
+		name     string
+		images   string
+		errors   []string
+		warnings []string
+	}{
+		{
+			name: "Valid",
+			images: `
+    - source: docker.io/library/ubuntu
+      targets:
+        - r2:account:bucket:ubuntu
+        - ghcr.io/altinity/ubuntu
+        - oci:/srv/mirror/ubuntu
+      mutableTags: ["latest", "2*"]
+`,
+		},
+		{
+			name: "Unknown field",
+			images: `
+    - source: docker.io/library/ubuntu
+      targets: [ghcr.io/altinity/ubuntu]
+      mutabletag: [latest]
+`,
+			errors: []string{`unknown field "sync.images[0].mutabletag"`},
+		},
+		{
+			name: "Invalid targets",
+			images: `
+    - source: docker.io/library/ubuntu
+      targets:
+        - r2:account:bucket
+        - ghcr.io/altinity/ubuntu:latest
+`,
+			errors: []string{
+				`sync.images[0].targets[0]: invalid bucket "r2:account:bucket"`,
+				`sync.images[0].targets[1]: invalid repository "ghcr.io/altinity/ubuntu:latest"`,
+			},
+		},
+		{
+			name: "Missing auth",
+			images: `
+    - source: docker.io/library/ubuntu
+      targets:
+        - s3:us-east-1:bucket:ubuntu
+        - quay.io/altinity/ubuntu
+`,
+			errors:   []string{"no sync.registries entry with a username and password for s3:us-east-1:bucket"},
+			warnings: []string{"sync.images[0].targets[1]: no sync.registries entry for quay.io, the default keychain is used"},
+		},
+		{
+			name: "Invalid pattern",
+			images: `
+    - source: docker.io/library/ubuntu
+      targets: [ghcr.io/altinity/ubuntu]
+      tags: ["[0-9"]
+`,
+			errors: []string{`sync.images[0].tags[0]: invalid pattern "[0-9"`},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			warnings, err := Check([]byte("sync:\n"+registries+"  images:\n"+tc.images), "yaml")
+
+			if len(tc.errors) == 0 {
+				assert.NoError(t, err)
+			}
+			for _, e := range tc.errors {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), e)
+				}
+			}
+
+			assert.Equal(t, tc.warnings, warnings)
+		})
+	}
+
+	t.Run("Unknown key", func(t *testing.T) {
+		_, err := Check([]byte("sync:\n  maxErrrors: 3\n  s3:\n    gc:\n      minAge: 1h\n"), "yaml")
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), `unknown key "sync.maxErrrors"`)
+		assert.NotContains(t, err.Error(), "minAge")
+	})
+
+	t.Run("Invalid value", func(t *testing.T) {
+		_, err := Check([]byte("sync:\n  interval: often\n"), "yaml")
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "sync.interval")
+	})
+}
+
+func TestCheckFiles(t *testing.T) {
+	resetConfig(t)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	secret := filepath.Join(dir, "secret.yaml")
+
+	// Files that can't be parsed are still checked, and their errors give the file and line
+	assert.NoError(t, os.WriteFile(path, []byte("sync:\n  images:\n    - source: [\n"), 0o644))
+	assert.Error(t, InitConfig(path))
+
+	_, err := CheckFiles()
+	assert.ErrorContains(t, err, path+": ")
+	assert.ErrorContains(t, err, "line 3")
+
+	assert.NoError(t, os.WriteFile(path, []byte("sync:\n  interval: 5m\n"), 0o644))
+	assert.NoError(t, os.WriteFile(secret, []byte("sync:\n x: 1\n  y: 2\n"), 0o644))
+	assert.Error(t, InitConfig("", path, secret))
+
+	_, err = CheckFiles()
+	assert.ErrorContains(t, err, secret+": ")
+	assert.ErrorContains(t, err, "line 3")
+}
+
+func TestSchema(t *testing.T) {
+	b, err := Schema()
+	assert.NoError(t, err)
+
+	var schema jsonSchema
+	assert.NoError(t, json.Unmarshal(b, &schema))
+
+	sync := schema.Properties["sync"]
+	if assert.NotNil(t, sync) {
+		assert.Equal(t, "string", sync.Properties["interval"].Type)
+		assert.Equal(t, "integer", sync.Properties["maxErrors"].Type)
+		assert.Equal(t, []string{"none", "file", "bucket"}, sync.Properties["state"].Properties["backend"].Enum)
+
+		images := sync.Properties["images"]
+		assert.Equal(t, "array", images.Type)
+		assert.Equal(t, "array", images.Items.Properties["mutableTags"].Type)
+		assert.Equal(t, "boolean", images.Items.Properties["purge"].Type)
+		assert.NotContains(t, images.Items.Properties, "SrcRef")
+	}
+}
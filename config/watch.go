@@ -0,0 +1,100 @@
+package config
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+)
+
+// watchDebounce is how long Watch waits for more events before notifying a change, as editors and Kubernetes
+// replace files in several steps.
+var watchDebounce = time.Second
+
+// Watch notifies changes of the configuration files on the returned channel until ctx is canceled. The parent
+// directories are watched, so files replaced by a rename or mounted from a ConfigMap or Secret are followed too.
+// The channel is nil if the configuration was not read from files.
+func Watch(ctx context.Context) (<-chan struct{}, error) {
+	if len(configFiles) == 0 {
+		return nil, nil
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]string, 0, len(configFiles))
+	// realPaths are the targets of the files, which change when Kubernetes swaps its ..data symlink
+	realPaths := make(map[string]string)
+	var dirs []string
+
+	for _, f := range configFiles {
+		f, err := filepath.Abs(f)
+		if err != nil {
+			watcher.Close()
+			return nil, err
+		}
+
+		files = append(files, f)
+		realPaths[f], _ = filepath.EvalSymlinks(f)
+
+		if dir := filepath.Dir(f); !slices.Contains(dirs, dir) {
+			if err := watcher.Add(dir); err != nil {
+				watcher.Close()
+				return nil, err
+			}
+
+			dirs = append(dirs, dir)
+		}
+	}
+
+	changes := make(chan struct{}, 1)
+
+	go func() {
+		defer watcher.Close()
+
+		var debounce <-chan time.Time
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+
+				for _, f := range files {
+					realPath, _ := filepath.EvalSymlinks(f)
+
+					if (filepath.Clean(event.Name) == f && event.Has(fsnotify.Write|fsnotify.Create)) || realPath != realPaths[f] {
+						realPaths[f] = realPath
+						debounce = time.After(watchDebounce)
+					}
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+
+				log.Error().
+					Err(err).
+					Msg("Failed to watch configuration files")
+			case <-debounce:
+				debounce = nil
+
+				// A pending change is enough, it is read when the channel is received from
+				select {
+				case changes <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	return changes, nil
+}
@@ -0,0 +1,290 @@
+package dockersync
+
+import (
+	"context"
+	"errors"
+	"slices"
+	stdsync "sync"
+
+	"github.com/Altinity/docker-sync/internal/admin"
+	"github.com/Altinity/docker-sync/internal/sync"
+	"github.com/rs/zerolog/log"
+)
+
+// errSyncCanceled is the cause of the image syncs canceled through the admin API.
+var errSyncCanceled = errors.New("sync canceled through the admin API")
+
+// syncTracker tracks the image syncs in progress, so they can be canceled and waited for.
+type syncTracker struct {
+	mutex   stdsync.Mutex
+	running map[string][]*trackedSync
+	// waiters are notified when the next sync of a source that starts finishes
+	waiters map[string][]chan struct{}
+}
+
+type trackedSync struct {
+	cancel  context.CancelCauseFunc
+	waiters []chan struct{}
+}
+
+func newSyncTracker() *syncTracker {
+	return &syncTracker{
+		running: make(map[string][]*trackedSync),
+		waiters: make(map[string][]chan struct{}),
+	}
+}
+
+var syncs = newSyncTracker()
+
+// start tracks the sync of an image, returning its context and the function to call once it finished.
+func (t *syncTracker) start(ctx context.Context, source string) (context.Context, func()) {
+	return t.track(ctx, source, true)
+}
+
+// startTag tracks the sync of a pushed tag of an image. The waiters are left to the next sync of the image, as
+// the tag sync doesn't sync the whole image.
+func (t *syncTracker) startTag(ctx context.Context, source string) (context.Context, func()) {
+	return t.track(ctx, source, false)
+}
+
+func (t *syncTracker) track(ctx context.Context, source string, notify bool) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	s := &trackedSync{cancel: cancel}
+	if notify {
+		s.waiters = t.waiters[source]
+		delete(t.waiters, source)
+	}
+
+	t.running[source] = append(t.running[source], s)
+
+	return ctx, func() {
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+
+		t.running[source] = slices.DeleteFunc(t.running[source], func(r *trackedSync) bool {
+			return r == s
+		})
+		if len(t.running[source]) == 0 {
+			delete(t.running, source)
+		}
+
+		for _, w := range s.waiters {
+			close(w)
+		}
+
+		cancel(nil)
+	}
+}
+
+// cancel cancels the syncs in progress of a source.
+func (t *syncTracker) cancel(source string) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for _, s := range t.running[source] {
+		s.cancel(errSyncCanceled)
+	}
+
+	return len(t.running[source]) > 0
+}
+
+// wait returns a channel closed once the next sync of a source that starts finishes.
+func (t *syncTracker) wait(source string) <-chan struct{} {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	ch := make(chan struct{})
+	t.waiters[source] = append(t.waiters[source], ch)
+
+	return ch
+}
+
+// controller implements the admin API on top of the scheduler of Run.
+type controller struct {
+	// ctx is canceled on shutdown, the syncs triggered afterwards would never run
+	ctx context.Context
+	// syncCtx is the context of the tag syncs of push events, drained like the other syncs
+	syncCtx context.Context
+	s       *scheduler
+	// tagSyncs are the tag syncs of push events in progress, which take their slots from the scheduler
+	tagSyncs stdsync.WaitGroup
+}
+
+func newController(ctx context.Context, syncCtx context.Context, s *scheduler) *controller {
+	return &controller{
+		ctx:     ctx,
+		syncCtx: syncCtx,
+		s:       s,
+	}
+}
+
+func (c *controller) Trigger(ctx context.Context, source string, wait bool) ([]string, error) {
+	if c.ctx.Err() != nil {
+		return nil, admin.ErrShuttingDown
+	}
+
+	sources, err := c.s.match(source)
+	if err != nil {
+		return nil, err
+	}
+
+	// Waiters are registered before triggering, so the triggered syncs can't finish first
+	var done []<-chan struct{}
+	if wait {
+		for _, source := range sources {
+			done = append(done, syncs.wait(source))
+		}
+	}
+
+	c.s.trigger(sources)
+
+	for _, ch := range done {
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return nil, context.Cause(ctx)
+		case <-c.ctx.Done():
+			return nil, admin.ErrShuttingDown
+		}
+	}
+
+	return sources, nil
+}
+
+func (c *controller) Pause(source string) error {
+	if err := c.s.pause(source); err != nil {
+		return err
+	}
+
+	admin.SetPaused(source, true)
+
+	return nil
+}
+
+func (c *controller) Resume(source string) error {
+	if err := c.s.resume(source); err != nil {
+		return err
+	}
+
+	admin.SetPaused(source, false)
+
+	return nil
+}
+
+func (c *controller) Cancel(source string) error {
+	if !syncs.cancel(source) {
+		return admin.ErrNotSyncing
+	}
+
+	return nil
+}
+
+func (c *controller) SyncTag(ctx context.Context, repository string, tag string) ([]string, error) {
+	if c.ctx.Err() != nil {
+		return nil, admin.ErrShuttingDown
+	}
+
+	var sources []string
+
+	for _, image := range c.s.matchRepository(repository) {
+		if !sync.SelectsTag(image, tag) {
+			continue
+		}
+
+		// The slot is reserved before starting the sync, so push events can't queue up syncs without bound
+		sem := c.s.semaphore()
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return sources, context.Cause(ctx)
+		case <-c.ctx.Done():
+			return sources, admin.ErrShuttingDown
+		}
+
+		sources = append(sources, image.Source)
+
+		// A tag pushed while its image is being synced could be purged by that sync, so it is synced once the
+		// image finished
+		if !c.s.startTag(image, tag) {
+			<-sem
+			continue
+		}
+
+		c.tagSyncs.Add(1)
+		go func() {
+			defer c.tagSyncs.Done()
+
+			c.syncTags(pushedTags{image: image, tags: []string{tag}}, sem)
+		}()
+	}
+
+	return sources, nil
+}
+
+// syncPushed syncs the tags pushed while their image was being synced, once a sync slot is free.
+func (c *controller) syncPushed(pushed pushedTags) {
+	sem := c.s.semaphore()
+
+	c.tagSyncs.Add(1)
+	go func() {
+		defer c.tagSyncs.Done()
+
+		select {
+		case sem <- struct{}{}:
+		case <-c.syncCtx.Done():
+			c.s.finishTag(pushed.image)
+			return
+		}
+
+		c.syncTags(pushed, sem)
+	}()
+}
+
+// syncTags syncs the pushed tags of an image the scheduler marked as syncing them, in a slot taken from sem.
+func (c *controller) syncTags(pushed pushedTags, sem chan struct{}) {
+	defer c.s.finishTag(pushed.image)
+	defer func() { <-sem }()
+
+	source := pushed.image.Source
+
+	for _, tag := range pushed.tags {
+		if sync.ShuttingDown(c.syncCtx) {
+			return
+		}
+
+		// Tag syncs are tracked as image syncs, so they can be canceled and are listed in the status
+		imageCtx, finish := syncs.startTag(c.syncCtx, source)
+		admin.ImageStarted(source)
+
+		err := sync.SyncImageTag(imageCtx, pushed.image, tag)
+		if canceled(imageCtx) {
+			err = context.Cause(imageCtx)
+		}
+
+		admin.ImageFinished(source, err)
+		finish()
+
+		if err != nil && !canceled(imageCtx) {
+			log.Error().
+				Err(err).
+				Str("source", source).
+				Str("tag", tag).
+				Msg("Failed to sync image tag")
+		}
+	}
+}
+
+// wait waits for the tag syncs of push events in progress.
+func (c *controller) wait() {
+	c.tagSyncs.Wait()
+}
+
+// canceled returns whether the sync of an image was canceled through the admin API, rather than failed.
+func canceled(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errSyncCanceled)
+}
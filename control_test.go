@@ -0,0 +1,158 @@
+package dockersync
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Altinity/docker-sync/internal/admin"
+	"github.com/Altinity/docker-sync/structs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyncTracker(t *testing.T) {
+	tracker := newSyncTracker()
+
+	assert.False(t, tracker.cancel("altinity/image"))
+
+	// Waiters are notified by the next sync that starts
+	done := tracker.wait("altinity/image")
+
+	ctx, finish := tracker.start(t.Context(), "altinity/image")
+	assert.True(t, tracker.cancel("altinity/image"))
+	assert.ErrorIs(t, context.Cause(ctx), errSyncCanceled)
+	assert.True(t, canceled(ctx))
+
+	select {
+	case <-done:
+		t.Fatal("waiter notified before the sync finished")
+	default:
+	}
+
+	finish()
+	<-done
+
+	assert.False(t, tracker.cancel("altinity/image"))
+	assert.Empty(t, tracker.running)
+
+	ctx, finish = tracker.start(t.Context(), "altinity/image")
+	finish()
+	assert.False(t, canceled(ctx))
+
+	// Tag syncs leave the waiters to the next sync of the image
+	done = tracker.wait("altinity/image")
+
+	_, finish = tracker.startTag(t.Context(), "altinity/image")
+	assert.True(t, tracker.cancel("altinity/image"))
+	finish()
+
+	select {
+	case <-done:
+		t.Fatal("waiter notified by a tag sync")
+	default:
+	}
+
+	_, finish = tracker.start(t.Context(), "altinity/image")
+	finish()
+	<-done
+}
+
+func TestControllerTrigger(t *testing.T) {
+	image := &structs.Image{Source: "altinity/image", Interval: "1h"}
+	s := newScheduler([]*structs.Image{image}, time.Now())
+	for _, image := range s.due(time.Now()) {
+		s.finish(t.Context(), image)
+	}
+
+	ctx, stop := context.WithCancel(t.Context())
+	c := newController(ctx, ctx, s)
+
+	// A minimal sync loop
+	loopCtx, stopLoop := context.WithCancel(t.Context())
+	defer stopLoop()
+
+	go func() {
+		for {
+			select {
+			case <-loopCtx.Done():
+				return
+			case <-s.wake:
+			}
+
+			for _, image := range s.due(time.Now()) {
+				_, finish := syncs.start(ctx, image.Source)
+				s.finish(ctx, image)
+				finish()
+			}
+		}
+	}()
+
+	sources, err := c.Trigger(t.Context(), "", true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"altinity/image"}, sources)
+
+	_, err = c.Trigger(t.Context(), "altinity/unknown", false)
+	assert.ErrorIs(t, err, admin.ErrUnknownImage)
+
+	assert.NoError(t, c.Pause("altinity/image"))
+	_, err = c.Trigger(t.Context(), "altinity/image", true)
+	assert.ErrorIs(t, err, admin.ErrImagePaused)
+	assert.NoError(t, c.Resume("altinity/image"))
+
+	assert.ErrorIs(t, c.Cancel("altinity/image"), admin.ErrNotSyncing)
+
+	stop()
+	_, err = c.Trigger(t.Context(), "", false)
+	assert.ErrorIs(t, err, admin.ErrShuttingDown)
+}
+
+func TestControllerSyncTag(t *testing.T) {
+	image := &structs.Image{Source: "docker.io/library/ubuntu", Tags: []string{"24.04"}}
+	s := newScheduler([]*structs.Image{image}, time.Now())
+
+	ctx, stop := context.WithCancel(t.Context())
+	c := newController(ctx, ctx, s)
+
+	// Tags not synced by the image are skipped
+	sources, err := c.SyncTag(t.Context(), "docker.io/library/ubuntu", "nightly")
+	assert.NoError(t, err)
+	assert.Empty(t, sources)
+
+	sources, err = c.SyncTag(t.Context(), "docker.io/library/alpine", "24.04")
+	assert.NoError(t, err)
+	assert.Empty(t, sources)
+
+	// Syncs wait for a slot of the scheduler before they start
+	sem := s.semaphore()
+	for range cap(sem) {
+		sem <- struct{}{}
+	}
+
+	reqCtx, cancel := context.WithCancel(t.Context())
+	cancel()
+	sources, err = c.SyncTag(reqCtx, "docker.io/library/ubuntu", "24.04")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Empty(t, sources)
+
+	for range cap(sem) {
+		<-sem
+	}
+
+	// Tags pushed while the image is being synced are queued until it finishes
+	assert.Equal(t, []*structs.Image{image}, s.due(time.Now()))
+	sources, err = c.SyncTag(t.Context(), "docker.io/library/ubuntu", "24.04")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"docker.io/library/ubuntu"}, sources)
+	assert.Empty(t, s.duePushed())
+	assert.Empty(t, sem)
+
+	s.finish(t.Context(), image)
+	assert.Equal(t, []pushedTags{{image: image, tags: []string{"24.04"}}}, s.duePushed())
+	s.finishTag(image)
+
+	stop()
+	_, err = c.SyncTag(t.Context(), "docker.io/library/ubuntu", "24.04")
+	assert.ErrorIs(t, err, admin.ErrShuttingDown)
+
+	c.wait()
+}
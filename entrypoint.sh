@@ -2,11 +2,8 @@
 
 # check if /config.yaml exists and first argument isn't 'sync'
 if [ ! -f /config.yaml ] && [ "$1" != "sync" ]; then
-  /docker-sync mergeYaml -o /config.yaml -f /config_map.yaml -f /secret.yaml
-  if [ $? -ne 0 ]; then
-    echo "Error merging YAML files. Exiting."
-    exit 1
-  fi
+  # The ConfigMap and Secret files are merged by docker-sync, which reloads them when they change
+  exec /docker-sync "$@" --merge-from /config_map.yaml --merge-from /secret.yaml
 fi
 
-/docker-sync "$@"
+exec /docker-sync "$@"
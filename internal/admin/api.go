@@ -0,0 +1,205 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Altinity/docker-sync/config"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrUnknownImage  = errors.New("unknown image")
+	ErrImagePaused   = errors.New("image is paused")
+	ErrNotSyncing    = errors.New("image is not being synced")
+	ErrShuttingDown  = errors.New("shutting down")
+	ErrNoController  = errors.New("syncs can only be controlled when running continuously")
+	errUnauthorized  = errors.New("unauthorized")
+	errTokenRequired = errors.New("admin API disabled, admin.token is not set")
+)
+
+// Controller controls the syncs of the sync loop.
+type Controller interface {
+	// Trigger syncs the images of source immediately, or all images if source is empty, and returns the sources
+	// triggered. With wait, it returns once they were synced.
+	Trigger(ctx context.Context, source string, wait bool) ([]string, error)
+	// Pause stops syncing the images of a source until they are resumed.
+	Pause(source string) error
+	// Resume syncs the images of a paused source again.
+	Resume(source string) error
+	// Cancel cancels the syncs in progress of the images of a source.
+	Cancel(source string) error
+	// SyncTag syncs a tag of the images whose source is repository, in the background, and returns their sources.
+	// Paused images and images not syncing the tag are skipped. It waits for a sync slot for each image, until ctx
+	// is canceled.
+	SyncTag(ctx context.Context, repository string, tag string) ([]string, error)
+}
+
+var (
+	controllerMutex sync.Mutex
+	controller      Controller
+)
+
+// SetController sets the controller of the API, nil while the sync loop is not running.
+func SetController(c Controller) {
+	controllerMutex.Lock()
+	defer controllerMutex.Unlock()
+
+	controller = c
+}
+
+func getController() Controller {
+	controllerMutex.Lock()
+	defer controllerMutex.Unlock()
+
+	return controller
+}
+
+// apiResponse is the response of the API. Images holds the status of the images affected by the request.
+type apiResponse struct {
+	Action string        `json:"action,omitempty"`
+	Images []ImageStatus `json:"images,omitempty"`
+	Error  string        `json:"error,omitempty"`
+}
+
+// registerAPI adds the API endpoints to mux. Images are selected with the image query parameter, set to the
+// source of the image.
+func registerAPI(mux *http.ServeMux) {
+	mux.HandleFunc("POST /api/v1/sync", authorized(handleSync))
+	mux.HandleFunc("POST /api/v1/pause", authorized(imageAction("pause", Controller.Pause)))
+	mux.HandleFunc("POST /api/v1/resume", authorized(imageAction("resume", Controller.Resume)))
+	mux.HandleFunc("POST /api/v1/cancel", authorized(imageAction("cancel", Controller.Cancel)))
+}
+
+// authorized checks the bearer token of a request against admin.token.
+func authorized(next func(http.ResponseWriter, *http.Request, Controller)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := config.AdminToken.String()
+		if token == "" {
+			writeError(w, errTokenRequired)
+			return
+		}
+
+		bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+			writeError(w, errUnauthorized)
+			return
+		}
+
+		c := getController()
+		if c == nil {
+			writeError(w, ErrNoController)
+			return
+		}
+
+		next(w, r, c)
+	}
+}
+
+func handleSync(w http.ResponseWriter, r *http.Request, c Controller) {
+	source := r.URL.Query().Get("image")
+	wait := r.URL.Query().Get("wait") == "true"
+
+	if wait {
+		// Syncs take longer than the write timeout of the server
+		_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	}
+
+	sources, err := c.Trigger(r.Context(), source, wait)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	log.Info().
+		Strs("images", sources).
+		Bool("wait", wait).
+		Msg("Sync triggered through the admin API")
+
+	status := http.StatusAccepted
+	if wait {
+		status = http.StatusOK
+	}
+
+	writeJSON(w, status, apiResponse{
+		Action: "sync",
+		Images: imageStatuses(sources),
+	})
+}
+
+// imageAction handles an action on the images of a single source.
+func imageAction(action string, fn func(Controller, string) error) func(http.ResponseWriter, *http.Request, Controller) {
+	return func(w http.ResponseWriter, r *http.Request, c Controller) {
+		source := r.URL.Query().Get("image")
+		if source == "" {
+			writeJSON(w, http.StatusBadRequest, apiResponse{Error: "image is required"})
+			return
+		}
+
+		if err := fn(c, source); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		log.Info().
+			Str("image", source).
+			Str("action", action).
+			Msg("Image updated through the admin API")
+
+		writeJSON(w, http.StatusOK, apiResponse{
+			Action: action,
+			Images: imageStatuses([]string{source}),
+		})
+	}
+}
+
+// imageStatuses returns the status of the images of sources.
+func imageStatuses(sources []string) []ImageStatus {
+	var statuses []ImageStatus
+
+	for _, s := range GetStatus(time.Now()).Images {
+		if slices.Contains(sources, s.Source) {
+			statuses = append(statuses, s)
+		}
+	}
+
+	return statuses
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+
+	switch {
+	case errors.Is(err, errUnauthorized):
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		status = http.StatusUnauthorized
+	case errors.Is(err, errTokenRequired):
+		status = http.StatusForbidden
+	case errors.Is(err, ErrUnknownImage):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrImagePaused), errors.Is(err, ErrNotSyncing):
+		status = http.StatusConflict
+	case errors.Is(err, ErrShuttingDown), errors.Is(err, ErrNoController):
+		status = http.StatusServiceUnavailable
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		status = http.StatusGatewayTimeout
+	}
+
+	writeJSON(w, status, apiResponse{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
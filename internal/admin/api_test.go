@@ -0,0 +1,155 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Altinity/docker-sync/config"
+	"github.com/Altinity/docker-sync/structs"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeController struct {
+	paused map[string]bool
+	// synced are the repository:tag synced by SyncTag
+	synced []string
+}
+
+func (c *fakeController) Trigger(ctx context.Context, source string, wait bool) ([]string, error) {
+	switch source {
+	case "":
+		return []string{"docker.io/library/ubuntu"}, nil
+	case "docker.io/library/ubuntu":
+		if c.paused[source] {
+			return nil, ErrImagePaused
+		}
+
+		return []string{source}, nil
+	default:
+		return nil, ErrUnknownImage
+	}
+}
+
+func (c *fakeController) Pause(source string) error {
+	if source != "docker.io/library/ubuntu" {
+		return ErrUnknownImage
+	}
+
+	c.paused[source] = true
+	SetPaused(source, true)
+
+	return nil
+}
+
+func (c *fakeController) Resume(source string) error {
+	delete(c.paused, source)
+	SetPaused(source, false)
+
+	return nil
+}
+
+func (c *fakeController) Cancel(source string) error {
+	return ErrNotSyncing
+}
+
+func (c *fakeController) SyncTag(ctx context.Context, repository string, tag string) ([]string, error) {
+	if repository != "docker.io/library/ubuntu" {
+		return nil, nil
+	}
+
+	c.synced = append(c.synced, repository+":"+tag)
+
+	return []string{repository}, nil
+}
+
+func TestAPI(t *testing.T) {
+	resetStatus(t)
+
+	SetImages([]*structs.Image{{Source: "docker.io/library/ubuntu"}})
+
+	mux := http.NewServeMux()
+	Register(mux)
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	post := func(path string, token string) (int, apiResponse) {
+		req, err := http.NewRequest(http.MethodPost, server.URL+path, nil)
+		if !assert.NoError(t, err) {
+			return 0, apiResponse{}
+		}
+
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if !assert.NoError(t, err) {
+			return 0, apiResponse{}
+		}
+		defer resp.Body.Close()
+
+		var body apiResponse
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+
+		return resp.StatusCode, body
+	}
+
+	// The API is disabled without a token
+	code, _ := post("/api/v1/sync", "secret")
+	assert.Equal(t, http.StatusForbidden, code)
+
+	viper.Set("admin.token", "secret")
+	config.AdminToken.Update()
+	defer func() {
+		viper.Set("admin.token", "")
+		config.AdminToken.Update()
+	}()
+
+	code, _ = post("/api/v1/sync", "")
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	code, _ = post("/api/v1/sync", "wrong")
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	// Only available while the sync loop runs
+	code, _ = post("/api/v1/sync", "secret")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+
+	SetController(&fakeController{paused: make(map[string]bool)})
+	defer SetController(nil)
+
+	testCases := []struct {
+		name   string
+		path   string
+		code   int
+		paused bool
+		error  string
+	}{
+		{name: "Sync all", path: "/api/v1/sync", code: http.StatusAccepted},
+		{name: "Sync image", path: "/api/v1/sync?image=docker.io/library/ubuntu&wait=true", code: http.StatusOK},
+		{name: "Sync unknown image", path: "/api/v1/sync?image=docker.io/library/alpine", code: http.StatusNotFound, error: "unknown image"},
+		{name: "Pause", path: "/api/v1/pause?image=docker.io/library/ubuntu", code: http.StatusOK, paused: true},
+		{name: "Sync paused image", path: "/api/v1/sync?image=docker.io/library/ubuntu", code: http.StatusConflict, error: "image is paused"},
+		{name: "Resume", path: "/api/v1/resume?image=docker.io/library/ubuntu", code: http.StatusOK},
+		{name: "Cancel", path: "/api/v1/cancel?image=docker.io/library/ubuntu", code: http.StatusConflict, error: "image is not being synced"},
+		{name: "Missing image", path: "/api/v1/pause", code: http.StatusBadRequest, error: "image is required"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			code, body := post(tc.path, "secret")
+			assert.Equal(t, tc.code, code)
+			assert.Equal(t, tc.error, body.Error)
+
+			if tc.error == "" && assert.Len(t, body.Images, 1) {
+				assert.Equal(t, "docker.io/library/ubuntu", body.Images[0].Source)
+				assert.Equal(t, tc.paused, body.Images[0].Paused)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/Altinity/docker-sync/config"
+	"github.com/rs/zerolog/log"
+)
+
+// SharesPrometheusServer returns whether the endpoints are served by the Prometheus server, as both listen on
+// the same address.
+func SharesPrometheusServer() bool {
+	return config.TelemetryEnabled.Bool() &&
+		config.TelemetryMetricsExporter.String() == "prometheus" &&
+		config.AdminAddress.String() == config.TelemetryMetricsPrometheusAddress.String()
+}
+
+// Register adds the health, readiness and status endpoints, the API and the webhooks to mux.
+func Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /healthz", handleHealthz)
+	mux.HandleFunc("GET /readyz", handleReadyz)
+	mux.HandleFunc("GET /status", handleStatus)
+
+	registerAPI(mux)
+	registerWebhooks(mux)
+}
+
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if err := Healthy(time.Now()); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if !Ready() {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, GetStatus(time.Now()))
+}
+
+// Start serves the endpoints on admin.address until ctx is canceled.
+func Start(ctx context.Context) error {
+	address := config.AdminAddress.String()
+
+	log.Info().Str("address", address).Msg("Starting admin server")
+
+	mux := http.NewServeMux()
+	Register(mux)
+
+	server := &http.Server{
+		BaseContext: func(net.Listener) context.Context {
+			return ctx
+		},
+		Addr:              address,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       10 * time.Second,
+		WriteTimeout:      10 * time.Second,
+	}
+
+	stop := context.AfterFunc(ctx, func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		_ = server.Shutdown(shutdownCtx)
+	})
+	defer stop()
+
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
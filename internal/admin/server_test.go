@@ -0,0 +1,76 @@
+package admin
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Altinity/docker-sync/structs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEndpoints(t *testing.T) {
+	resetStatus(t)
+
+	mux := http.NewServeMux()
+	Register(mux)
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	get := func(path string) (int, string) {
+		resp, err := http.Get(server.URL + path)
+		if !assert.NoError(t, err) {
+			return 0, ""
+		}
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+
+		return resp.StatusCode, string(body)
+	}
+
+	code, _ := get("/healthz")
+	assert.Equal(t, http.StatusOK, code)
+
+	code, _ = get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+
+	SetReady(true)
+	code, _ = get("/readyz")
+	assert.Equal(t, http.StatusOK, code)
+
+	Waiting(time.Now().Add(-2 * time.Hour))
+	code, body := get("/healthz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Contains(t, body, "sync loop overdue")
+
+	SetImages([]*structs.Image{{Source: "docker.io/library/ubuntu"}})
+	ImageStarted("docker.io/library/ubuntu")
+	TagsFound("docker.io/library/ubuntu", 2)
+
+	resp, err := http.Get(server.URL + "/status")
+	if assert.NoError(t, err) {
+		defer resp.Body.Close()
+
+		assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+		var status Status
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+		assert.True(t, status.Ready)
+		assert.True(t, status.Healthy)
+		if assert.Len(t, status.Images, 1) {
+			assert.True(t, status.Images[0].Running)
+			assert.Equal(t, 2, status.Images[0].Tags)
+		}
+	}
+
+	resp, err = http.Post(server.URL+"/status", "application/json", nil)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+}
@@ -0,0 +1,267 @@
+package admin
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Altinity/docker-sync/config"
+	"github.com/Altinity/docker-sync/structs"
+)
+
+const (
+	ResultSucceeded = "succeeded"
+	ResultFailed    = "failed"
+)
+
+// ImageStatus is the sync status of an image.
+type ImageStatus struct {
+	Source  string   `json:"source"`
+	Targets []string `json:"targets"`
+	Running bool     `json:"running"`
+	Paused  bool     `json:"paused"`
+	// Result is the result of the last finished sync, empty until the image was synced once.
+	Result    string     `json:"result,omitempty"`
+	LastStart *time.Time `json:"lastStart,omitempty"`
+	LastEnd   *time.Time `json:"lastEnd,omitempty"`
+	NextSync  *time.Time `json:"nextSync,omitempty"`
+	// Tags is the number of source tags found by the last sync, PushedTags and FailedTags the number of tags
+	// pushed and failed to push to a target.
+	Tags       int    `json:"tags"`
+	PushedTags int    `json:"pushedTags"`
+	FailedTags int    `json:"failedTags"`
+	LastError  string `json:"lastError,omitempty"`
+}
+
+// Status is the status of the sync loop and its images, served by /status.
+type Status struct {
+	Ready        bool          `json:"ready"`
+	Healthy      bool          `json:"healthy"`
+	Error        string        `json:"error,omitempty"`
+	LastActivity time.Time     `json:"lastActivity"`
+	Images       []ImageStatus `json:"images"`
+}
+
+var (
+	statusMutex  sync.Mutex
+	images       []*ImageStatus
+	ready        bool
+	running      int
+	lastActivity = time.Now()
+	// wakeup is when the sync loop waiting for the next sync is expected to wake up
+	wakeup time.Time
+)
+
+// SetImages replaces the monitored images, keeping the status of the sources that are still monitored.
+func SetImages(monitored []*structs.Image) {
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+
+	var updated []*ImageStatus
+
+	for _, image := range monitored {
+		s := findImage(image.Source)
+		if s == nil {
+			s = &ImageStatus{Source: image.Source}
+		}
+		s.Targets = image.Targets
+
+		updated = append(updated, s)
+	}
+
+	images = updated
+}
+
+// findImage returns the status of a source, or nil if it is not monitored.
+// Must be called with statusMutex held.
+func findImage(source string) *ImageStatus {
+	i := slices.IndexFunc(images, func(s *ImageStatus) bool {
+		return s.Source == source
+	})
+	if i < 0 {
+		return nil
+	}
+
+	return images[i]
+}
+
+// updateImage records activity and applies fn to the status of a source, if it is monitored.
+func updateImage(source string, fn func(s *ImageStatus)) {
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+
+	lastActivity = time.Now()
+
+	if s := findImage(source); s != nil {
+		fn(s)
+	}
+}
+
+// ImageStarted records the start of the sync of an image.
+func ImageStarted(source string) {
+	statusMutex.Lock()
+	running++
+	statusMutex.Unlock()
+
+	updateImage(source, func(s *ImageStatus) {
+		now := time.Now()
+
+		s.Running = true
+		s.LastStart = &now
+		s.Tags = 0
+		s.PushedTags = 0
+		s.FailedTags = 0
+	})
+}
+
+// ImageFinished records the end of the sync of an image.
+func ImageFinished(source string, err error) {
+	statusMutex.Lock()
+	running--
+	statusMutex.Unlock()
+
+	updateImage(source, func(s *ImageStatus) {
+		now := time.Now()
+
+		s.Running = false
+		s.LastEnd = &now
+		s.Result = ResultSucceeded
+
+		if err != nil {
+			s.Result = ResultFailed
+			s.LastError = err.Error()
+		}
+	})
+}
+
+// Progress records that data of a sync in progress was copied, so a long copy isn't considered stalled.
+func Progress() {
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+
+	lastActivity = time.Now()
+}
+
+// TagsFound records the number of source tags of an image.
+func TagsFound(source string, tags int) {
+	updateImage(source, func(s *ImageStatus) {
+		s.Tags = tags
+	})
+}
+
+// TagPushed records a tag pushed to a target.
+func TagPushed(source string) {
+	updateImage(source, func(s *ImageStatus) {
+		s.PushedTags++
+	})
+}
+
+// TagFailed records a tag that failed to be pushed to a target.
+func TagFailed(source string, tag string, err error) {
+	updateImage(source, func(s *ImageStatus) {
+		s.FailedTags++
+		s.LastError = fmt.Sprintf("%s: %s", tag, err)
+	})
+}
+
+// SetPaused records whether the images of a source are paused.
+func SetPaused(source string, paused bool) {
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+
+	if s := findImage(source); s != nil {
+		s.Paused = paused
+	}
+}
+
+// SetNextSync records when an image is synced next.
+func SetNextSync(source string, next time.Time) {
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+
+	if s := findImage(source); s != nil {
+		s.NextSync = &next
+	}
+}
+
+// SetReady records whether the sync loop is running, it is not ready before it starts and once it shuts down.
+func SetReady(r bool) {
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+
+	ready = r
+}
+
+// Waiting records that the sync loop is waiting until the next sync.
+func Waiting(until time.Time) {
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+
+	lastActivity = time.Now()
+	wakeup = until
+}
+
+// Ready returns whether the sync loop is running.
+func Ready() bool {
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+
+	return ready
+}
+
+// Healthy returns an error if the sync loop looks stuck: syncs made no progress, neither finished a tag nor copied
+// data, for admin.stallTimeout, or the
+// loop didn't wake up admin.stallTimeout after the next sync was due.
+func Healthy(now time.Time) error {
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+
+	return healthy(now)
+}
+
+// Must be called with statusMutex held.
+func healthy(now time.Time) error {
+	timeout := config.AdminStallTimeout.Duration()
+	if timeout <= 0 {
+		return nil
+	}
+
+	if running > 0 {
+		if now.Sub(lastActivity) > timeout {
+			return fmt.Errorf("no sync progress since %s", lastActivity.Format(time.RFC3339))
+		}
+
+		return nil
+	}
+
+	if !wakeup.IsZero() && now.Sub(wakeup) > timeout {
+		return fmt.Errorf("sync loop overdue since %s", wakeup.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// GetStatus returns a copy of the status.
+func GetStatus(now time.Time) Status {
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+
+	status := Status{
+		Ready:        ready,
+		Healthy:      true,
+		LastActivity: lastActivity,
+		Images:       make([]ImageStatus, 0, len(images)),
+	}
+
+	if err := healthy(now); err != nil {
+		status.Healthy = false
+		status.Error = err.Error()
+	}
+
+	for _, s := range images {
+		status.Images = append(status.Images, *s)
+	}
+
+	return status
+}
@@ -0,0 +1,116 @@
+package admin
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Altinity/docker-sync/config"
+	"github.com/Altinity/docker-sync/structs"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+// resetStatus clears the status recorded by previous tests.
+func resetStatus(t *testing.T) {
+	t.Helper()
+
+	viper.Set("admin.stallTimeout", "1h")
+	config.AdminStallTimeout.Update()
+
+	images = nil
+	ready = false
+	running = 0
+	lastActivity = time.Now()
+	wakeup = time.Time{}
+
+	t.Cleanup(func() {
+		viper.Set("admin.stallTimeout", "1h")
+		config.AdminStallTimeout.Update()
+	})
+}
+
+func TestImageStatus(t *testing.T) {
+	resetStatus(t)
+
+	SetImages([]*structs.Image{
+		{Source: "docker.io/library/ubuntu", Targets: []string{"ghcr.io/altinity/ubuntu"}},
+		{Source: "docker.io/library/alpine", Targets: []string{"ghcr.io/altinity/alpine"}},
+	})
+
+	ImageStarted("docker.io/library/ubuntu")
+	TagsFound("docker.io/library/ubuntu", 3)
+	TagPushed("docker.io/library/ubuntu")
+	TagFailed("docker.io/library/ubuntu", "24.04", errors.New("denied"))
+
+	status := GetStatus(time.Now())
+	if assert.Len(t, status.Images, 2) {
+		ubuntu := status.Images[0]
+		assert.Equal(t, "docker.io/library/ubuntu", ubuntu.Source)
+		assert.True(t, ubuntu.Running)
+		assert.NotNil(t, ubuntu.LastStart)
+		assert.Nil(t, ubuntu.LastEnd)
+		assert.Equal(t, 3, ubuntu.Tags)
+		assert.Equal(t, 1, ubuntu.PushedTags)
+		assert.Equal(t, 1, ubuntu.FailedTags)
+		assert.Equal(t, "24.04: denied", ubuntu.LastError)
+
+		assert.Empty(t, status.Images[1].Result)
+	}
+
+	ImageFinished("docker.io/library/ubuntu", errors.New("failed to list tags"))
+	ImageStarted("docker.io/library/alpine")
+	ImageFinished("docker.io/library/alpine", nil)
+
+	status = GetStatus(time.Now())
+	assert.False(t, status.Images[0].Running)
+	assert.Equal(t, ResultFailed, status.Images[0].Result)
+	assert.Equal(t, "failed to list tags", status.Images[0].LastError)
+	assert.Equal(t, ResultSucceeded, status.Images[1].Result)
+
+	// A reload keeps the status of the images still monitored
+	SetImages([]*structs.Image{
+		{Source: "docker.io/library/alpine", Targets: []string{"ghcr.io/altinity/alpine", "quay.io/altinity/alpine"}},
+	})
+
+	status = GetStatus(time.Now())
+	if assert.Len(t, status.Images, 1) {
+		assert.Equal(t, ResultSucceeded, status.Images[0].Result)
+		assert.Len(t, status.Images[0].Targets, 2)
+	}
+}
+
+func TestHealthy(t *testing.T) {
+	resetStatus(t)
+
+	now := time.Now()
+
+	assert.NoError(t, Healthy(now))
+
+	// Waiting for the next sync
+	Waiting(now.Add(time.Minute))
+	assert.NoError(t, Healthy(now.Add(time.Hour)))
+	assert.Error(t, Healthy(now.Add(2*time.Hour)))
+
+	// Syncing without progress
+	ImageStarted("docker.io/library/ubuntu")
+	assert.NoError(t, Healthy(now.Add(30*time.Minute)))
+	assert.Error(t, Healthy(now.Add(2*time.Hour)))
+
+	// Copying data of a long tag is progress
+	statusMutex.Lock()
+	lastActivity = time.Now().Add(-2 * time.Hour)
+	statusMutex.Unlock()
+	assert.Error(t, Healthy(time.Now()))
+	Progress()
+	assert.NoError(t, Healthy(time.Now()))
+
+	ImageFinished("docker.io/library/ubuntu", nil)
+	Waiting(now.Add(2 * time.Hour))
+	assert.NoError(t, Healthy(now.Add(2*time.Hour)))
+
+	// A zero stall timeout disables the check
+	viper.Set("admin.stallTimeout", "0s")
+	config.AdminStallTimeout.Update()
+	assert.NoError(t, Healthy(now.Add(24*time.Hour)))
+}
@@ -0,0 +1,379 @@
+package admin
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Altinity/docker-sync/config"
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/rs/zerolog/log"
+)
+
+// maxWebhookSize is the largest webhook payload accepted.
+const maxWebhookSize = 1 << 20
+
+var (
+	errWebhookSecretRequired = errors.New("webhooks disabled, admin.webhooks.secret is not set")
+	errInvalidSignature      = errors.New("invalid signature")
+)
+
+// PushEvent is a tag pushed to a repository, named as reference.ParseNormalizedNamed does, e.g.
+// docker.io/library/ubuntu.
+type PushEvent struct {
+	Repository string `json:"repository"`
+	Tag        string `json:"tag"`
+}
+
+// webhookSync is an image tag synced on a push event.
+type webhookSync struct {
+	Image string `json:"image"`
+	Tag   string `json:"tag"`
+}
+
+// webhookResponse lists the push events of a webhook and the image tags synced. Errors are returned as for the
+// API.
+type webhookResponse struct {
+	Events []PushEvent   `json:"events"`
+	Syncs  []webhookSync `json:"syncs"`
+}
+
+// webhookParsers decode the push events of each provider.
+var webhookParsers = map[string]func(r *http.Request, body []byte) ([]PushEvent, error){
+	"dockerhub":   parseDockerHub,
+	"harbor":      parseHarbor,
+	"github":      parseGitHub,
+	"cloudevents": parseCloudEvents,
+}
+
+func registerWebhooks(mux *http.ServeMux) {
+	mux.HandleFunc("POST /webhooks/{provider}", handleWebhook)
+}
+
+func handleWebhook(w http.ResponseWriter, r *http.Request) {
+	provider := r.PathValue("provider")
+
+	parse, ok := webhookParsers[provider]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, apiResponse{Error: fmt.Sprintf("unknown provider %q", provider)})
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookSize))
+	if err != nil {
+		writeJSON(w, http.StatusRequestEntityTooLarge, apiResponse{Error: err.Error()})
+		return
+	}
+
+	if err := verifyWebhook(r, body); err != nil {
+		status := http.StatusUnauthorized
+		if errors.Is(err, errWebhookSecretRequired) {
+			status = http.StatusForbidden
+		}
+
+		log.Warn().
+			Err(err).
+			Str("provider", provider).
+			Str("remote", r.RemoteAddr).
+			Msg("Rejected webhook")
+
+		writeJSON(w, status, apiResponse{Error: err.Error()})
+		return
+	}
+
+	events, err := parse(r, body)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiResponse{Error: err.Error()})
+		return
+	}
+
+	c := getController()
+	if c == nil {
+		writeJSON(w, http.StatusServiceUnavailable, apiResponse{Error: ErrNoController.Error()})
+		return
+	}
+
+	resp := webhookResponse{
+		Events: append([]PushEvent{}, events...),
+		Syncs:  []webhookSync{},
+	}
+
+	for _, event := range events {
+		sources, err := c.SyncTag(r.Context(), event.Repository, event.Tag)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		log.Info().
+			Str("provider", provider).
+			Str("repository", event.Repository).
+			Str("tag", event.Tag).
+			Strs("images", sources).
+			Msg("Received push event")
+
+		for _, source := range sources {
+			resp.Syncs = append(resp.Syncs, webhookSync{Image: source, Tag: event.Tag})
+		}
+	}
+
+	status := http.StatusOK
+	if len(resp.Syncs) > 0 {
+		status = http.StatusAccepted
+	}
+
+	writeJSON(w, status, resp)
+}
+
+// verifyWebhook checks a webhook against admin.webhooks.secret, with the first of:
+//   - an X-Hub-Signature-256 HMAC-SHA256 signature of the body, sent by GitHub
+//   - an Authorization header set to the secret or to a bearer token, sent by Harbor and distribution
+//   - a secret query parameter, for Docker Hub which can't send headers
+func verifyWebhook(r *http.Request, body []byte) error {
+	secret := config.AdminWebhooksSecret.String()
+	if secret == "" {
+		return errWebhookSecretRequired
+	}
+
+	if signature, ok := strings.CutPrefix(r.Header.Get("X-Hub-Signature-256"), "sha256="); ok {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+
+		expected, err := hex.DecodeString(signature)
+		if err != nil || !hmac.Equal(expected, mac.Sum(nil)) {
+			return errInvalidSignature
+		}
+
+		return nil
+	}
+
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		auth = strings.TrimPrefix(auth, "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(auth), []byte(secret)) != 1 {
+			return errInvalidSignature
+		}
+
+		return nil
+	}
+
+	if s := r.URL.Query().Get("secret"); s != "" && subtle.ConstantTimeCompare([]byte(s), []byte(secret)) == 1 {
+		return nil
+	}
+
+	return errInvalidSignature
+}
+
+// normalizeRepository names a repository as reference.ParseNormalizedNamed does.
+func normalizeRepository(repository string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(repository)
+	if err != nil {
+		return "", fmt.Errorf("invalid repository %q: %w", repository, err)
+	}
+
+	return named.Name(), nil
+}
+
+// pushEvent returns the push event of a tag in a repository, nil if the tag is empty, e.g. for untagged pushes.
+func pushEvent(repository string, tag string) ([]PushEvent, error) {
+	if tag == "" {
+		return nil, nil
+	}
+
+	name, err := normalizeRepository(repository)
+	if err != nil {
+		return nil, err
+	}
+
+	return []PushEvent{{Repository: name, Tag: tag}}, nil
+}
+
+// parseDockerHub decodes a Docker Hub webhook.
+func parseDockerHub(r *http.Request, body []byte) ([]PushEvent, error) {
+	var payload struct {
+		PushData struct {
+			Tag string `json:"tag"`
+		} `json:"push_data"`
+		Repository struct {
+			RepoName string `json:"repo_name"`
+		} `json:"repository"`
+	}
+
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("invalid Docker Hub payload: %w", err)
+	}
+
+	return pushEvent(payload.Repository.RepoName, payload.PushData.Tag)
+}
+
+// parseHarbor decodes a Harbor webhook. Only PUSH_ARTIFACT events sync tags.
+func parseHarbor(r *http.Request, body []byte) ([]PushEvent, error) {
+	var payload struct {
+		Type      string `json:"type"`
+		EventData struct {
+			Resources []struct {
+				Tag         string `json:"tag"`
+				ResourceURL string `json:"resource_url"`
+			} `json:"resources"`
+		} `json:"event_data"`
+	}
+
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("invalid Harbor payload: %w", err)
+	}
+
+	if payload.Type != "PUSH_ARTIFACT" {
+		return nil, nil
+	}
+
+	var events []PushEvent
+
+	for _, resource := range payload.EventData.Resources {
+		// The resource URL is <host>/<project>/<repository>:<tag> or @<digest>
+		repository, _, _ := strings.Cut(resource.ResourceURL, "@")
+		if resource.Tag != "" {
+			repository = strings.TrimSuffix(repository, ":"+resource.Tag)
+		}
+
+		e, err := pushEvent(repository, resource.Tag)
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, e...)
+	}
+
+	return events, nil
+}
+
+// parseGitHub decodes a GitHub package or registry_package event of a container published to GHCR.
+func parseGitHub(r *http.Request, body []byte) ([]PushEvent, error) {
+	event := r.Header.Get("X-GitHub-Event")
+	if event != "package" && event != "registry_package" {
+		// e.g. ping
+		return nil, nil
+	}
+
+	type githubPackage struct {
+		Name        string `json:"name"`
+		PackageType string `json:"package_type"`
+		Owner       struct {
+			Login string `json:"login"`
+		} `json:"owner"`
+		PackageVersion struct {
+			ContainerMetadata struct {
+				Tag struct {
+					Name string `json:"name"`
+				} `json:"tag"`
+			} `json:"container_metadata"`
+		} `json:"package_version"`
+	}
+
+	var payload struct {
+		Action          string         `json:"action"`
+		Package         *githubPackage `json:"package"`
+		RegistryPackage *githubPackage `json:"registry_package"`
+	}
+
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("invalid GitHub payload: %w", err)
+	}
+
+	pkg := payload.Package
+	if pkg == nil {
+		pkg = payload.RegistryPackage
+	}
+
+	if pkg == nil || (payload.Action != "published" && payload.Action != "updated") ||
+		!strings.EqualFold(pkg.PackageType, "container") {
+		return nil, nil
+	}
+
+	repository := strings.ToLower(fmt.Sprintf("ghcr.io/%s/%s", pkg.Owner.Login, pkg.Name))
+
+	return pushEvent(repository, pkg.PackageVersion.ContainerMetadata.Tag.Name)
+}
+
+// distributionEvent is an event of the distribution registry notifications.
+type distributionEvent struct {
+	Action string `json:"action"`
+	Target struct {
+		Repository string `json:"repository"`
+		Tag        string `json:"tag"`
+		URL        string `json:"url"`
+	} `json:"target"`
+	Request struct {
+		Host string `json:"host"`
+	} `json:"request"`
+}
+
+// parseCloudEvents decodes distribution notifications, sent as CloudEvents in structured or binary mode, or as
+// the native envelope of events.
+func parseCloudEvents(r *http.Request, body []byte) ([]PushEvent, error) {
+	var payload struct {
+		SpecVersion string              `json:"specversion"`
+		Data        json.RawMessage     `json:"data"`
+		Events      []distributionEvent `json:"events"`
+	}
+
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("invalid CloudEvents payload: %w", err)
+	}
+
+	var events []distributionEvent
+
+	switch {
+	case payload.Events != nil:
+		events = payload.Events
+	case payload.SpecVersion != "":
+		var e distributionEvent
+		if err := json.Unmarshal(payload.Data, &e); err != nil {
+			return nil, fmt.Errorf("invalid CloudEvents data: %w", err)
+		}
+
+		events = append(events, e)
+	case r.Header.Get("Ce-Specversion") != "":
+		var e distributionEvent
+		if err := json.Unmarshal(body, &e); err != nil {
+			return nil, fmt.Errorf("invalid CloudEvents data: %w", err)
+		}
+
+		events = append(events, e)
+	default:
+		return nil, errors.New("invalid CloudEvents payload: no event found")
+	}
+
+	var pushEvents []PushEvent
+
+	for _, e := range events {
+		if e.Action != "push" {
+			continue
+		}
+
+		host := e.Request.Host
+		if u, err := url.Parse(e.Target.URL); host == "" && err == nil {
+			host = u.Host
+		}
+
+		repository := e.Target.Repository
+		if host != "" {
+			repository = host + "/" + repository
+		}
+
+		pe, err := pushEvent(repository, e.Target.Tag)
+		if err != nil {
+			return nil, err
+		}
+
+		pushEvents = append(pushEvents, pe...)
+	}
+
+	return pushEvents, nil
+}
@@ -0,0 +1,217 @@
+package admin
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Altinity/docker-sync/config"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseWebhooks(t *testing.T) {
+	testCases := []struct {
+		name     string
+		provider string
+		headers  map[string]string
+		body     string
+		expected []PushEvent
+	}{
+		{
+			name:     "Docker Hub",
+			provider: "dockerhub",
+			body:     `{"push_data":{"tag":"24.04","pusher":"altinity"},"repository":{"repo_name":"altinity/clickhouse-server"}}`,
+			expected: []PushEvent{{Repository: "docker.io/altinity/clickhouse-server", Tag: "24.04"}},
+		},
+		{
+			name:     "Harbor",
+			provider: "harbor",
+			body: `{"type":"PUSH_ARTIFACT","event_data":{"resources":[{"digest":"sha256:1111111111111111111111111111111111111111111111111111111111111111",` +
+				`"tag":"v1","resource_url":"harbor.example.com/library/nginx:v1"}],"repository":{"repo_full_name":"library/nginx"}}}`,
+			expected: []PushEvent{{Repository: "harbor.example.com/library/nginx", Tag: "v1"}},
+		},
+		{
+			name:     "Harbor deletion",
+			provider: "harbor",
+			body:     `{"type":"DELETE_ARTIFACT","event_data":{"resources":[{"tag":"v1","resource_url":"harbor.example.com/library/nginx:v1"}]}}`,
+		},
+		{
+			name:     "GitHub",
+			provider: "github",
+			headers:  map[string]string{"X-GitHub-Event": "package"},
+			body: `{"action":"published","package":{"name":"Clickhouse-Server","package_type":"CONTAINER","owner":{"login":"Altinity"},` +
+				`"package_version":{"container_metadata":{"tag":{"name":"1.0","digest":"sha256:1111"}}}}}`,
+			expected: []PushEvent{{Repository: "ghcr.io/altinity/clickhouse-server", Tag: "1.0"}},
+		},
+		{
+			name:     "GitHub untagged",
+			provider: "github",
+			headers:  map[string]string{"X-GitHub-Event": "registry_package"},
+			body: `{"action":"published","registry_package":{"name":"image","package_type":"container","owner":{"login":"altinity"},` +
+				`"package_version":{"container_metadata":{"tag":{"name":""}}}}}`,
+		},
+		{
+			name:     "GitHub npm package",
+			provider: "github",
+			headers:  map[string]string{"X-GitHub-Event": "package"},
+			body:     `{"action":"published","package":{"name":"lib","package_type":"npm","owner":{"login":"altinity"}}}`,
+		},
+		{
+			name:     "GitHub ping",
+			provider: "github",
+			headers:  map[string]string{"X-GitHub-Event": "ping"},
+			body:     `{"zen":"Keep it logically awesome."}`,
+		},
+		{
+			name:     "Distribution envelope",
+			provider: "cloudevents",
+			body: `{"events":[{"action":"push","target":{"repository":"altinity/image","tag":"2.0"},"request":{"host":"registry.example.com:5000"}},` +
+				`{"action":"pull","target":{"repository":"altinity/image","tag":"2.0"},"request":{"host":"registry.example.com:5000"}}]}`,
+			expected: []PushEvent{{Repository: "registry.example.com:5000/altinity/image", Tag: "2.0"}},
+		},
+		{
+			name:     "CloudEvents structured",
+			provider: "cloudevents",
+			body: `{"specversion":"1.0","type":"distribution.push","source":"registry","id":"1",` +
+				`"data":{"action":"push","target":{"repository":"altinity/image","tag":"2.0","url":"https://registry.example.com/v2/altinity/image/manifests/sha256:1111"}}}`,
+			expected: []PushEvent{{Repository: "registry.example.com/altinity/image", Tag: "2.0"}},
+		},
+		{
+			name:     "CloudEvents binary",
+			provider: "cloudevents",
+			headers:  map[string]string{"Ce-Specversion": "1.0", "Ce-Type": "distribution.push"},
+			body:     `{"action":"push","target":{"repository":"altinity/image","tag":"2.0"},"request":{"host":"registry.example.com"}}`,
+			expected: []PushEvent{{Repository: "registry.example.com/altinity/image", Tag: "2.0"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/webhooks/"+tc.provider, strings.NewReader(tc.body))
+			for k, v := range tc.headers {
+				r.Header.Set(k, v)
+			}
+
+			events, err := webhookParsers[tc.provider](r, []byte(tc.body))
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, events)
+		})
+	}
+
+	t.Run("Invalid", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPost, "/webhooks/cloudevents", nil)
+
+		_, err := parseCloudEvents(r, []byte(`{"action":"push"}`))
+		assert.Error(t, err)
+
+		_, err = parseDockerHub(r, []byte(`{"push_data":{"tag":"1.0"},"repository":{"repo_name":"Invalid Name"}}`))
+		assert.Error(t, err)
+	})
+}
+
+func TestVerifyWebhook(t *testing.T) {
+	body := []byte(`{"zen":"Keep it logically awesome."}`)
+
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(body)
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	testCases := []struct {
+		name    string
+		target  string
+		headers map[string]string
+		valid   bool
+	}{
+		{name: "Signature", target: "/webhooks/github", headers: map[string]string{"X-Hub-Signature-256": signature}, valid: true},
+		{name: "Invalid signature", target: "/webhooks/github", headers: map[string]string{"X-Hub-Signature-256": "sha256=1234"}},
+		{name: "Authorization", target: "/webhooks/harbor", headers: map[string]string{"Authorization": "secret"}, valid: true},
+		{name: "Bearer token", target: "/webhooks/cloudevents", headers: map[string]string{"Authorization": "Bearer secret"}, valid: true},
+		{name: "Invalid token", target: "/webhooks/cloudevents?secret=secret", headers: map[string]string{"Authorization": "Bearer wrong"}},
+		{name: "Query parameter", target: "/webhooks/dockerhub?secret=secret", valid: true},
+		{name: "Invalid query parameter", target: "/webhooks/dockerhub?secret=wrong"},
+		{name: "Unsigned", target: "/webhooks/dockerhub"},
+	}
+
+	viper.Set("admin.webhooks.secret", "secret")
+	config.AdminWebhooksSecret.Update()
+	defer func() {
+		viper.Set("admin.webhooks.secret", "")
+		config.AdminWebhooksSecret.Update()
+	}()
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, tc.target, nil)
+			for k, v := range tc.headers {
+				r.Header.Set(k, v)
+			}
+
+			err := verifyWebhook(r, body)
+			if tc.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, errInvalidSignature)
+			}
+		})
+	}
+}
+
+func TestWebhookEndpoint(t *testing.T) {
+	mux := http.NewServeMux()
+	Register(mux)
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	post := func(path string, body string) (int, webhookResponse) {
+		resp, err := http.Post(server.URL+path, "application/json", strings.NewReader(body))
+		if !assert.NoError(t, err) {
+			return 0, webhookResponse{}
+		}
+		defer resp.Body.Close()
+
+		var r webhookResponse
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&r))
+
+		return resp.StatusCode, r
+	}
+
+	push := `{"push_data":{"tag":"24.04"},"repository":{"repo_name":"library/ubuntu"}}`
+
+	// Webhooks are disabled without a secret
+	code, _ := post("/webhooks/dockerhub?secret=secret", push)
+	assert.Equal(t, http.StatusForbidden, code)
+
+	viper.Set("admin.webhooks.secret", "secret")
+	config.AdminWebhooksSecret.Update()
+	defer func() {
+		viper.Set("admin.webhooks.secret", "")
+		config.AdminWebhooksSecret.Update()
+	}()
+
+	c := &fakeController{paused: make(map[string]bool)}
+	SetController(c)
+	defer SetController(nil)
+
+	code, _ = post("/webhooks/dockerhub", push)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	code, _ = post("/webhooks/quay?secret=secret", push)
+	assert.Equal(t, http.StatusNotFound, code)
+
+	code, resp := post("/webhooks/dockerhub?secret=secret", push)
+	assert.Equal(t, http.StatusAccepted, code)
+	assert.Equal(t, []webhookSync{{Image: "docker.io/library/ubuntu", Tag: "24.04"}}, resp.Syncs)
+	assert.Equal(t, []string{"docker.io/library/ubuntu:24.04"}, c.synced)
+
+	// Repositories without a matching image are acknowledged
+	code, resp = post("/webhooks/dockerhub?secret=secret", `{"push_data":{"tag":"3.20"},"repository":{"repo_name":"library/alpine"}}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, resp.Syncs)
+}
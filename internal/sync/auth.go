@@ -3,6 +3,7 @@ package sync
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	"github.com/Altinity/docker-sync/config"
 	"github.com/Altinity/docker-sync/structs"
@@ -10,6 +11,17 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
+// getRepository returns the registry configured for a url, if any.
+func getRepository(url string) *structs.Repository {
+	for _, r := range config.SyncRegistries.Repositories() {
+		if r.URL == url {
+			return r
+		}
+	}
+
+	return nil
+}
+
 func getObjectStorageAuth(url string) (string, string, error) {
 	repositories := config.SyncRegistries.Repositories()
 
@@ -24,18 +36,20 @@ func getObjectStorageAuth(url string) (string, string, error) {
 	return "", "", fmt.Errorf("no auth found for %s", url)
 }
 
-func getSkopeoAuth(ctx context.Context, url string, name string) (*types.DockerAuthConfig, string) {
-	repositories := config.SyncRegistries.Repositories()
+// getRegistryContext returns the system context to access a registry with, and the name of its authentication
+// method. Tokens configured without a username are sent to the registry as bearer tokens.
+func getRegistryContext(ctx context.Context, url string, name string) (*types.SystemContext, string) {
+	if repo := getRepository(url); repo != nil && repo.Auth.Token != "" && repo.Auth.Username == "" {
+		return &types.SystemContext{DockerBearerRegistryToken: repo.Auth.Token}, "bearerToken"
+	}
 
-	var repo *structs.Repository
+	auth, authName := getSkopeoAuth(ctx, url, name)
 
-	for _, r := range repositories {
-		if r.URL == url {
-			repo = r
-			break
-		}
-	}
+	return &types.SystemContext{DockerAuthConfig: auth}, authName
+}
 
+func getSkopeoAuth(ctx context.Context, url string, name string) (*types.DockerAuthConfig, string) {
+	repo := getRepository(url)
 	if repo == nil {
 		return nil, "default"
 	}
@@ -44,6 +58,11 @@ func getSkopeoAuth(ctx context.Context, url string, name string) (*types.DockerA
 		return &types.DockerAuthConfig{Username: repo.Auth.Username, Password: repo.Auth.Password}, "basic"
 	}
 
+	// Tokens with a username are exchanged for a registry token, see getRegistryContext for those without one
+	if repo.Auth.Token != "" && repo.Auth.Username != "" {
+		return &types.DockerAuthConfig{Username: repo.Auth.Username, IdentityToken: repo.Auth.Token}, "identityToken"
+	}
+
 	switch repo.Auth.Helper {
 	case "":
 	case "ecr":
@@ -53,10 +72,38 @@ func getSkopeoAuth(ctx context.Context, url string, name string) (*types.DockerA
 		username, password := authEcrPublic(ctx, name)
 		return &types.DockerAuthConfig{Username: username, Password: password}, "ecr-public"
 	default:
+		if strings.HasPrefix(repo.Auth.Helper, credentialHelperPrefix) {
+			auth, err := authCredentialHelper(repo.Auth.Helper, url)
+			if err != nil {
+				log.Error().
+					Err(err).
+					Str("helper", repo.Auth.Helper).
+					Msg("Failed to get credentials from credential helper, falling back to keychain")
+
+				return nil, "default"
+			}
+
+			return auth, repo.Auth.Helper
+		}
+
 		log.Error().
 			Str("helper", repo.Auth.Helper).
 			Msg("Unknown auth helper, falling back to keychain")
 	}
 
+	if repo.Auth.AuthFile != "" {
+		auth, err := authFromFile(repo.Auth.AuthFile, url)
+		if err != nil {
+			log.Error().
+				Err(err).
+				Str("authFile", repo.Auth.AuthFile).
+				Msg("Failed to get credentials from auth file, falling back to keychain")
+
+			return nil, "default"
+		}
+
+		return auth, "authFile"
+	}
+
 	return nil, "default"
 }
@@ -26,6 +26,21 @@ func setupTestData(t *testing.T) {
 				"password": "testpass",
 			},
 		},
+		{
+			"name": "token",
+			"url":  "ghcr.io",
+			"auth": map[string]interface{}{
+				"token": "testtoken",
+			},
+		},
+		{
+			"name": "robot",
+			"url":  "quay.io",
+			"auth": map[string]interface{}{
+				"username": "org+robot",
+				"token":    "robottoken",
+			},
+		},
 		{
 			"name": "ecr-private",
 			"url":  "https://123456789012.dkr.ecr.us-east-1.amazonaws.com",
@@ -123,6 +138,18 @@ func TestGetSkopeoAuth(t *testing.T) {
 				assert.Equal(t, "testpass", auth.Password)
 			},
 		},
+		{
+			name:             "Token repository with username",
+			url:              "quay.io",
+			imageName:        "test-image",
+			expectedAuthType: "identityToken",
+			checkAuthConfig: func(t *testing.T, auth *types.DockerAuthConfig) {
+				assert.NotNil(t, auth)
+				assert.Equal(t, "org+robot", auth.Username)
+				assert.Equal(t, "robottoken", auth.IdentityToken)
+				assert.Empty(t, auth.Password)
+			},
+		},
 		{
 			name:             "ECR private repository",
 			url:              "https://123456789012.dkr.ecr.us-east-1.amazonaws.com",
@@ -172,3 +199,24 @@ func TestGetSkopeoAuth(t *testing.T) {
 		})
 	}
 }
+
+func TestGetRegistryContext(t *testing.T) {
+	setupAWSEnv(t)
+	setupTestData(t)
+	defer cleanupTestData(t)
+
+	// Tokens without a username are sent as bearer tokens
+	sys, authType := getRegistryContext(t.Context(), "ghcr.io", "test-image")
+	assert.Equal(t, "bearerToken", authType)
+	assert.Equal(t, "testtoken", sys.DockerBearerRegistryToken)
+	assert.Nil(t, sys.DockerAuthConfig)
+
+	sys, authType = getRegistryContext(t.Context(), "quay.io", "test-image")
+	assert.Equal(t, "identityToken", authType)
+	assert.Empty(t, sys.DockerBearerRegistryToken)
+	assert.Equal(t, "robottoken", sys.DockerAuthConfig.IdentityToken)
+
+	sys, authType = getRegistryContext(t.Context(), "https://example.com", "test-image")
+	assert.Equal(t, "basic", authType)
+	assert.Equal(t, "testpass", sys.DockerAuthConfig.Password)
+}
@@ -5,4 +5,5 @@ type RepositoryType string
 const (
 	S3CompatibleRepository RepositoryType = "s3"
 	OCIRepository          RepositoryType = "oci"
+	OCILayoutRepository    RepositoryType = "oci-layout"
 )
@@ -0,0 +1,132 @@
+package sync
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/containers/image/v5/types"
+	"github.com/docker/docker-credential-helpers/client"
+	"github.com/docker/docker-credential-helpers/credentials"
+)
+
+const credentialHelperPrefix = "docker-credential-"
+
+// dockerConfigFile is the subset of a Docker/Podman config.json used for registry authentication.
+type dockerConfigFile struct {
+	Auths       map[string]dockerConfigAuth `json:"auths"`
+	CredHelpers map[string]string           `json:"credHelpers"`
+	CredsStore  string                      `json:"credsStore"`
+}
+
+type dockerConfigAuth struct {
+	Auth          string `json:"auth"`
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	IdentityToken string `json:"identitytoken"`
+}
+
+// dockerConfigKeys returns the keys a registry may be stored under in a config.json, in lookup order.
+func dockerConfigKeys(registry string) []string {
+	if registry == "docker.io" || registry == "index.docker.io" || registry == "registry-1.docker.io" {
+		return []string{"https://index.docker.io/v1/", "index.docker.io", "docker.io", "registry-1.docker.io"}
+	}
+
+	return []string{registry, fmt.Sprintf("https://%s", registry), fmt.Sprintf("http://%s", registry)}
+}
+
+// authCredentialHelper gets the credentials of a registry from a docker-credential-* program using the
+// credential helper protocol.
+func authCredentialHelper(helper string, registry string) (*types.DockerAuthConfig, error) {
+	if !strings.HasPrefix(helper, credentialHelperPrefix) {
+		helper = credentialHelperPrefix + helper
+	}
+
+	p := client.NewShellProgramFunc(helper)
+
+	var lastErr error
+
+	for _, key := range dockerConfigKeys(registry) {
+		creds, err := client.Get(p, key)
+		if err != nil {
+			if credentials.IsErrCredentialsNotFound(err) {
+				continue
+			}
+
+			lastErr = err
+			continue
+		}
+
+		// Helpers return identity tokens with a special username
+		if creds.Username == "<token>" {
+			return &types.DockerAuthConfig{IdentityToken: creds.Secret}, nil
+		}
+
+		return &types.DockerAuthConfig{Username: creds.Username, Password: creds.Secret}, nil
+	}
+
+	if lastErr != nil {
+		return nil, fmt.Errorf("credential helper %s failed: %w", helper, lastErr)
+	}
+
+	return nil, fmt.Errorf("no credentials for %s in credential helper %s", registry, helper)
+}
+
+// authFromFile gets the credentials of a registry from a Docker/Podman config.json, honoring its
+// credHelpers and credsStore entries.
+func authFromFile(path string, registry string) (*types.DockerAuthConfig, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg dockerConfigFile
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse auth file %s: %w", path, err)
+	}
+
+	keys := dockerConfigKeys(registry)
+
+	for _, key := range keys {
+		if helper, ok := cfg.CredHelpers[key]; ok {
+			return authCredentialHelper(helper, registry)
+		}
+	}
+
+	for _, key := range keys {
+		auth, ok := cfg.Auths[key]
+		if !ok {
+			continue
+		}
+
+		if auth.IdentityToken != "" {
+			return &types.DockerAuthConfig{Username: auth.Username, IdentityToken: auth.IdentityToken}, nil
+		}
+
+		if auth.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode auth for %s in %s: %w", key, path, err)
+			}
+
+			username, password, ok := strings.Cut(string(decoded), ":")
+			if !ok {
+				return nil, fmt.Errorf("invalid auth for %s in %s", key, path)
+			}
+
+			return &types.DockerAuthConfig{Username: username, Password: password}, nil
+		}
+
+		if auth.Username != "" || auth.Password != "" {
+			return &types.DockerAuthConfig{Username: auth.Username, Password: auth.Password}, nil
+		}
+	}
+
+	if cfg.CredsStore != "" {
+		return authCredentialHelper(cfg.CredsStore, registry)
+	}
+
+	return nil, fmt.Errorf("no credentials for %s in auth file %s", registry, path)
+}
@@ -0,0 +1,102 @@
+package sync
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// setupCredentialHelper installs a docker-credential-test program that returns fixed credentials for ghcr.io.
+func setupCredentialHelper(t *testing.T) {
+	dir := t.TempDir()
+
+	script := `#!/bin/sh
+read server
+if [ "$server" = "ghcr.io" ]; then
+  echo '{"ServerURL":"ghcr.io","Username":"helperuser","Secret":"helpersecret"}'
+  exit 0
+fi
+if [ "$server" = "quay.io" ]; then
+  echo '{"ServerURL":"quay.io","Username":"<token>","Secret":"helpertoken"}'
+  exit 0
+fi
+echo "credentials not found in native keychain"
+exit 1
+`
+
+	if err := os.WriteFile(filepath.Join(dir, "docker-credential-test"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("PATH", fmt.Sprintf("%s%c%s", dir, os.PathListSeparator, os.Getenv("PATH")))
+}
+
+func TestAuthCredentialHelper(t *testing.T) {
+	setupCredentialHelper(t)
+
+	auth, err := authCredentialHelper("docker-credential-test", "ghcr.io")
+	assert.NoError(t, err)
+	assert.Equal(t, "helperuser", auth.Username)
+	assert.Equal(t, "helpersecret", auth.Password)
+
+	auth, err = authCredentialHelper("test", "quay.io")
+	assert.NoError(t, err)
+	assert.Equal(t, "helpertoken", auth.IdentityToken)
+
+	_, err = authCredentialHelper("docker-credential-test", "unknown.io")
+	assert.Error(t, err)
+}
+
+func TestAuthFromFile(t *testing.T) {
+	setupCredentialHelper(t)
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	content := fmt.Sprintf(`{
+  "auths": {
+    "https://index.docker.io/v1/": {"auth": %q},
+    "registry.example.com": {"identitytoken": "filetoken"}
+  },
+  "credHelpers": {
+    "ghcr.io": "test"
+  },
+  "credsStore": "test"
+}`, base64.StdEncoding.EncodeToString([]byte("fileuser:filepass")))
+
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		registry      string
+		username      string
+		password      string
+		identityToken string
+		wantErr       bool
+	}{
+		{name: "auths entry", registry: "docker.io", username: "fileuser", password: "filepass"},
+		{name: "identity token", registry: "registry.example.com", identityToken: "filetoken"},
+		{name: "credHelpers entry", registry: "ghcr.io", username: "helperuser", password: "helpersecret"},
+		{name: "credsStore", registry: "quay.io", identityToken: "helpertoken"},
+		{name: "not found", registry: "unknown.io", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth, err := authFromFile(path, tt.registry)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.username, auth.Username)
+			assert.Equal(t, tt.password, auth.Password)
+			assert.Equal(t, tt.identityToken, auth.IdentityToken)
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"io"
 
+	"github.com/Altinity/docker-sync/internal/admin"
 	"github.com/Altinity/docker-sync/internal/telemetry"
 	"github.com/containers/image/v5/types"
 	"go.opentelemetry.io/otel/attribute"
@@ -20,18 +21,7 @@ func (s s3DataCounter) Read(p []byte) (int, error) {
 	n, err := s.f.Read(p)
 
 	if err == nil && n > 0 {
-		telemetry.UploadedBytes.Add(s.ctx, int64(n),
-			metric.WithAttributes(
-				attribute.KeyValue{
-					Key:   "destination",
-					Value: attribute.StringValue(s.dest),
-				},
-				attribute.KeyValue{
-					Key:   "type",
-					Value: attribute.StringValue("s3"),
-				},
-			),
-		)
+		countS3Upload(s.ctx, s.dest, n)
 	}
 
 	return n, err
@@ -41,6 +31,41 @@ func (s s3DataCounter) Seek(offset int64, whence int) (int64, error) {
 	return s.f.Seek(offset, whence)
 }
 
+// s3StreamCounter counts the bytes of a blob streamed to a bucket. Unlike s3DataCounter, it isn't seekable, so the
+// uploader doesn't try to rewind it.
+type s3StreamCounter struct {
+	ctx  context.Context
+	r    io.Reader
+	dest string
+	size int64
+}
+
+func (s *s3StreamCounter) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+
+	if n > 0 {
+		s.size += int64(n)
+		countS3Upload(s.ctx, s.dest, n)
+	}
+
+	return n, err
+}
+
+func countS3Upload(ctx context.Context, dest string, n int) {
+	telemetry.UploadedBytes.Add(ctx, int64(n),
+		metric.WithAttributes(
+			attribute.KeyValue{
+				Key:   "destination",
+				Value: attribute.StringValue(dest),
+			},
+			attribute.KeyValue{
+				Key:   "type",
+				Value: attribute.StringValue("s3"),
+			},
+		),
+	)
+}
+
 func dockerDataCounter(ctx context.Context, src string, dst string, ch chan types.ProgressProperties) {
 	for {
 		select {
@@ -48,6 +73,8 @@ func dockerDataCounter(ctx context.Context, src string, dst string, ch chan type
 			return
 		case p := <-ch:
 			if p.OffsetUpdate > 0 {
+				admin.Progress()
+
 				telemetry.DownloadedBytes.Add(ctx, int64(p.OffsetUpdate),
 					metric.WithAttributes(
 						attribute.KeyValue{
@@ -0,0 +1,233 @@
+package sync
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/Altinity/docker-sync/config"
+	"github.com/Altinity/docker-sync/internal/telemetry"
+	"github.com/Altinity/docker-sync/structs"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/containers/image/v5/docker"
+	"github.com/opencontainers/go-digest"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// actionVerify marks an immutable tag that already exists in a target and whose digest must be checked.
+// It never leaves checkDigests.
+const actionVerify ActionType = "verify"
+
+// checkDigests compares the source and target manifest digests of a tag, dropping overwrites of
+// tags that did not move and resolving the verification of immutable tags. It only reads the state, as plans
+// call it too: the state is recorded by syncActions once a tag is pushed.
+func checkDigests(ctx context.Context, image *structs.Image, tag string, actions []Action) []Action {
+	var srcDigest string
+	var srcErr error
+	var checked bool
+
+	var result []Action
+
+	for _, action := range actions {
+		if action.Type == ActionCopy || (action.Type == ActionOverwrite && !config.SyncCompareDigests.Bool()) {
+			result = append(result, action)
+			continue
+		}
+
+		if !checked {
+			srcDigest, srcErr = getSourceDigest(ctx, image, tag)
+			checked = true
+		}
+
+		if entry, ok := getStateEntry(ctx, image.Source, tag, action.Target); ok && srcErr == nil && entry.Digest == srcDigest {
+			log.Debug().
+				Str("image", image.Source).
+				Str("tag", tag).
+				Str("target", action.Target).
+				Str("digest", srcDigest).
+				Msg("Tag digest unchanged since last sync, skipping")
+
+			continue
+		}
+
+		dstDigest, err := getTargetDigest(ctx, image, action.Target, tag)
+		if err == nil {
+			err = srcErr
+		}
+		if err != nil {
+			log.Warn().
+				Err(err).
+				Str("image", image.Source).
+				Str("tag", tag).
+				Str("target", action.Target).
+				Msg("Failed to compare digests")
+
+			// Without digests, mutable tags are pushed and immutable tags are left alone
+			if action.Type == ActionOverwrite {
+				result = append(result, action)
+			}
+
+			continue
+		}
+
+		if srcDigest == dstDigest {
+			log.Debug().
+				Str("image", image.Source).
+				Str("tag", tag).
+				Str("target", action.Target).
+				Str("digest", srcDigest).
+				Msg("Tag digest unchanged, skipping")
+
+			continue
+		}
+
+		if action.Type == actionVerify {
+			log.Warn().
+				Str("image", image.Source).
+				Str("tag", tag).
+				Str("target", action.Target).
+				Str("sourceDigest", srcDigest).
+				Str("targetDigest", dstDigest).
+				Msg("Immutable tag was overwritten in the source")
+
+			telemetry.ImmutableTagChanges.Add(ctx, 1,
+				metric.WithAttributes(
+					attribute.KeyValue{
+						Key:   "image",
+						Value: attribute.StringValue(image.Source),
+					},
+					attribute.KeyValue{
+						Key:   "tag",
+						Value: attribute.StringValue(tag),
+					},
+					attribute.KeyValue{
+						Key:   "target",
+						Value: attribute.StringValue(action.Target),
+					},
+				),
+			)
+
+			if image.ImmutableTagDrift != "resync" {
+				continue
+			}
+
+			action.Type = ActionOverwrite
+		}
+
+		result = append(result, action)
+	}
+
+	// The digest is passed on, so it isn't read again to record the pushes in the state
+	if checked && srcErr == nil {
+		for i := range result {
+			result[i].Digest = srcDigest
+		}
+	}
+
+	return result
+}
+
+// getSourceDigest returns the manifest digest of a source tag.
+func getSourceDigest(ctx context.Context, image *structs.Image, tag string) (string, error) {
+	srcRef, err := getSourceReference(image, tag)
+	if err != nil {
+		return "", err
+	}
+
+	srcCtx, _ := getSourceContext(ctx, image)
+
+	// The target holds a rewritten index, so its digest must be computed from the source manifest
+	if len(image.Platforms) > 0 && image.PlatformIndex == "rewrite" {
+		selection, err := selectPlatforms(ctx, image, srcRef, srcCtx)
+		if err != nil {
+			return "", err
+		}
+
+		if selection.index != nil {
+			return digest.FromBytes(selection.index).String(), nil
+		}
+	}
+
+	return getReferenceDigest(ctx, srcRef, srcCtx)
+}
+
+// getTargetDigest returns the manifest digest of a tag in a target.
+func getTargetDigest(ctx context.Context, image *structs.Image, dst string, tag string) (string, error) {
+	switch getRepositoryType(dst) {
+	case S3CompatibleRepository:
+		fields := strings.Split(dst, ":")
+
+		var s3Session *s3.Client
+		var bucket *string
+		var err error
+
+		switch fields[0] {
+		case "r2":
+			s3Session, bucket, err = getR2Session(dst)
+		case "s3":
+			s3Session, bucket, err = getS3Session(dst)
+		default:
+			return "", fmt.Errorf("unsupported bucket destination: %s", dst)
+		}
+		if err != nil {
+			return "", err
+		}
+
+		return getS3ManifestDigest(ctx, s3Session, bucket, filepath.Join("v2", fields[3], "manifests", tag))
+	case OCILayoutRepository:
+		return getOCILayoutDigest(dst, tag)
+	case OCIRepository:
+		dstRef, err := docker.ParseReference(fmt.Sprintf("//%s:%s", dst, tag))
+		if err != nil {
+			return "", err
+		}
+
+		dstCtx, _ := getRegistryContext(ctx, image.GetRegistry(dst), image.GetRepository(dst))
+
+		d, err := docker.GetDigest(ctx, dstCtx, dstRef)
+		if err != nil {
+			return "", err
+		}
+
+		return d.String(), nil
+	default:
+		return "", fmt.Errorf("unsupported repository type")
+	}
+}
+
+// getS3ManifestDigest returns the digest of a manifest object, using the digest stored in its metadata when available.
+func getS3ManifestDigest(ctx context.Context, s3Session *s3.Client, bucket *string, key string) (string, error) {
+	exists, digest, err := s3ObjectExists(ctx, s3Session, bucket, key)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", fmt.Errorf("manifest %s not found in bucket %s", key, *bucket)
+	}
+	if digest != "" {
+		return digest, nil
+	}
+
+	// Objects uploaded by other tools don't have the digest metadata
+	resp, err := s3Session.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: bucket,
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, resp.Body); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("sha256:%x", h.Sum(nil)), nil
+}
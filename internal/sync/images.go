@@ -5,15 +5,17 @@ import (
 	"fmt"
 	"slices"
 	"strings"
+	stdsync "sync"
 
+	"github.com/Altinity/docker-sync/config"
+	"github.com/Altinity/docker-sync/internal/admin"
 	"github.com/Altinity/docker-sync/internal/telemetry"
 	"github.com/Altinity/docker-sync/structs"
 	"github.com/cenkalti/backoff/v4"
-	"github.com/containers/image/v5/docker"
-	"github.com/containers/image/v5/types"
 	"github.com/rs/zerolog/log"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/metric"
+	"golang.org/x/sync/errgroup"
 )
 
 func checkRateLimit(err error) error {
@@ -30,26 +32,21 @@ func checkRateLimit(err error) error {
 	return backoff.Permanent(err)
 }
 
-func SyncImage(ctx context.Context, image *structs.Image) error {
-	log.Info().
-		Str("image", image.Source).
-		Strs("targets", image.Targets).
-		Msg("Syncing image")
-
-	srcRef, err := docker.ParseReference(fmt.Sprintf("//%s", image.Source))
+// prepareImage lists the source and destination tags of an image. With includeReferrers, the referrer tags of the
+// source are returned apart from the tags to sync, as they are synced along with their subject regardless of the
+// tag filters.
+func prepareImage(ctx context.Context, image *structs.Image) ([]string, []string, []string, error) {
+	srcRef, err := getSourceReference(image, "")
 	if err != nil {
-		return err
+		return nil, nil, nil, err
 	}
 	image.SrcRef = srcRef
 
-	srcAuth, srcAuthName := getSkopeoAuth(ctx, image.GetSourceRegistry(), image.GetSourceRepository())
-	srcCtx := &types.SystemContext{
-		DockerAuthConfig: srcAuth,
-	}
+	srcCtx, srcAuthName := getSourceContext(ctx, image)
 
-	srcTags, err := getSourceTags(ctx, image, srcCtx, srcRef)
+	srcTags, allTags, err := getSourceTags(ctx, image, srcCtx, srcRef)
 	if err != nil {
-		return err
+		return nil, nil, nil, err
 	}
 
 	if len(srcTags) == 0 {
@@ -58,7 +55,7 @@ func SyncImage(ctx context.Context, image *structs.Image) error {
 			Str("auth", srcAuthName).
 			Msg("No source tags found, skipping image")
 
-		return nil
+		return nil, nil, nil, nil
 	}
 
 	telemetry.MonitoredTags.Record(ctx, int64(len(srcTags)),
@@ -76,13 +73,57 @@ func SyncImage(ctx context.Context, image *structs.Image) error {
 		Int("tags", len(srcTags)).
 		Msg("Found source tags")
 
-	// Get all tags from targets
-	dstTags, err := getDstTags(ctx, image)
+	admin.TagsFound(image.Source, len(srcTags))
+
+	// Targets are only listed when the state doesn't record all tags, or all target tags are needed
+	dstTags, complete := stateDstTags(ctx, image, syncedTags(image, srcTags))
+	if complete && !image.Purge && !image.IncludeReferrers {
+		log.Info().
+			Str("image", image.Source).
+			Msg("All tags found in state, skipping target listing")
+	} else {
+		dstTags, err = getDstTags(ctx, image)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	if !image.IncludeReferrers {
+		return srcTags, nil, dstTags, nil
+	}
+
+	if allTags == nil {
+		allTags, err = listSourceTags(ctx, image, srcCtx, srcRef)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	return withoutReferrerTags(srcTags), onlyReferrerTags(allTags), dstTags, nil
+}
+
+func SyncImage(ctx context.Context, image *structs.Image) error {
+	log.Info().
+		Str("image", image.Source).
+		Strs("targets", image.Targets).
+		Msg("Syncing image")
+
+	srcTags, srcReferrerTags, dstTags, err := prepareImage(ctx, image)
 	if err != nil {
 		return err
 	}
 
-	// Sync tags
+	if len(srcTags) == 0 {
+		return nil
+	}
+
+	// Sync tags, keeping the source digests read to find their referrers
+	g, _ := errgroup.WithContext(ctx)
+	g.SetLimit(max(1, config.SyncMaxConcurrentTags.Int()))
+
+	digests := make(map[string]string)
+	var digestsMutex stdsync.Mutex
+
 	for _, tag := range srcTags {
 		if slices.Contains(image.IgnoredTags, tag) {
 			log.Info().
@@ -92,11 +133,168 @@ func SyncImage(ctx context.Context, image *structs.Image) error {
 			continue
 		}
 
-		syncTag(ctx, image, tag, dstTags)
+		if ShuttingDown(ctx) {
+			break
+		}
+
+		g.Go(func() error {
+			// Failed pushes are recorded per tag, and don't fail the image
+			if d, _ := syncTag(ctx, image, tag, dstTags); d != "" {
+				digestsMutex.Lock()
+				digests[tag] = d
+				digestsMutex.Unlock()
+			}
+
+			return nil
+		})
+	}
+
+	_ = g.Wait()
+
+	// Referrers and purge are left to the next sync, the tags synced so far are still indexed and recorded
+	if ShuttingDown(ctx) {
+		log.Info().
+			Str("image", image.Source).
+			Msg("Shutdown requested, skipping referrers and purge")
+
+		updateS3Indexes(ctx, image)
+		saveSyncState(ctx, image)
+
+		return nil
+	}
+
+	// Referrers are attached to the synced tags, so they are kept by purge
+	keepTags := srcTags
+
+	if image.IncludeReferrers {
+		referrerTags, err := syncReferrers(ctx, image, srcReferrerTags, syncedTags(image, srcTags), digests, dstTags)
+		if err != nil {
+			return fmt.Errorf("failed to sync referrers: %w", err)
+		}
+
+		keepTags = append(slices.Clone(srcTags), referrerTags...)
 	}
 
 	// Purge
-	purge(ctx, image, srcTags, dstTags)
+	purge(ctx, image, keepTags, dstTags)
+
+	updateS3Indexes(ctx, image)
+	saveSyncState(ctx, image)
 
 	return nil
 }
+
+// SyncImageTag syncs a single tag of an image, e.g. on a push event, without listing the source tags. The tag
+// must be selected by the image. Purge is left to the next sync of the image. It returns an error if the tag
+// failed to be pushed to a target.
+func SyncImageTag(ctx context.Context, image *structs.Image, tag string) error {
+	if !SelectsTag(image, tag) {
+		return fmt.Errorf("tag %s is not synced by the image", tag)
+	}
+
+	log.Info().
+		Str("image", image.Source).
+		Str("tag", tag).
+		Strs("targets", image.Targets).
+		Msg("Syncing image tag")
+
+	// The image is shared with the syncs of its schedule, so it is only read
+	srcRef, err := getSourceReference(image, "")
+	if err != nil {
+		return err
+	}
+
+	dstTags, complete := stateDstTags(ctx, image, []string{tag})
+	if !complete || image.IncludeReferrers {
+		dstTags, err = getDstTags(ctx, image)
+		if err != nil {
+			return err
+		}
+	}
+
+	d, tagErr := syncTag(ctx, image, tag, dstTags)
+
+	if image.IncludeReferrers && !ShuttingDown(ctx) {
+		srcCtx, _ := getSourceContext(ctx, image)
+
+		// The source tags are only listed for the referrer tags
+		allTags, err := listSourceTags(ctx, image, srcCtx, srcRef)
+		if err != nil {
+			return fmt.Errorf("failed to sync referrers: %w", err)
+		}
+
+		if _, err := syncReferrers(ctx, image, onlyReferrerTags(allTags), []string{tag}, map[string]string{tag: d}, dstTags); err != nil {
+			return fmt.Errorf("failed to sync referrers: %w", err)
+		}
+	}
+
+	updateS3Indexes(ctx, image)
+	saveSyncState(ctx, image)
+
+	if tagErr != nil {
+		return fmt.Errorf("failed to sync tag %s: %w", tag, tagErr)
+	}
+
+	return nil
+}
+
+func saveSyncState(ctx context.Context, image *structs.Image) {
+	if err := saveState(ctx); err != nil {
+		log.Error().
+			Err(err).
+			Str("image", image.Source).
+			Msg("Failed to save state")
+	}
+}
+
+// syncedTags returns the source tags that are synced, skipping ignored tags.
+func syncedTags(image *structs.Image, srcTags []string) []string {
+	return slices.DeleteFunc(slices.Clone(srcTags), func(tag string) bool {
+		return slices.Contains(image.IgnoredTags, tag)
+	})
+}
+
+// PlanImage returns the actions SyncImage would perform for an image, without performing them.
+func PlanImage(ctx context.Context, image *structs.Image) ([]Action, error) {
+	srcTags, srcReferrerTags, dstTags, err := prepareImage(ctx, image)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(srcTags) == 0 {
+		return nil, nil
+	}
+
+	var actions []Action
+
+	for _, tag := range syncedTags(image, srcTags) {
+		actions = append(actions, checkDigests(ctx, image, tag, planTag(image, tag, dstTags))...)
+	}
+
+	keepTags := srcTags
+
+	if image.IncludeReferrers {
+		referrerActions, referrerTags, err := planReferrers(ctx, image, srcReferrerTags, syncedTags(image, srcTags), dstTags)
+		if err != nil {
+			return nil, fmt.Errorf("failed to plan referrers: %w", err)
+		}
+
+		actions = append(actions, referrerActions...)
+		keepTags = append(slices.Clone(srcTags), referrerTags...)
+	}
+
+	if image.Purge {
+		for _, dst := range image.Targets {
+			for _, tag := range planPurge(image, dst, keepTags, dstTags) {
+				actions = append(actions, Action{
+					Image:  image.Source,
+					Target: dst,
+					Tag:    tag,
+					Type:   ActionDelete,
+				})
+			}
+		}
+	}
+
+	return actions, nil
+}
@@ -43,17 +43,13 @@ func deleteTag(ctx context.Context, image *structs.Image, dst string, tag string
 
 			return nil
 		case OCIRepository:
-			dstAuth, _ := getSkopeoAuth(ctx, image.GetRegistry(dst), image.GetRepository(dst))
+			dstCtx, _ := getRegistryContext(ctx, image.GetRegistry(dst), image.GetRepository(dst))
 
 			dstRef, err := docker.ParseReference(fmt.Sprintf("//%s:%s", dst, tag))
 			if err != nil {
 				return err
 			}
 
-			dstCtx := &types.SystemContext{
-				DockerAuthConfig: dstAuth,
-			}
-
 			ch := make(chan types.ProgressProperties)
 			defer close(ch)
 
@@ -64,6 +60,8 @@ func deleteTag(ctx context.Context, image *structs.Image, dst string, tag string
 			err = dstRef.DeleteImage(chCtx, dstCtx)
 
 			return checkRateLimit(err)
+		case OCILayoutRepository:
+			return backoff.Permanent(deleteOCILayout(ctx, dst, tag))
 		default:
 			return fmt.Errorf("unsupported repository type")
 		}
@@ -87,14 +85,7 @@ func purge(ctx context.Context, image *structs.Image, srcTags []string, dstTags
 
 	// Purge tags
 	for _, dst := range image.Targets {
-		var toPurge []string
-
-		for _, tag := range dstTags {
-			tag = strings.TrimPrefix(tag, fmt.Sprintf("%s:", dst))
-			if !slices.Contains(srcTags, tag) && !slices.Contains(image.MutableTags, tag) {
-				toPurge = append(toPurge, tag)
-			}
-		}
+		toPurge := planPurge(image, dst, srcTags, dstTags)
 
 		if len(toPurge) == 0 {
 			log.Debug().
@@ -107,9 +98,6 @@ func purge(ctx context.Context, image *structs.Image, srcTags []string, dstTags
 			continue
 		}
 
-		slices.Sort(toPurge)
-		toPurge = slices.Compact(toPurge)
-
 		log.Info().
 			Str("image", image.Source).
 			Str("target", dst).
@@ -169,6 +157,8 @@ func purge(ctx context.Context, image *structs.Image, srcTags []string, dstTags
 							},
 						),
 					)
+				} else {
+					deleteStateEntry(ctx, image.Source, tag, dst)
 				}
 
 				return nil
@@ -181,8 +171,60 @@ func purge(ctx context.Context, image *structs.Image, srcTags []string, dstTags
 	}
 }
 
+// planPurge returns the tags of a target that are no longer present in the source.
+func planPurge(image *structs.Image, dst string, srcTags []string, dstTags []string) []string {
+	var toPurge []string
+
+	prefix := fmt.Sprintf("%s:", dst)
+
+	for _, tag := range dstTags {
+		// dstTags holds the tags of all targets
+		if !strings.HasPrefix(tag, prefix) {
+			continue
+		}
+
+		tag = strings.TrimPrefix(tag, prefix)
+		if !slices.Contains(srcTags, tag) && !slices.Contains(image.MutableTags, tag) {
+			toPurge = append(toPurge, tag)
+		}
+	}
+
+	slices.Sort(toPurge)
+
+	return slices.Compact(toPurge)
+}
+
 func purgeOrphans(ctx context.Context, image *structs.Image, dst string) {
-	// Remove orphaned blobs
+	if getRepositoryType(dst) == OCILayoutRepository {
+		if err := deleteOrphanedBlobsOCILayout(ctx, dst); err != nil {
+			log.Error().
+				Err(err).
+				Str("image", image.Source).
+				Str("target", dst).
+				Msg("Failed to delete orphaned blobs")
+
+			telemetry.PurgeErrors.Add(ctx, 1,
+				metric.WithAttributes(
+					attribute.KeyValue{
+						Key:   "image",
+						Value: attribute.StringValue(image.Source),
+					},
+					attribute.KeyValue{
+						Key:   "target",
+						Value: attribute.StringValue(dst),
+					},
+					attribute.KeyValue{
+						Key:   "error",
+						Value: attribute.StringValue(err.Error()),
+					},
+				),
+			)
+		}
+
+		return
+	}
+
+	// Remove orphaned manifests and blobs
 	if strings.HasPrefix(dst, "r2:") || strings.HasPrefix(dst, "s3:") {
 		var s3Session *s3.Client
 		var bucket *string
@@ -221,12 +263,18 @@ func purgeOrphans(ctx context.Context, image *structs.Image, dst string) {
 			return
 		}
 
-		if err := deleteOrphanedBlobsS3(ctx, s3Session, *bucket, image.GetRepository(dst)); err != nil {
+		_, err = deleteOrphansS3(ctx, s3Session, *bucket, image.GetRepository(dst))
+		if err == nil && isSharedBlobs(dst) {
+			// Blobs of the shared store are only deleted once no repository links to them anymore
+			scheduleSharedGC(dst, s3Session, *bucket)
+		}
+
+		if err != nil {
 			log.Error().
 				Err(err).
 				Str("image", image.Source).
 				Str("target", dst).
-				Msg("Failed to delete orphaned blobs")
+				Msg("Failed to delete orphaned objects")
 
 			telemetry.PurgeErrors.Add(ctx, 1,
 				metric.WithAttributes(
@@ -11,18 +11,29 @@ import (
 	"github.com/cenkalti/backoff/v4"
 	"github.com/containers/image/v5/copy"
 	"github.com/containers/image/v5/docker"
-	"github.com/containers/image/v5/signature"
 	"github.com/containers/image/v5/types"
 	"github.com/rs/zerolog/log"
 )
 
 func push(ctx context.Context, image *structs.Image, dst string, tag string) error {
+	return pushIndex(ctx, image, dst, tag, nil)
+}
+
+// pushIndex pushes a tag to a target from index, an index built by docker-sync, or from the source tag if index is
+// nil.
+func pushIndex(ctx context.Context, image *structs.Image, dst string, tag string, index *indexSourceReference) error {
 	return backoff.RetryNotify(func() error {
+		release, err := acquireRegistries(ctx, image, dst)
+		if err != nil {
+			return backoff.Permanent(err)
+		}
+		defer release()
+
 		switch getRepositoryType(dst) {
 		case S3CompatibleRepository:
 			fields := strings.Split(dst, ":")
 
-			var fn func(context.Context, *structs.Image, string, string, string) error
+			var fn func(context.Context, *structs.Image, string, string, string, *indexSourceReference) error
 
 			switch fields[0] {
 			case "r2":
@@ -33,54 +44,82 @@ func push(ctx context.Context, image *structs.Image, dst string, tag string) err
 				return fmt.Errorf("unsupported bucket destination: %s", dst)
 			}
 
-			if err := fn(ctx, image, dst, fields[3], tag); err != nil {
+			if err := fn(ctx, image, dst, fields[3], tag, index); err != nil {
+				if isSignatureError(err) {
+					return backoff.Permanent(err)
+				}
+
 				return err
 			}
 
 			return nil
 		case OCIRepository:
-			srcAuth, _ := getSkopeoAuth(ctx, image.GetSourceRegistry(), image.GetSourceRepository())
-			dstAuth, _ := getSkopeoAuth(ctx, image.GetRegistry(dst), image.GetRepository(dst))
+			dstCtx, _ := getRegistryContext(ctx, image.GetRegistry(dst), image.GetRepository(dst))
 
 			dstRef, err := docker.ParseReference(fmt.Sprintf("//%s:%s", dst, tag))
 			if err != nil {
 				return err
 			}
 
-			srcRef, err := docker.ParseReference(fmt.Sprintf("//%s:%s", image.Source, tag))
+			srcRef, err := getPushReference(image, tag, index)
 			if err != nil {
 				return err
 			}
 
-			srcCtx := &types.SystemContext{
-				DockerAuthConfig: srcAuth,
-			}
-			dstCtx := &types.SystemContext{
-				DockerAuthConfig: dstAuth,
-			}
+			srcCtx, _ := getSourceContext(ctx, image)
 
-			policy := &signature.Policy{Default: []signature.PolicyRequirement{signature.NewPRInsecureAcceptAnything()}}
-			policyContext, err := signature.NewPolicyContext(policy)
+			policyContext, destroy, err := newPolicyContext(image, srcCtx)
 			if err != nil {
-				return err
+				return backoff.Permanent(err)
 			}
+			defer destroy()
+
+			// Verified signatures are copied, to the same location as in the source
+			dstCtx.RegistriesDirPath = srcCtx.RegistriesDirPath
 
 			ch := make(chan types.ProgressProperties)
 			defer close(ch)
 
+			selection, err := selectPlatforms(ctx, image, srcRef, srcCtx)
+			if err != nil {
+				return checkRateLimit(err)
+			}
+
+			// With a rewritten index, the copy reads the rewritten index instead of the source manifest list, so
+			// the instances that are not selected are never referenced in the target. The source is verified
+			// first, as its signatures don't match the rewritten index.
+			copySrcRef := srcRef
+			if selection.index != nil {
+				verified, destroyVerified, err := verifySource(ctx, policyContext, srcRef, srcCtx)
+				if err != nil {
+					return checkRateLimit(err)
+				}
+				defer destroyVerified()
+
+				copySrcRef = &indexSourceReference{ImageReference: srcRef, index: selection.index}
+				policyContext = verified
+			}
+
 			chCtx, cancel := context.WithCancel(ctx)
 			defer cancel()
 			go dockerDataCounter(chCtx, image.Source, dst, ch)
 
-			_, err = copy.Image(ctx, policyContext, dstRef, srcRef, &copy.Options{
+			_, err = copy.Image(ctx, policyContext, dstRef, copySrcRef, &copy.Options{
 				SourceCtx:          srcCtx,
 				DestinationCtx:     dstCtx,
-				ImageListSelection: copy.CopyAllImages,
+				ImageListSelection: selection.selection,
+				Instances:          selection.instances,
+				RemoveSignatures:   selection.index != nil || index != nil,
 				ProgressInterval:   time.Second,
 				Progress:           ch,
 			})
+			if err != nil {
+				return checkRateLimit(err)
+			}
 
-			return checkRateLimit(err)
+			return nil
+		case OCILayoutRepository:
+			return checkRateLimit(pushOCILayout(ctx, image, dst, tag, index))
 		default:
 			return fmt.Errorf("unsupported repository type")
 		}
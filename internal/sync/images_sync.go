@@ -2,10 +2,12 @@ package sync
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"path/filepath"
 	"slices"
 
+	"github.com/Altinity/docker-sync/internal/admin"
 	"github.com/Altinity/docker-sync/internal/telemetry"
 	"github.com/Altinity/docker-sync/structs"
 	"github.com/rs/zerolog/log"
@@ -13,7 +15,9 @@ import (
 	"go.opentelemetry.io/otel/metric"
 )
 
-func syncTag(ctx context.Context, image *structs.Image, tag string, dstTags []string) {
+// syncTag pushes a tag to the targets that need it, and returns its source digest if it was read. Failed pushes
+// are recorded and returned, the other targets are still pushed to.
+func syncTag(ctx context.Context, image *structs.Image, tag string, dstTags []string) (string, error) {
 	// Initialize telemetry for the tag
 	telemetry.TagSyncErrors.Add(ctx, 0,
 		metric.WithAttributes(
@@ -28,30 +32,45 @@ func syncTag(ctx context.Context, image *structs.Image, tag string, dstTags []st
 		),
 	)
 
-	var actualDsts []string
+	recordPresentTags(ctx, image, tag, dstTags)
+
+	actions := checkDigests(ctx, image, tag, planTag(image, tag, dstTags))
+	err := syncActions(ctx, image, tag, actions)
+
+	if len(actions) == 0 {
+		return "", err
+	}
+
+	return actions[0].Digest, err
+}
+
+// recordPresentTags records the immutable tags found in the targets in the state, so the targets don't need to be
+// listed again to know they are present.
+func recordPresentTags(ctx context.Context, image *structs.Image, tag string, dstTags []string) {
+	if !stateEnabled() || isMutableTag(image, tag) {
+		return
+	}
 
-	// Determine the actual destinations for the tag
 	for _, dst := range image.Targets {
-		if !slices.Contains(image.MutableTags, tag) && // Explicitly mutable tags
-			slices.Contains(dstTags, fmt.Sprintf("%s:%s", dst, tag)) && // Check if the tag already exists in the target
-			!slices.ContainsFunc(image.MutableTags, func(t string) bool {
-				match, _ := filepath.Match(t, tag)
-				return match
-			}) && // Check if the tag matches any mutable tag patterns
-			!slices.Contains(image.MutableTags, "*") { // All tags are mutable if "*" is present
+		if !slices.Contains(dstTags, fmt.Sprintf("%s:%s", dst, tag)) {
 			continue
 		}
 
-		actualDsts = append(actualDsts, dst)
+		if _, ok := getStateEntry(ctx, image.Source, tag, dst); !ok {
+			setStateEntry(ctx, image.Source, tag, dst, "")
+		}
 	}
+}
 
-	if len(actualDsts) == 0 {
+// syncActions pushes a tag to the targets of its actions, and returns the errors of the failed pushes.
+func syncActions(ctx context.Context, image *structs.Image, tag string, actions []Action) error {
+	if len(actions) == 0 {
 		log.Debug().
 			Str("image", image.Source).
 			Str("tag", tag).
-			Msg("Tag already exists in all targets, skipping")
+			Msg("Tag is up to date in all targets, skipping")
 
-		return
+		return nil
 	}
 
 	log.Info().
@@ -60,14 +79,63 @@ func syncTag(ctx context.Context, image *structs.Image, tag string, dstTags []st
 		Strs("targets", image.Targets).
 		Msg("Syncing tag")
 
-	for _, dst := range actualDsts {
+	// The source digest is read before pushing, unless checkDigests already did, so a tag moving during the push
+	// is pushed again by the next sync
+	srcDigest := actions[0].Digest
+	if srcDigest == "" && stateEnabled() {
+		d, err := getSourceDigest(ctx, image, tag)
+		if err != nil {
+			log.Warn().
+				Err(err).
+				Str("image", image.Source).
+				Str("tag", tag).
+				Msg("Failed to get source digest, tag won't be recorded in state")
+		}
+		srcDigest = d
+	}
+
+	var errs []error
+
+	for _, action := range actions {
+		dst := action.Target
+
 		if err := push(ctx, image, dst, tag); err != nil {
+			errs = append(errs, fmt.Errorf("failed to push to %s: %w", dst, err))
+
+			if isSignatureError(err) {
+				log.Error().
+					Err(err).
+					Str("image", image.Source).
+					Str("tag", tag).
+					Str("target", dst).
+					Msg("Tag refused by signature policy")
+
+				admin.TagFailed(image.Source, tag, err)
+
+				telemetry.SignatureVerificationFailures.Add(ctx, 1,
+					metric.WithAttributes(
+						attribute.KeyValue{
+							Key:   "image",
+							Value: attribute.StringValue(image.Source),
+						},
+						attribute.KeyValue{
+							Key:   "tag",
+							Value: attribute.StringValue(tag),
+						},
+					),
+				)
+
+				continue
+			}
+
 			log.Error().
 				Err(err).
 				Str("image", image.Source).
 				Str("tag", tag).
 				Msg("Failed to sync tag")
 
+			admin.TagFailed(image.Source, tag, err)
+
 			telemetry.TagSyncErrors.Add(ctx, 1,
 				metric.WithAttributes(
 					attribute.KeyValue{
@@ -85,6 +153,12 @@ func syncTag(ctx context.Context, image *structs.Image, tag string, dstTags []st
 				),
 			)
 		} else {
+			admin.TagPushed(image.Source)
+
+			if srcDigest != "" {
+				setStateEntry(ctx, image.Source, tag, dst, srcDigest)
+			}
+
 			telemetry.Pushes.Add(ctx, 1,
 				metric.WithAttributes(
 					attribute.KeyValue{
@@ -103,4 +177,44 @@ func syncTag(ctx context.Context, image *structs.Image, tag string, dstTags []st
 			)
 		}
 	}
+
+	return errors.Join(errs...)
+}
+
+// planTag determines which targets a tag must be pushed to, before comparing digests.
+func planTag(image *structs.Image, tag string, dstTags []string) []Action {
+	var actions []Action
+
+	for _, dst := range image.Targets {
+		action := ActionCopy
+
+		if slices.Contains(dstTags, fmt.Sprintf("%s:%s", dst, tag)) { // Check if the tag already exists in the target
+			switch {
+			case isMutableTag(image, tag):
+				action = ActionOverwrite
+			case image.ImmutableTagDrift == "warn" || image.ImmutableTagDrift == "resync":
+				action = actionVerify
+			default:
+				continue
+			}
+		}
+
+		actions = append(actions, Action{
+			Image:  image.Source,
+			Target: dst,
+			Tag:    tag,
+			Type:   action,
+		})
+	}
+
+	return actions
+}
+
+func isMutableTag(image *structs.Image, tag string) bool {
+	return slices.Contains(image.MutableTags, tag) || // Explicitly mutable tags
+		slices.ContainsFunc(image.MutableTags, func(t string) bool {
+			match, _ := filepath.Match(t, tag)
+			return match
+		}) || // Check if the tag matches any mutable tag patterns
+		slices.Contains(image.MutableTags, "*") // All tags are mutable if "*" is present
 }
@@ -13,7 +13,8 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-func getSourceTags(ctx context.Context, image *structs.Image, srcCtx *types.SystemContext, srcRef types.ImageReference) ([]string, error) {
+// getSourceTags returns the source tags selected by an image, and all the source tags if they had to be listed.
+func getSourceTags(ctx context.Context, image *structs.Image, srcCtx *types.SystemContext, srcRef types.ImageReference) ([]string, []string, error) {
 	var srcTags []string
 	var err error
 
@@ -23,9 +24,9 @@ func getSourceTags(ctx context.Context, image *structs.Image, srcCtx *types.Syst
 		for _, tag := range image.Tags {
 			if tag == "@semver" {
 				if allTags == nil {
-					allTags, err = docker.GetRepositoryTags(ctx, srcCtx, srcRef)
+					allTags, err = listSourceTags(ctx, image, srcCtx, srcRef)
 					if err != nil {
-						return nil, err
+						return nil, nil, err
 					}
 				}
 
@@ -36,9 +37,9 @@ func getSourceTags(ctx context.Context, image *structs.Image, srcCtx *types.Syst
 				}
 			} else if strings.Contains(tag, "*") {
 				if allTags == nil {
-					allTags, err = docker.GetRepositoryTags(ctx, srcCtx, srcRef)
+					allTags, err = listSourceTags(ctx, image, srcCtx, srcRef)
 					if err != nil {
-						return nil, err
+						return nil, nil, err
 					}
 				}
 
@@ -52,16 +53,50 @@ func getSourceTags(ctx context.Context, image *structs.Image, srcCtx *types.Syst
 			}
 		}
 	} else {
-		srcTags, err = docker.GetRepositoryTags(ctx, srcCtx, srcRef)
+		allTags, err = listSourceTags(ctx, image, srcCtx, srcRef)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
+
+		srcTags = slices.Clone(allTags)
 	}
 
 	// Remove duplicate tags
 	slices.Sort(srcTags)
 
-	return slices.Compact(srcTags), nil
+	return slices.Compact(srcTags), allTags, nil
+}
+
+// SelectsTag returns whether a tag is synced by an image, as getSourceTags would select it.
+func SelectsTag(image *structs.Image, tag string) bool {
+	if slices.Contains(image.IgnoredTags, tag) {
+		return false
+	}
+
+	if _, ok := isReferrerTag(tag); ok && image.IncludeReferrers {
+		return false
+	}
+
+	if len(image.Tags) == 0 {
+		return true
+	}
+
+	for _, t := range image.Tags {
+		switch {
+		case t == "@semver":
+			if isSemVerTag(tag) {
+				return true
+			}
+		case strings.Contains(t, "*"):
+			if match, err := filepath.Match(t, tag); err == nil && match {
+				return true
+			}
+		case t == tag:
+			return true
+		}
+	}
+
+	return false
 }
 
 func getDstTags(ctx context.Context, image *structs.Image) ([]string, error) {
@@ -88,17 +123,28 @@ func getDstTags(ctx context.Context, image *structs.Image) ([]string, error) {
 			}
 
 			continue
-		case OCIRepository:
-			dstRef, err := docker.ParseReference(fmt.Sprintf("//%s", dst))
+		case OCILayoutRepository:
+			tags, err := listOCILayoutTags(dst)
 			if err != nil {
 				return nil, err
 			}
 
-			dstAuth, dstAuthName := getSkopeoAuth(ctx, image.GetRegistry(dst), image.GetRepository(dst))
+			if len(tags) > 0 {
+				log.Info().
+					Str("image", image.Source).
+					Str("target", dst).
+					Int("tags", len(tags)).
+					Msg("Found destination tags")
 
-			dstCtx := &types.SystemContext{
-				DockerAuthConfig: dstAuth,
+				dstTags = append(dstTags, tags...)
 			}
+		case OCIRepository:
+			dstRef, err := docker.ParseReference(fmt.Sprintf("//%s", dst))
+			if err != nil {
+				return nil, err
+			}
+
+			dstCtx, dstAuthName := getRegistryContext(ctx, image.GetRegistry(dst), image.GetRepository(dst))
 
 			tags, err := docker.GetRepositoryTags(ctx, dstCtx, dstRef)
 			if err != nil {
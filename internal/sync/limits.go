@@ -0,0 +1,107 @@
+package sync
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/Altinity/docker-sync/config"
+	"github.com/Altinity/docker-sync/structs"
+)
+
+// registryLimiter caps the number of concurrent operations against a single registry.
+type registryLimiter struct {
+	mutex     sync.Mutex
+	semaphore map[string]chan struct{}
+	limit     *config.Key
+}
+
+var (
+	sourceRegistryLimiter = &registryLimiter{
+		semaphore: make(map[string]chan struct{}),
+		limit:     config.SyncMaxConcurrentPerSourceRegistry,
+	}
+	targetRegistryLimiter = &registryLimiter{
+		semaphore: make(map[string]chan struct{}),
+		limit:     config.SyncMaxConcurrentPerTargetRegistry,
+	}
+	// copyLimiter caps the copies of all images and tags, under the single allCopies key
+	copyLimiter = &registryLimiter{
+		semaphore: make(map[string]chan struct{}),
+		limit:     config.SyncMaxConcurrentCopies,
+	}
+)
+
+const allCopies = "*"
+
+// acquire blocks until a slot for the registry is available, and returns a function that releases it.
+// The limit is read on each call, so a reloaded limit applies to the next copies. Copies started under the previous
+// limit keep their slot in the previous semaphore until they finish.
+func (l *registryLimiter) acquire(ctx context.Context, registry string) (func(), error) {
+	limit := max(0, l.limit.Int())
+
+	l.mutex.Lock()
+	sem, ok := l.semaphore[registry]
+	if !ok || cap(sem) != limit {
+		sem = nil
+		if limit > 0 {
+			sem = make(chan struct{}, limit)
+		}
+		l.semaphore[registry] = sem
+	}
+	l.mutex.Unlock()
+
+	// Unlimited
+	if sem == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// getRegistry returns the registry of a source or target, which for buckets is <type>:<region/endpoint>:<bucket>
+// and for layouts is the layout itself.
+func getRegistry(image *structs.Image, url string) string {
+	switch getRepositoryType(url) {
+	case S3CompatibleRepository:
+		fields := strings.Split(url, ":")
+		return strings.Join(fields[:3], ":")
+	case OCILayoutRepository:
+		return url
+	}
+
+	return image.GetRegistry(url)
+}
+
+// acquireRegistries reserves a global copy slot, and a slot in both the source and the target registry of a copy.
+// Slots are always acquired in that order, so copies can't deadlock each other.
+func acquireRegistries(ctx context.Context, image *structs.Image, dst string) (func(), error) {
+	releaseCopy, err := copyLimiter.acquire(ctx, allCopies)
+	if err != nil {
+		return nil, err
+	}
+
+	releaseSrc, err := sourceRegistryLimiter.acquire(ctx, getRegistry(image, image.Source))
+	if err != nil {
+		releaseCopy()
+		return nil, err
+	}
+
+	releaseDst, err := targetRegistryLimiter.acquire(ctx, getRegistry(image, dst))
+	if err != nil {
+		releaseSrc()
+		releaseCopy()
+		return nil, err
+	}
+
+	return func() {
+		releaseDst()
+		releaseSrc()
+		releaseCopy()
+	}, nil
+}
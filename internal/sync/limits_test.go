@@ -0,0 +1,89 @@
+package sync
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Altinity/docker-sync/config"
+	"github.com/Altinity/docker-sync/structs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistryLimiter(t *testing.T) {
+	t.Run("limited", func(t *testing.T) {
+		l := &registryLimiter{
+			semaphore: make(map[string]chan struct{}),
+			limit:     &config.Key{Value: 1},
+		}
+
+		release, err := l.acquire(t.Context(), "docker.io")
+		assert.NoError(t, err)
+
+		// A different registry is not affected
+		releaseOther, err := l.acquire(t.Context(), "ghcr.io")
+		assert.NoError(t, err)
+		releaseOther()
+
+		ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+		defer cancel()
+
+		_, err = l.acquire(ctx, "docker.io")
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+		release()
+
+		release, err = l.acquire(t.Context(), "docker.io")
+		assert.NoError(t, err)
+		release()
+	})
+
+	t.Run("reloaded", func(t *testing.T) {
+		limit := &config.Key{Value: 1}
+		l := &registryLimiter{
+			semaphore: make(map[string]chan struct{}),
+			limit:     limit,
+		}
+
+		release, err := l.acquire(t.Context(), "docker.io")
+		assert.NoError(t, err)
+		defer release()
+
+		// The new limit applies to the next copies
+		limit.Value = 2
+
+		releaseOther, err := l.acquire(t.Context(), "docker.io")
+		assert.NoError(t, err)
+		releaseOther()
+	})
+
+	t.Run("unlimited", func(t *testing.T) {
+		l := &registryLimiter{
+			semaphore: make(map[string]chan struct{}),
+			limit:     &config.Key{Value: 0},
+		}
+
+		for range 10 {
+			_, err := l.acquire(t.Context(), "docker.io")
+			assert.NoError(t, err)
+		}
+	})
+}
+
+func TestGetTargetRegistry(t *testing.T) {
+	image := &structs.Image{}
+
+	tests := []struct {
+		dst      string
+		expected string
+	}{
+		{"r2:account-id:bucket:repo/image", "r2:account-id:bucket"},
+		{"s3:us-east-1:bucket:image", "s3:us-east-1:bucket"},
+		{"ghcr.io/altinity/image", "ghcr.io"},
+		{"altinity/image", "docker.io"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, getRegistry(image, tt.dst))
+	}
+}
@@ -0,0 +1,52 @@
+package sync
+
+import (
+	"encoding/json"
+
+	"github.com/opencontainers/go-digest"
+)
+
+type manifestDescriptor struct {
+	Digest digest.Digest `json:"digest"`
+}
+
+// rawManifest holds the references of the manifest formats a target may store: OCI and Docker image
+// manifests and indexes, and Docker schema 1 manifests.
+type rawManifest struct {
+	Config    *manifestDescriptor  `json:"config"`
+	Layers    []manifestDescriptor `json:"layers"`
+	Manifests []manifestDescriptor `json:"manifests"`
+	FSLayers  []struct {
+		BlobSum digest.Digest `json:"blobSum"`
+	} `json:"fsLayers"`
+}
+
+// parseManifestReferences returns the manifests referenced by an index and the blobs referenced by an image
+// manifest. The subject of a manifest is not returned, as a referrer does not keep its subject alive.
+func parseManifestReferences(b []byte) ([]digest.Digest, []digest.Digest, error) {
+	var m rawManifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, nil, err
+	}
+
+	var manifests []digest.Digest
+	var blobs []digest.Digest
+
+	for _, d := range m.Manifests {
+		manifests = append(manifests, d.Digest)
+	}
+
+	if m.Config != nil && m.Config.Digest != "" {
+		blobs = append(blobs, m.Config.Digest)
+	}
+
+	for _, d := range m.Layers {
+		blobs = append(blobs, d.Digest)
+	}
+
+	for _, l := range m.FSLayers {
+		blobs = append(blobs, l.BlobSum)
+	}
+
+	return manifests, blobs, nil
+}
@@ -0,0 +1,349 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Altinity/docker-sync/structs"
+	"github.com/containers/image/v5/copy"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/oci/layout"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/rs/zerolog/log"
+)
+
+// sourceDigestAnnotation records the source digest of a tag in index.json, as images in the Docker format are
+// converted to OCI and get a different digest.
+const sourceDigestAnnotation = "com.altinity.docker-sync.source.digest"
+
+var (
+	// ociLayoutLocks serializes writes to a layout, as index.json is read and rewritten by every change.
+	ociLayoutLocks      = make(map[string]*sync.Mutex)
+	ociLayoutLocksMutex sync.Mutex
+)
+
+// getOCILayoutPath returns the directory of an oci:<path> target.
+func getOCILayoutPath(dst string) string {
+	return strings.TrimPrefix(dst, "oci:")
+}
+
+func lockOCILayout(path string) func() {
+	ociLayoutLocksMutex.Lock()
+	mutex, ok := ociLayoutLocks[path]
+	if !ok {
+		mutex = &sync.Mutex{}
+		ociLayoutLocks[path] = mutex
+	}
+	ociLayoutLocksMutex.Unlock()
+
+	mutex.Lock()
+
+	return mutex.Unlock
+}
+
+// readOCILayoutIndex reads the index.json of a layout. A layout that does not exist yet has an empty index.
+func readOCILayoutIndex(path string) (*imgspecv1.Index, error) {
+	b, err := os.ReadFile(filepath.Join(path, imgspecv1.ImageIndexFile))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return &imgspecv1.Index{}, nil
+		}
+
+		return nil, err
+	}
+
+	var index imgspecv1.Index
+	if err := json.Unmarshal(b, &index); err != nil {
+		return nil, fmt.Errorf("failed to parse index of %s: %w", path, err)
+	}
+
+	return &index, nil
+}
+
+func writeOCILayoutIndex(path string, index *imgspecv1.Index) error {
+	b, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := filepath.Join(path, imgspecv1.ImageIndexFile+".tmp")
+	if err := os.WriteFile(tmpPath, b, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, filepath.Join(path, imgspecv1.ImageIndexFile))
+}
+
+func getOCILayoutBlobPath(path string, d digest.Digest) string {
+	return filepath.Join(path, imgspecv1.ImageBlobsDir, d.Algorithm().String(), d.Encoded())
+}
+
+// listOCILayoutTags returns the tags of a layout, in the <dst>:<tag> format used for other targets.
+func listOCILayoutTags(dst string) ([]string, error) {
+	index, err := readOCILayoutIndex(getOCILayoutPath(dst))
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []string
+
+	for _, desc := range index.Manifests {
+		if tag := desc.Annotations[imgspecv1.AnnotationRefName]; tag != "" {
+			tags = append(tags, fmt.Sprintf("%s:%s", dst, tag))
+		}
+	}
+
+	return tags, nil
+}
+
+// getOCILayoutDigest returns the source digest of a tag stored in a layout.
+func getOCILayoutDigest(dst string, tag string) (string, error) {
+	index, err := readOCILayoutIndex(getOCILayoutPath(dst))
+	if err != nil {
+		return "", err
+	}
+
+	for _, desc := range index.Manifests {
+		if desc.Annotations[imgspecv1.AnnotationRefName] != tag {
+			continue
+		}
+
+		if d := desc.Annotations[sourceDigestAnnotation]; d != "" {
+			return d, nil
+		}
+
+		return desc.Digest.String(), nil
+	}
+
+	return "", fmt.Errorf("tag %s not found in %s", tag, dst)
+}
+
+// annotateOCILayoutTag records the source digest of a tag in index.json.
+func annotateOCILayoutTag(path string, tag string, srcDigest string) error {
+	index, err := readOCILayoutIndex(path)
+	if err != nil {
+		return err
+	}
+
+	for i, desc := range index.Manifests {
+		if desc.Annotations[imgspecv1.AnnotationRefName] == tag {
+			index.Manifests[i].Annotations[sourceDigestAnnotation] = srcDigest
+			return writeOCILayoutIndex(path, index)
+		}
+	}
+
+	return fmt.Errorf("tag %s not found in %s", tag, path)
+}
+
+func pushOCILayout(ctx context.Context, image *structs.Image, dst string, tag string, index *indexSourceReference) error {
+	path := getOCILayoutPath(dst)
+
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		return err
+	}
+
+	srcRef, err := getPushReference(image, tag, index)
+	if err != nil {
+		return err
+	}
+
+	srcCtx, _ := getSourceContext(ctx, image)
+
+	dstRef, err := layout.NewReference(path, tag)
+	if err != nil {
+		return err
+	}
+
+	policyContext, destroy, err := newPolicyContext(image, srcCtx)
+	if err != nil {
+		return err
+	}
+	defer destroy()
+
+	selection, err := selectPlatforms(ctx, image, srcRef, srcCtx)
+	if err != nil {
+		return err
+	}
+
+	var srcDigest string
+	if index != nil {
+		srcDigest = index.indexDigest()
+	} else if srcDigest, err = getSourceDigest(ctx, image, tag); err != nil {
+		return err
+	}
+
+	ch := make(chan types.ProgressProperties)
+	defer close(ch)
+
+	chCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go dockerDataCounter(chCtx, image.Source, dst, ch)
+
+	unlock := lockOCILayout(path)
+	defer unlock()
+
+	copied, err := copy.Image(ctx, policyContext, dstRef, srcRef, &copy.Options{
+		SourceCtx:          srcCtx,
+		ImageListSelection: selection.selection,
+		Instances:          selection.instances,
+		RemoveSignatures:   true, // Layouts can't store signatures
+		ProgressInterval:   time.Second,
+		Progress:           ch,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Images in the Docker format are converted to OCI, so the index is rewritten from the copied one
+	if selection.index != nil {
+		index, err := rewriteOCILayoutIndex(path, copied)
+		if err != nil {
+			return err
+		}
+
+		if err := putManifestList(ctx, dstRef, nil, index); err != nil {
+			return err
+		}
+	}
+
+	return annotateOCILayoutTag(path, tag, srcDigest)
+}
+
+// rewriteOCILayoutIndex removes the instances that were not copied from an index copied to a layout.
+func rewriteOCILayoutIndex(path string, copied []byte) ([]byte, error) {
+	list, err := manifest.ListFromBlob(copied, manifest.GuessMIMEType(copied))
+	if err != nil {
+		return nil, err
+	}
+
+	var present []digest.Digest
+
+	for _, d := range list.Instances() {
+		if _, err := os.Stat(getOCILayoutBlobPath(path, d)); err == nil {
+			present = append(present, d)
+		}
+	}
+
+	return rewriteManifestList(copied, present)
+}
+
+func deleteOCILayout(ctx context.Context, dst string, tag string) error {
+	path := getOCILayoutPath(dst)
+
+	dstRef, err := layout.NewReference(path, tag)
+	if err != nil {
+		return err
+	}
+
+	unlock := lockOCILayout(path)
+	defer unlock()
+
+	return dstRef.DeleteImage(ctx, nil)
+}
+
+// deleteOrphanedBlobsOCILayout removes the blobs of a layout that are not reachable from its index.
+func deleteOrphanedBlobsOCILayout(ctx context.Context, dst string) error {
+	path := getOCILayoutPath(dst)
+
+	unlock := lockOCILayout(path)
+	defer unlock()
+
+	index, err := readOCILayoutIndex(path)
+	if err != nil {
+		return err
+	}
+
+	referenced := make(map[digest.Digest]struct{})
+
+	var queue []digest.Digest
+	for _, desc := range index.Manifests {
+		queue = append(queue, desc.Digest)
+	}
+
+	for len(queue) > 0 {
+		d := queue[0]
+		queue = queue[1:]
+
+		if _, ok := referenced[d]; ok {
+			continue
+		}
+		referenced[d] = struct{}{}
+
+		b, err := os.ReadFile(getOCILayoutBlobPath(path, d))
+		if err != nil {
+			// Instances that were not selected are listed in preserved indexes but not stored
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+
+			return err
+		}
+
+		manifests, blobs, err := parseManifestReferences(b)
+		if err != nil {
+			return fmt.Errorf("failed to parse manifest %s: %w", d, err)
+		}
+
+		queue = append(queue, manifests...)
+
+		for _, blob := range blobs {
+			referenced[blob] = struct{}{}
+		}
+	}
+
+	var orphanedBlobs []string
+
+	entries, err := os.ReadDir(filepath.Join(path, imgspecv1.ImageBlobsDir, digest.SHA256.String()))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+
+		return err
+	}
+
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		d := digest.NewDigestFromEncoded(digest.SHA256, entry.Name())
+		if _, ok := referenced[d]; !ok {
+			orphanedBlobs = append(orphanedBlobs, entry.Name())
+		}
+	}
+
+	if len(orphanedBlobs) == 0 {
+		log.Info().
+			Str("path", path).
+			Msg("No orphaned blobs found")
+
+		return nil
+	}
+
+	log.Info().
+		Str("path", path).
+		Int("orphaned_blobs", len(orphanedBlobs)).
+		Msg("Found orphaned blobs")
+
+	slices.Sort(orphanedBlobs)
+
+	for _, blob := range orphanedBlobs {
+		if err := os.Remove(filepath.Join(path, imgspecv1.ImageBlobsDir, digest.SHA256.String(), blob)); err != nil {
+			return fmt.Errorf("failed to delete orphaned blob %s: %w", blob, err)
+		}
+	}
+
+	return nil
+}
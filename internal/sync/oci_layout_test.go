@@ -0,0 +1,154 @@
+package sync
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTestBlob stores a blob in a layout and returns its descriptor.
+func writeTestBlob(t *testing.T, path string, mediaType string, b []byte) imgspecv1.Descriptor {
+	d := digest.FromBytes(b)
+
+	assert.NoError(t, os.MkdirAll(filepath.Dir(getOCILayoutBlobPath(path, d)), 0o755))
+	assert.NoError(t, os.WriteFile(getOCILayoutBlobPath(path, d), b, 0o644))
+
+	return imgspecv1.Descriptor{MediaType: mediaType, Digest: d, Size: int64(len(b))}
+}
+
+// writeTestImage stores a single-layer image in a layout and returns its manifest descriptor.
+func writeTestImage(t *testing.T, path string, layer string) imgspecv1.Descriptor {
+	config := writeTestBlob(t, path, imgspecv1.MediaTypeImageConfig, []byte(`{"architecture":"amd64","os":"linux"}`))
+	l := writeTestBlob(t, path, imgspecv1.MediaTypeImageLayer, []byte(layer))
+
+	b, err := json.Marshal(imgspecv1.Manifest{
+		MediaType: imgspecv1.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    []imgspecv1.Descriptor{l},
+	})
+	assert.NoError(t, err)
+
+	return writeTestBlob(t, path, imgspecv1.MediaTypeImageManifest, b)
+}
+
+func setupTestLayout(t *testing.T) (string, string) {
+	path := t.TempDir()
+	dst := fmt.Sprintf("oci:%s", path)
+
+	m1 := writeTestImage(t, path, "layer 1")
+	m1.Annotations = map[string]string{
+		imgspecv1.AnnotationRefName: "1.0",
+		sourceDigestAnnotation:      "sha256:1111111111111111111111111111111111111111111111111111111111111111",
+	}
+
+	m2 := writeTestImage(t, path, "layer 2")
+	m2.Annotations = map[string]string{imgspecv1.AnnotationRefName: "2.0"}
+
+	index := &imgspecv1.Index{MediaType: imgspecv1.MediaTypeImageIndex, Manifests: []imgspecv1.Descriptor{m1, m2}}
+	index.SchemaVersion = 2
+	assert.NoError(t, writeOCILayoutIndex(path, index))
+	assert.NoError(t, os.WriteFile(filepath.Join(path, imgspecv1.ImageLayoutFile), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0o644))
+
+	return path, dst
+}
+
+func TestOCILayoutTags(t *testing.T) {
+	path, dst := setupTestLayout(t)
+
+	tags, err := listOCILayoutTags(dst)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{dst + ":1.0", dst + ":2.0"}, tags)
+
+	d, err := getOCILayoutDigest(dst, "1.0")
+	assert.NoError(t, err)
+	assert.Equal(t, "sha256:1111111111111111111111111111111111111111111111111111111111111111", d)
+
+	// Without the annotation, the stored digest is used
+	index, err := readOCILayoutIndex(path)
+	assert.NoError(t, err)
+
+	d, err = getOCILayoutDigest(dst, "2.0")
+	assert.NoError(t, err)
+	assert.Equal(t, index.Manifests[1].Digest.String(), d)
+
+	assert.NoError(t, annotateOCILayoutTag(path, "2.0", "sha256:2222222222222222222222222222222222222222222222222222222222222222"))
+
+	d, err = getOCILayoutDigest(dst, "2.0")
+	assert.NoError(t, err)
+	assert.Equal(t, "sha256:2222222222222222222222222222222222222222222222222222222222222222", d)
+
+	_, err = getOCILayoutDigest(dst, "3.0")
+	assert.Error(t, err)
+
+	// A layout that does not exist yet has no tags
+	tags, err = listOCILayoutTags("oci:" + filepath.Join(path, "missing"))
+	assert.NoError(t, err)
+	assert.Empty(t, tags)
+}
+
+func TestDeleteOCILayout(t *testing.T) {
+	path, dst := setupTestLayout(t)
+
+	index, err := readOCILayoutIndex(path)
+	assert.NoError(t, err)
+
+	assert.NoError(t, deleteOCILayout(t.Context(), dst, "2.0"))
+
+	tags, err := listOCILayoutTags(dst)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{dst + ":1.0"}, tags)
+
+	_, err = os.Stat(getOCILayoutBlobPath(path, index.Manifests[1].Digest))
+	assert.True(t, os.IsNotExist(err))
+
+	_, err = os.Stat(getOCILayoutBlobPath(path, index.Manifests[0].Digest))
+	assert.NoError(t, err)
+}
+
+func TestDeleteOrphanedBlobsOCILayout(t *testing.T) {
+	path, dst := setupTestLayout(t)
+
+	orphan := writeTestBlob(t, path, imgspecv1.MediaTypeImageLayer, []byte("orphan"))
+
+	assert.NoError(t, deleteOrphanedBlobsOCILayout(t.Context(), dst))
+
+	_, err := os.Stat(getOCILayoutBlobPath(path, orphan.Digest))
+	assert.True(t, os.IsNotExist(err))
+
+	entries, err := os.ReadDir(filepath.Join(path, imgspecv1.ImageBlobsDir, "sha256"))
+	assert.NoError(t, err)
+	// Two manifests, two layers and a shared config
+	assert.Len(t, entries, 5)
+}
+
+func TestParseManifestReferences(t *testing.T) {
+	index := `{"manifests":[{"digest":"sha256:1111111111111111111111111111111111111111111111111111111111111111"}]}`
+
+	manifests, blobs, err := parseManifestReferences([]byte(index))
+	assert.NoError(t, err)
+	assert.Equal(t, []digest.Digest{"sha256:1111111111111111111111111111111111111111111111111111111111111111"}, manifests)
+	assert.Empty(t, blobs)
+
+	image := `{
+		"config":{"digest":"sha256:2222222222222222222222222222222222222222222222222222222222222222"},
+		"layers":[{"digest":"sha256:3333333333333333333333333333333333333333333333333333333333333333"}],
+		"subject":{"digest":"sha256:4444444444444444444444444444444444444444444444444444444444444444"}
+	}`
+
+	manifests, blobs, err = parseManifestReferences([]byte(image))
+	assert.NoError(t, err)
+	assert.Empty(t, manifests)
+	assert.Equal(t, []digest.Digest{
+		"sha256:2222222222222222222222222222222222222222222222222222222222222222",
+		"sha256:3333333333333333333333333333333333333333333333333333333333333333",
+	}, blobs)
+
+	_, _, err = parseManifestReferences([]byte("not json"))
+	assert.Error(t, err)
+}
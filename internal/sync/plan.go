@@ -0,0 +1,24 @@
+package sync
+
+// ActionType is the kind of change a sync performs on a target.
+type ActionType string
+
+const (
+	// ActionCopy pushes a tag that does not exist in the target yet.
+	ActionCopy ActionType = "copy"
+	// ActionOverwrite pushes a mutable tag that already exists in the target.
+	ActionOverwrite ActionType = "overwrite"
+	// ActionDelete removes a tag that no longer exists in the source.
+	ActionDelete ActionType = "delete"
+)
+
+// Action is a single change a sync performs on a target.
+type Action struct {
+	Image  string     `json:"image"`
+	Target string     `json:"target"`
+	Tag    string     `json:"tag"`
+	Type   ActionType `json:"action"`
+	// Digest is the source manifest digest, set for referrers copied by digest as they have no tag, and for tags
+	// whose digest was read to compare digests.
+	Digest string `json:"digest,omitempty"`
+}
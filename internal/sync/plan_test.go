@@ -0,0 +1,193 @@
+package sync
+
+import (
+	"testing"
+
+	"github.com/Altinity/docker-sync/config"
+	"github.com/Altinity/docker-sync/structs"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestPlanTag(t *testing.T) {
+	dstTags := []string{
+		"ghcr.io/altinity/image:1.0",
+		"ghcr.io/altinity/image:latest",
+		"r2:account-id:bucket:altinity/image:1.0",
+	}
+
+	tests := []struct {
+		name              string
+		mutableTags       []string
+		immutableTagDrift string
+		tag               string
+		expected          []Action
+	}{
+		{
+			name: "missing in all targets",
+			tag:  "2.0",
+			expected: []Action{
+				{Image: "altinity/image", Target: "ghcr.io/altinity/image", Tag: "2.0", Type: ActionCopy},
+				{Image: "altinity/image", Target: "r2:account-id:bucket:altinity/image", Tag: "2.0", Type: ActionCopy},
+			},
+		},
+		{
+			name:     "immutable tag present in all targets",
+			tag:      "1.0",
+			expected: nil,
+		},
+		{
+			name:              "immutable tag present in all targets with drift check",
+			immutableTagDrift: "warn",
+			tag:               "1.0",
+			expected: []Action{
+				{Image: "altinity/image", Target: "ghcr.io/altinity/image", Tag: "1.0", Type: actionVerify},
+				{Image: "altinity/image", Target: "r2:account-id:bucket:altinity/image", Tag: "1.0", Type: actionVerify},
+			},
+		},
+		{
+			name:        "mutable tag present in one target",
+			mutableTags: []string{"latest"},
+			tag:         "latest",
+			expected: []Action{
+				{Image: "altinity/image", Target: "ghcr.io/altinity/image", Tag: "latest", Type: ActionOverwrite},
+				{Image: "altinity/image", Target: "r2:account-id:bucket:altinity/image", Tag: "latest", Type: ActionCopy},
+			},
+		},
+		{
+			name:        "mutable tag pattern",
+			mutableTags: []string{"1.*"},
+			tag:         "1.0",
+			expected: []Action{
+				{Image: "altinity/image", Target: "ghcr.io/altinity/image", Tag: "1.0", Type: ActionOverwrite},
+				{Image: "altinity/image", Target: "r2:account-id:bucket:altinity/image", Tag: "1.0", Type: ActionOverwrite},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			image := &structs.Image{
+				Source:            "altinity/image",
+				Targets:           []string{"ghcr.io/altinity/image", "r2:account-id:bucket:altinity/image"},
+				MutableTags:       tt.mutableTags,
+				ImmutableTagDrift: tt.immutableTagDrift,
+			}
+
+			assert.Equal(t, tt.expected, planTag(image, tt.tag, dstTags))
+		})
+	}
+}
+
+func TestPlanPurge(t *testing.T) {
+	image := &structs.Image{
+		Source:      "altinity/image",
+		Targets:     []string{"ghcr.io/altinity/image", "ghcr.io/altinity/image-mirror"},
+		MutableTags: []string{"latest"},
+	}
+
+	dstTags := []string{
+		"ghcr.io/altinity/image-mirror:0.9",
+		"ghcr.io/altinity/image:0.8",
+		"ghcr.io/altinity/image:0.9",
+		"ghcr.io/altinity/image:1.0",
+		"ghcr.io/altinity/image:latest",
+	}
+
+	srcTags := []string{"1.0"}
+
+	assert.Equal(t, []string{"0.8", "0.9"}, planPurge(image, "ghcr.io/altinity/image", srcTags, dstTags))
+	assert.Equal(t, []string{"0.9"}, planPurge(image, "ghcr.io/altinity/image-mirror", srcTags, dstTags))
+}
+
+func TestCheckDigests(t *testing.T) {
+	setCompareDigests := func(compare bool) {
+		viper.Set("sync.compareDigests", compare)
+		config.SyncCompareDigests.Update()
+	}
+	t.Cleanup(func() { setCompareDigests(true) })
+
+	reader := sdkmetric.NewManualReader()
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+
+	// immutableTagChanges returns the number of immutable tag changes recorded so far
+	immutableTagChanges := func() int64 {
+		var rm metricdata.ResourceMetrics
+		assert.NoError(t, reader.Collect(t.Context(), &rm))
+
+		for _, sm := range rm.ScopeMetrics {
+			for _, m := range sm.Metrics {
+				if m.Name != "immutable_tag_changes" {
+					continue
+				}
+
+				var total int64
+				for _, dp := range m.Data.(metricdata.Sum[int64]).DataPoints {
+					total += dp.Value
+				}
+
+				return total
+			}
+		}
+
+		return 0
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		setCompareDigests(false)
+
+		image := &structs.Image{Source: "altinity/image"}
+
+		actions := []Action{
+			{Image: "altinity/image", Target: "ghcr.io/altinity/image", Tag: "latest", Type: ActionOverwrite},
+			{Image: "altinity/image", Target: "r2:account-id:bucket:altinity/image", Tag: "latest", Type: ActionCopy},
+		}
+
+		// Nothing to compare, so no registry is contacted
+		assert.Equal(t, actions, checkDigests(t.Context(), image, "latest", actions))
+	})
+
+	// The layout is both the source and the target. Tag 2.0 has the same digest in both, while the target
+	// records another source digest for tag 1.0.
+	_, src := setupTestLayout(t)
+
+	tests := []struct {
+		name    string
+		tag     string
+		drift   string
+		action  ActionType
+		want    []ActionType
+		changes int64
+	}{
+		{name: "mutable tag unchanged", tag: "2.0", action: ActionOverwrite},
+		{name: "mutable tag moved", tag: "1.0", action: ActionOverwrite, want: []ActionType{ActionOverwrite}},
+		{name: "immutable tag unchanged", tag: "2.0", action: actionVerify},
+		{name: "immutable tag changed", tag: "1.0", action: actionVerify, changes: 1},
+		{name: "immutable tag changed with resync", tag: "1.0", drift: "resync", action: actionVerify,
+			want: []ActionType{ActionOverwrite}, changes: 1},
+		{name: "missing target", tag: "3.0", action: actionVerify},
+		{name: "copy", tag: "1.0", action: ActionCopy, want: []ActionType{ActionCopy}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setCompareDigests(true)
+
+			image := &structs.Image{Source: src, ImmutableTagDrift: tt.drift}
+			actions := []Action{{Image: src, Target: src, Tag: tt.tag, Type: tt.action}}
+
+			before := immutableTagChanges()
+
+			var got []ActionType
+			for _, action := range checkDigests(t.Context(), image, tt.tag, actions) {
+				got = append(got, action.Type)
+			}
+
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.changes, immutableTagChanges()-before)
+		})
+	}
+}
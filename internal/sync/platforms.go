@@ -0,0 +1,315 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/Altinity/docker-sync/structs"
+	"github.com/containers/image/v5/copy"
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+)
+
+// platformSelection describes which instances of a source image are copied.
+type platformSelection struct {
+	selection copy.ImageListSelection
+	instances []digest.Digest
+	// index is the manifest list rewritten to only list the selected instances, if requested.
+	index []byte
+}
+
+type platform struct {
+	os      string
+	arch    string
+	variant string
+}
+
+func parsePlatform(s string) (platform, error) {
+	fields := strings.Split(s, "/")
+	if len(fields) < 2 || len(fields) > 3 {
+		return platform{}, fmt.Errorf("invalid platform %q, format is <os>/<arch>[/<variant>]", s)
+	}
+
+	p := platform{os: fields[0], arch: fields[1]}
+	if len(fields) == 3 {
+		p.variant = fields[2]
+	}
+
+	return p, nil
+}
+
+// matches reports whether an instance platform matches the requested one. An empty variant matches any variant.
+func (p platform) matches(os string, arch string, variant string) bool {
+	if p.os != os || p.arch != arch {
+		return false
+	}
+
+	if p.variant == "" {
+		return true
+	}
+
+	// arm64 images are published both with and without the v8 variant
+	if arch == "arm64" && variant == "" {
+		variant = "v8"
+	}
+
+	return p.variant == variant
+}
+
+// selectPlatforms determines which instances of the source manifest list must be copied for an image.
+func selectPlatforms(ctx context.Context, image *structs.Image, srcRef types.ImageReference, srcCtx *types.SystemContext) (*platformSelection, error) {
+	if len(image.Platforms) == 0 {
+		return &platformSelection{selection: copy.CopyAllImages}, nil
+	}
+
+	var platforms []platform
+	for _, s := range image.Platforms {
+		p, err := parsePlatform(s)
+		if err != nil {
+			return nil, err
+		}
+		platforms = append(platforms, p)
+	}
+
+	src, err := srcRef.NewImageSource(ctx, srcCtx)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	b, mimeType, err := src.GetManifest(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Not a multi-arch image, there is nothing to filter
+	if !manifest.MIMETypeIsMultiImage(mimeType) {
+		return &platformSelection{selection: copy.CopyAllImages}, nil
+	}
+
+	return filterManifestList(image, platforms, b, mimeType)
+}
+
+func filterManifestList(image *structs.Image, platforms []platform, b []byte, mimeType string) (*platformSelection, error) {
+	list, err := manifest.ListFromBlob(b, mimeType)
+	if err != nil {
+		return nil, err
+	}
+
+	var instances []digest.Digest
+	attachments := make(map[digest.Digest]digest.Digest)
+
+	for _, d := range list.Instances() {
+		instance, err := list.Instance(d)
+		if err != nil {
+			return nil, err
+		}
+
+		// Keep BuildKit attestations of the selected platforms
+		if ref, ok := instance.ReadOnly.Annotations["vnd.docker.reference.digest"]; ok {
+			attachments[d] = digest.Digest(ref)
+			continue
+		}
+
+		p := instance.ReadOnly.Platform
+		if p == nil {
+			continue
+		}
+
+		if slices.ContainsFunc(platforms, func(want platform) bool {
+			return want.matches(p.OS, p.Architecture, p.Variant)
+		}) {
+			instances = append(instances, d)
+		}
+	}
+
+	if len(instances) == 0 {
+		return nil, fmt.Errorf("no instances of %s match platforms %v", image.Source, image.Platforms)
+	}
+
+	for d, ref := range attachments {
+		if slices.Contains(instances, ref) {
+			instances = append(instances, d)
+		}
+	}
+
+	selection := &platformSelection{
+		selection: copy.CopySpecificImages,
+		instances: instances,
+	}
+
+	if image.PlatformIndex == "rewrite" {
+		index, err := rewriteManifestList(b, instances)
+		if err != nil {
+			return nil, err
+		}
+		selection.index = index
+	}
+
+	return selection, nil
+}
+
+// rewriteManifestList removes the instances that are not selected from a manifest list or OCI index. The
+// descriptors kept and every other field are left byte for byte as they are, so the digest of the result only
+// depends on the source manifest.
+func rewriteManifestList(b []byte, instances []digest.Digest) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+
+	if tok, err := dec.Token(); err != nil {
+		return nil, err
+	} else if tok != json.Delim('{') {
+		return nil, errors.New("manifest list is not a JSON object")
+	}
+
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		if key != "manifests" {
+			var value json.RawMessage
+			if err := dec.Decode(&value); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		if tok, err := dec.Token(); err != nil {
+			return nil, err
+		} else if tok != json.Delim('[') {
+			return nil, errors.New("manifests is not a JSON array")
+		}
+
+		start := dec.InputOffset()
+
+		// Offsets of the descriptors in b
+		var offsets [][2]int64
+		var kept []int
+
+		for dec.More() {
+			var descriptor json.RawMessage
+			if err := dec.Decode(&descriptor); err != nil {
+				return nil, err
+			}
+
+			var d struct {
+				Digest digest.Digest `json:"digest"`
+			}
+			if err := json.Unmarshal(descriptor, &d); err != nil {
+				return nil, err
+			}
+
+			end := dec.InputOffset()
+			offsets = append(offsets, [2]int64{end - int64(len(descriptor)), end})
+
+			if slices.Contains(instances, d.Digest) {
+				kept = append(kept, len(offsets)-1)
+			}
+		}
+
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+
+		end := dec.InputOffset() - 1
+
+		var out bytes.Buffer
+		out.Write(b[:start])
+
+		if len(kept) > 0 {
+			// The whitespace around and between the descriptors is kept as well
+			separator := []byte(",")
+			if len(offsets) > 1 {
+				separator = b[offsets[0][1]:offsets[1][0]]
+			}
+
+			out.Write(b[start:offsets[0][0]])
+			for i, k := range kept {
+				if i > 0 {
+					out.Write(separator)
+				}
+				out.Write(b[offsets[k][0]:offsets[k][1]])
+			}
+			out.Write(b[offsets[len(offsets)-1][1]:end])
+		}
+
+		out.Write(b[end:])
+
+		return out.Bytes(), nil
+	}
+
+	return nil, errors.New("manifest list has no manifests")
+}
+
+// indexSourceReference is a source reference whose top-level manifest list is replaced by an index built by
+// docker-sync, so copying it only pushes that index and the instances it lists: a rewritten index, or a referrers
+// index. The signatures of the source don't match the index, so the source must be verified before it is copied.
+type indexSourceReference struct {
+	types.ImageReference
+	index []byte
+}
+
+// DockerReference drops the digest of a source referenced by digest, as the copied manifest is the index.
+func (r *indexSourceReference) DockerReference() reference.Named {
+	named := r.ImageReference.DockerReference()
+	if _, ok := named.(reference.Digested); ok {
+		return reference.TrimNamed(named)
+	}
+
+	return named
+}
+
+func (r *indexSourceReference) NewImageSource(ctx context.Context, sys *types.SystemContext) (types.ImageSource, error) {
+	src, err := r.ImageReference.NewImageSource(ctx, sys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &indexSource{ImageSource: src, ref: r}, nil
+}
+
+// indexDigest returns the digest of the index, which is the digest of the copied tag.
+func (r *indexSourceReference) indexDigest() string {
+	return digest.FromBytes(r.index).String()
+}
+
+type indexSource struct {
+	types.ImageSource
+	ref *indexSourceReference
+}
+
+func (s *indexSource) Reference() types.ImageReference {
+	return s.ref
+}
+
+func (s *indexSource) GetManifest(ctx context.Context, instanceDigest *digest.Digest) ([]byte, string, error) {
+	if instanceDigest != nil {
+		return s.ImageSource.GetManifest(ctx, instanceDigest)
+	}
+
+	return s.ref.index, manifest.GuessMIMEType(s.ref.index), nil
+}
+
+// putManifestList replaces the manifest of a reference with the rewritten manifest list.
+func putManifestList(ctx context.Context, dstRef types.ImageReference, dstCtx *types.SystemContext, index []byte) error {
+	dest, err := dstRef.NewImageDestination(ctx, dstCtx)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+
+	if err := dest.PutManifest(ctx, index, nil); err != nil {
+		return err
+	}
+
+	return dest.Commit(ctx, nil)
+}
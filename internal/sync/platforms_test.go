@@ -0,0 +1,228 @@
+package sync
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Altinity/docker-sync/structs"
+	"github.com/containers/image/v5/copy"
+	"github.com/containers/image/v5/oci/layout"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+const testIndex = `{
+   "schemaVersion": 2,
+   "mediaType": "application/vnd.oci.image.index.v1+json",
+   "manifests": [
+      {
+         "mediaType": "application/vnd.oci.image.manifest.v1+json",
+         "digest": "sha256:1111111111111111111111111111111111111111111111111111111111111111",
+         "size": 100,
+         "platform": {"architecture": "amd64", "os": "linux"}
+      },
+      {
+         "mediaType": "application/vnd.oci.image.manifest.v1+json",
+         "digest": "sha256:2222222222222222222222222222222222222222222222222222222222222222",
+         "size": 100,
+         "platform": {"architecture": "arm64", "os": "linux"}
+      },
+      {
+         "mediaType": "application/vnd.oci.image.manifest.v1+json",
+         "digest": "sha256:3333333333333333333333333333333333333333333333333333333333333333",
+         "size": 100,
+         "platform": {"architecture": "s390x", "os": "linux"}
+      },
+      {
+         "mediaType": "application/vnd.oci.image.manifest.v1+json",
+         "digest": "sha256:4444444444444444444444444444444444444444444444444444444444444444",
+         "size": 100,
+         "annotations": {
+            "vnd.docker.reference.digest": "sha256:1111111111111111111111111111111111111111111111111111111111111111",
+            "vnd.docker.reference.type": "attestation-manifest"
+         },
+         "platform": {"architecture": "unknown", "os": "unknown"}
+      }
+   ]
+}`
+
+func TestPlatformMatches(t *testing.T) {
+	tests := []struct {
+		platform string
+		os       string
+		arch     string
+		variant  string
+		expected bool
+	}{
+		{"linux/amd64", "linux", "amd64", "", true},
+		{"linux/amd64", "linux", "arm64", "", false},
+		{"linux/arm64", "linux", "arm64", "v8", true},
+		{"linux/arm64/v8", "linux", "arm64", "", true},
+		{"linux/arm/v7", "linux", "arm", "v6", false},
+		{"windows/amd64", "linux", "amd64", "", false},
+	}
+
+	for _, tt := range tests {
+		p, err := parsePlatform(tt.platform)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.expected, p.matches(tt.os, tt.arch, tt.variant), tt.platform)
+	}
+
+	_, err := parsePlatform("linux")
+	assert.Error(t, err)
+}
+
+func TestFilterManifestList(t *testing.T) {
+	platforms := []platform{{os: "linux", arch: "amd64"}}
+
+	t.Run("preserve", func(t *testing.T) {
+		image := &structs.Image{Source: "altinity/image", Platforms: []string{"linux/amd64"}}
+
+		selection, err := filterManifestList(image, platforms, []byte(testIndex), imgspecv1.MediaTypeImageIndex)
+		assert.NoError(t, err)
+		assert.Equal(t, copy.CopySpecificImages, selection.selection)
+		assert.ElementsMatch(t, []digest.Digest{
+			"sha256:1111111111111111111111111111111111111111111111111111111111111111",
+			"sha256:4444444444444444444444444444444444444444444444444444444444444444",
+		}, selection.instances)
+		assert.Nil(t, selection.index)
+	})
+
+	t.Run("rewrite", func(t *testing.T) {
+		image := &structs.Image{Source: "altinity/image", Platforms: []string{"linux/amd64"}, PlatformIndex: "rewrite"}
+
+		selection, err := filterManifestList(image, platforms, []byte(testIndex), imgspecv1.MediaTypeImageIndex)
+		assert.NoError(t, err)
+
+		var index struct {
+			SchemaVersion int `json:"schemaVersion"`
+			Manifests     []struct {
+				Digest digest.Digest `json:"digest"`
+			} `json:"manifests"`
+		}
+		assert.NoError(t, json.Unmarshal(selection.index, &index))
+		assert.Equal(t, 2, index.SchemaVersion)
+		assert.Len(t, index.Manifests, 2)
+		assert.Equal(t, digest.Digest("sha256:1111111111111111111111111111111111111111111111111111111111111111"), index.Manifests[0].Digest)
+	})
+
+	t.Run("no match", func(t *testing.T) {
+		image := &structs.Image{Source: "altinity/image", Platforms: []string{"windows/amd64"}}
+
+		_, err := filterManifestList(image, []platform{{os: "windows", arch: "amd64"}}, []byte(testIndex), imgspecv1.MediaTypeImageIndex)
+		assert.Error(t, err)
+	})
+}
+
+func TestRewriteManifestList(t *testing.T) {
+	all := []digest.Digest{
+		"sha256:1111111111111111111111111111111111111111111111111111111111111111",
+		"sha256:2222222222222222222222222222222222222222222222222222222222222222",
+		"sha256:3333333333333333333333333333333333333333333333333333333333333333",
+		"sha256:4444444444444444444444444444444444444444444444444444444444444444",
+	}
+
+	// Nothing is removed, so the index is unchanged
+	index, err := rewriteManifestList([]byte(testIndex), all)
+	assert.NoError(t, err)
+	assert.Equal(t, testIndex, string(index))
+
+	// The fields keep their order and formatting
+	index, err = rewriteManifestList([]byte(testIndex), all[1:2])
+	assert.NoError(t, err)
+	assert.Equal(t, `{
+   "schemaVersion": 2,
+   "mediaType": "application/vnd.oci.image.index.v1+json",
+   "manifests": [
+      {
+         "mediaType": "application/vnd.oci.image.manifest.v1+json",
+         "digest": "sha256:2222222222222222222222222222222222222222222222222222222222222222",
+         "size": 100,
+         "platform": {"architecture": "arm64", "os": "linux"}
+      }
+   ]
+}`, string(index))
+
+	_, err = rewriteManifestList([]byte(`{"schemaVersion": 2}`), all)
+	assert.Error(t, err)
+}
+
+func TestIndexSourceReference(t *testing.T) {
+	// The source layout has a two-platform index tagged 1.0
+	srcPath := t.TempDir()
+
+	amd64 := writeTestImage(t, srcPath, "amd64 layer")
+	amd64.Platform = &imgspecv1.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := writeTestImage(t, srcPath, "arm64 layer")
+	arm64.Platform = &imgspecv1.Platform{OS: "linux", Architecture: "arm64"}
+
+	list := imgspecv1.Index{MediaType: imgspecv1.MediaTypeImageIndex, Manifests: []imgspecv1.Descriptor{amd64, arm64}}
+	list.SchemaVersion = 2
+	b, err := json.Marshal(list)
+	assert.NoError(t, err)
+
+	tagged := writeTestBlob(t, srcPath, imgspecv1.MediaTypeImageIndex, b)
+	tagged.Annotations = map[string]string{imgspecv1.AnnotationRefName: "1.0"}
+
+	index := &imgspecv1.Index{MediaType: imgspecv1.MediaTypeImageIndex, Manifests: []imgspecv1.Descriptor{tagged}}
+	index.SchemaVersion = 2
+	assert.NoError(t, writeOCILayoutIndex(srcPath, index))
+	assert.NoError(t, os.WriteFile(filepath.Join(srcPath, imgspecv1.ImageLayoutFile), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0o644))
+
+	image := &structs.Image{Source: "oci:" + srcPath, Platforms: []string{"linux/amd64"}, PlatformIndex: "rewrite"}
+
+	srcRef, err := getSourceReference(image, "1.0")
+	assert.NoError(t, err)
+
+	srcCtx, _ := getSourceContext(t.Context(), image)
+
+	selection, err := selectPlatforms(t.Context(), image, srcRef, srcCtx)
+	assert.NoError(t, err)
+	assert.NotNil(t, selection.index)
+
+	// The source is checked against its policy before the copy, which doesn't read the source manifest list
+	policyPath := filepath.Join(t.TempDir(), "policy.json")
+	assert.NoError(t, os.WriteFile(policyPath, []byte(`{"default":[{"type":"reject"}]}`), 0o644))
+
+	rejected, destroy, err := newPolicyContext(&structs.Image{Policy: &structs.SignaturePolicy{Path: policyPath}}, srcCtx)
+	assert.NoError(t, err)
+
+	_, _, err = verifySource(t.Context(), rejected, srcRef, srcCtx)
+	assert.True(t, isSignatureError(err))
+	destroy()
+
+	policyContext, destroy, err := newPolicyContext(image, srcCtx)
+	assert.NoError(t, err)
+	defer destroy()
+
+	verified, destroyVerified, err := verifySource(t.Context(), policyContext, srcRef, srcCtx)
+	assert.NoError(t, err)
+	defer destroyVerified()
+
+	// Only the rewritten index and the selected platform are copied. Digests are preserved, as registries keep
+	// layers as they are while layouts would compress them.
+	dstPath := t.TempDir()
+	dstRef, err := layout.NewReference(dstPath, "1.0")
+	assert.NoError(t, err)
+
+	copied, err := copy.Image(t.Context(), verified, dstRef, &indexSourceReference{ImageReference: srcRef, index: selection.index}, &copy.Options{
+		SourceCtx:          srcCtx,
+		ImageListSelection: selection.selection,
+		Instances:          selection.instances,
+		RemoveSignatures:   true,
+		PreserveDigests:    true,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, selection.index, copied)
+
+	d, err := getOCILayoutDigest("oci:"+dstPath, "1.0")
+	assert.NoError(t, err)
+	assert.Equal(t, digest.FromBytes(selection.index).String(), d)
+
+	assert.FileExists(t, getOCILayoutBlobPath(dstPath, amd64.Digest))
+	assert.NoFileExists(t, getOCILayoutBlobPath(dstPath, arm64.Digest))
+	assert.NoFileExists(t, getOCILayoutBlobPath(dstPath, tagged.Digest))
+}
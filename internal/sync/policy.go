@@ -0,0 +1,161 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/Altinity/docker-sync/config"
+	"github.com/Altinity/docker-sync/structs"
+	"github.com/containers/image/v5/image"
+	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/types"
+)
+
+// sigstoreRegistriesConfig makes signatures be looked up as sigstore attachments in every registry.
+const sigstoreRegistriesConfig = `default-docker:
+  use-sigstore-attachments: true
+`
+
+// getSignaturePolicy returns the signature policy of an image, falling back to the global policy.
+func getSignaturePolicy(image *structs.Image) *structs.SignaturePolicy {
+	if image.Policy != nil {
+		return image.Policy
+	}
+
+	return config.SyncPolicy.SignaturePolicy()
+}
+
+// buildSignaturePolicy converts a signature policy into a containers/image policy.
+func buildSignaturePolicy(policy *structs.SignaturePolicy) (*signature.Policy, error) {
+	if policy == nil {
+		return &signature.Policy{Default: []signature.PolicyRequirement{signature.NewPRInsecureAcceptAnything()}}, nil
+	}
+
+	if policy.Path != "" {
+		return signature.NewPolicyFromFile(policy.Path)
+	}
+
+	var requirement signature.PolicyRequirement
+	var err error
+
+	switch policy.Type {
+	case "", "insecureAcceptAnything":
+		requirement = signature.NewPRInsecureAcceptAnything()
+	case "sigstoreSigned":
+		requirement, err = signature.NewPRSigstoreSignedKeyPath(policy.KeyPath, signature.NewPRMMatchRepoDigestOrExact())
+	case "signedBy":
+		requirement, err = signature.NewPRSignedByKeyPath(signature.SBKeyTypeGPGKeys, policy.KeyPath, signature.NewPRMMatchRepoDigestOrExact())
+	default:
+		return nil, fmt.Errorf("unsupported policy type: %s", policy.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &signature.Policy{Default: []signature.PolicyRequirement{requirement}}, nil
+}
+
+// newPolicyContext returns the policy context used to copy an image, and configures srcCtx so that signatures
+// can be found. The returned function releases the resources of the context.
+func newPolicyContext(image *structs.Image, srcCtx *types.SystemContext) (*signature.PolicyContext, func(), error) {
+	policy := getSignaturePolicy(image)
+
+	p, err := buildSignaturePolicy(policy)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	policyContext, err := signature.NewPolicyContext(p)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cleanup := func() {
+		_ = policyContext.Destroy()
+	}
+
+	if !policy.IsEnforced() {
+		return policyContext, cleanup, nil
+	}
+
+	if policy.RegistriesDir != "" {
+		srcCtx.RegistriesDirPath = policy.RegistriesDir
+		return policyContext, cleanup, nil
+	}
+
+	dir, err := getSigstoreRegistriesDir()
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+
+	srcCtx.RegistriesDirPath = dir
+
+	return policyContext, cleanup, nil
+}
+
+var (
+	sigstoreRegistriesDirMutex sync.Mutex
+	sigstoreRegistriesDir      string
+)
+
+// getSigstoreRegistriesDir returns a registries.d directory that enables sigstore attachments in every registry.
+// It is created on first use and shared by every copy until the process exits.
+func getSigstoreRegistriesDir() (string, error) {
+	sigstoreRegistriesDirMutex.Lock()
+	defer sigstoreRegistriesDirMutex.Unlock()
+
+	if sigstoreRegistriesDir != "" {
+		return sigstoreRegistriesDir, nil
+	}
+
+	dir, err := os.MkdirTemp(os.TempDir(), "docker-sync-registries-*")
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "default.yaml"), []byte(sigstoreRegistriesConfig), 0o644); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+
+	sigstoreRegistriesDir = dir
+
+	return dir, nil
+}
+
+// verifySource checks a source image against a policy, for copies that don't read the source manifest as it is.
+// It returns the policy context to use for such a copy, which accepts any image, and a function that releases it.
+func verifySource(ctx context.Context, policyContext *signature.PolicyContext, srcRef types.ImageReference, srcCtx *types.SystemContext) (*signature.PolicyContext, func(), error) {
+	src, err := srcRef.NewImageSource(ctx, srcCtx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer src.Close()
+
+	if _, err := policyContext.IsRunningImageAllowed(ctx, image.UnparsedInstance(src, nil)); err != nil {
+		return nil, nil, err
+	}
+
+	p, err := buildSignaturePolicy(nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	verified, err := signature.NewPolicyContext(p)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return verified, func() { _ = verified.Destroy() }, nil
+}
+
+// isSignatureError reports whether an error is the rejection of an image by the signature policy.
+func isSignatureError(err error) bool {
+	var policyErr signature.PolicyRequirementError
+	return errors.As(err, &policyErr)
+}
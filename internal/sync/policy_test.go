@@ -0,0 +1,106 @@
+package sync
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Altinity/docker-sync/structs"
+	"github.com/cenkalti/backoff/v4"
+	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildSignaturePolicy(t *testing.T) {
+	keyPath := filepath.Join(t.TempDir(), "cosign.pub")
+	assert.NoError(t, os.WriteFile(keyPath, []byte("key"), 0o644))
+
+	tests := []struct {
+		name     string
+		policy   *structs.SignaturePolicy
+		expected string
+	}{
+		{name: "default", policy: nil, expected: "insecureAcceptAnything"},
+		{name: "insecure", policy: &structs.SignaturePolicy{Type: "insecureAcceptAnything"}, expected: "insecureAcceptAnything"},
+		{name: "sigstore", policy: &structs.SignaturePolicy{Type: "sigstoreSigned", KeyPath: keyPath}, expected: "sigstoreSigned"},
+		{name: "signed by", policy: &structs.SignaturePolicy{Type: "signedBy", KeyPath: keyPath}, expected: "signedBy"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := buildSignaturePolicy(tt.policy)
+			assert.NoError(t, err)
+
+			b, err := json.Marshal(policy)
+			assert.NoError(t, err)
+			assert.Contains(t, string(b), fmt.Sprintf(`"type":%q`, tt.expected))
+		})
+	}
+
+	_, err := buildSignaturePolicy(&structs.SignaturePolicy{Type: "reject"})
+	assert.Error(t, err)
+}
+
+func TestNewPolicyContext(t *testing.T) {
+	keyPath := filepath.Join(t.TempDir(), "cosign.pub")
+	assert.NoError(t, os.WriteFile(keyPath, []byte("key"), 0o644))
+
+	t.Run("not enforced", func(t *testing.T) {
+		srcCtx := &types.SystemContext{}
+
+		_, destroy, err := newPolicyContext(&structs.Image{Policy: &structs.SignaturePolicy{}}, srcCtx)
+		assert.NoError(t, err)
+		defer destroy()
+
+		assert.Empty(t, srcCtx.RegistriesDirPath)
+	})
+
+	t.Run("sigstore attachments", func(t *testing.T) {
+		srcCtx := &types.SystemContext{}
+
+		_, destroy, err := newPolicyContext(&structs.Image{
+			Policy: &structs.SignaturePolicy{Type: "sigstoreSigned", KeyPath: keyPath},
+		}, srcCtx)
+		assert.NoError(t, err)
+
+		b, err := os.ReadFile(filepath.Join(srcCtx.RegistriesDirPath, "default.yaml"))
+		assert.NoError(t, err)
+		assert.Equal(t, sigstoreRegistriesConfig, string(b))
+
+		destroy()
+
+		// The directory is kept and shared by the next copies
+		otherCtx := &types.SystemContext{}
+
+		_, destroy, err = newPolicyContext(&structs.Image{
+			Policy: &structs.SignaturePolicy{Type: "sigstoreSigned", KeyPath: keyPath},
+		}, otherCtx)
+		assert.NoError(t, err)
+		defer destroy()
+
+		assert.Equal(t, srcCtx.RegistriesDirPath, otherCtx.RegistriesDirPath)
+	})
+
+	t.Run("registries dir", func(t *testing.T) {
+		srcCtx := &types.SystemContext{}
+
+		_, destroy, err := newPolicyContext(&structs.Image{
+			Policy: &structs.SignaturePolicy{Type: "signedBy", KeyPath: keyPath, RegistriesDir: "/etc/containers/registries.d"},
+		}, srcCtx)
+		assert.NoError(t, err)
+		defer destroy()
+
+		assert.Equal(t, "/etc/containers/registries.d", srcCtx.RegistriesDirPath)
+	})
+}
+
+func TestIsSignatureError(t *testing.T) {
+	err := fmt.Errorf("Source image rejected: %w", signature.PolicyRequirementError("A signature was required, but no signature exists"))
+
+	assert.True(t, isSignatureError(err))
+	assert.True(t, isSignatureError(backoff.Permanent(err)))
+	assert.False(t, isSignatureError(fmt.Errorf("connection refused")))
+}
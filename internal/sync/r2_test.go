@@ -254,12 +254,12 @@ func TestR2SyncObject(t *testing.T) {
 
 	// Create the s3Client with all required components
 	s3c := &s3Client{
-		bucket:    bucket,
-		acl:       aws.String("public-read"),
-		baseDir:   "test",
-		dst:       fmt.Sprintf("r2:%s:%s:test", accountID, testBucket),
-		s3Session: s3Session,
-		uploader:  manager.NewUploader(s3Session), // This was missing
+		bucket:        bucket,
+		objectOptions: objectOptions{acl: aws.String("public-read")},
+		baseDir:       "test",
+		dst:           fmt.Sprintf("r2:%s:%s:test", accountID, testBucket),
+		s3Session:     s3Session,
+		uploader:      manager.NewUploader(s3Session), // This was missing
 	}
 
 	content := "test content"
@@ -377,12 +377,12 @@ func TestR2ObjectOperations(t *testing.T) {
 
 		// Upload object
 		s3c := &s3Client{
-			bucket:    bucket,
-			acl:       aws.String("public-read"),
-			baseDir:   "test",
-			dst:       fmt.Sprintf("r2:%s:%s:test", accountID, testBucket),
-			s3Session: s3Session,
-			uploader:  uploader, // Make sure uploader is set
+			bucket:        bucket,
+			objectOptions: objectOptions{acl: aws.String("public-read")},
+			baseDir:       "test",
+			dst:           fmt.Sprintf("r2:%s:%s:test", accountID, testBucket),
+			s3Session:     s3Session,
+			uploader:      uploader, // Make sure uploader is set
 		}
 
 		err = syncObject(
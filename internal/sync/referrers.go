@@ -0,0 +1,521 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	stdsync "sync"
+	"time"
+
+	"github.com/Altinity/docker-sync/config"
+	"github.com/Altinity/docker-sync/structs"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
+	"github.com/jellydator/ttlcache/v3"
+	"github.com/opencontainers/go-digest"
+	imgspec "github.com/opencontainers/image-spec/specs-go"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/errgroup"
+)
+
+// referrerTagRegexp matches the tags cosign (.sig, .att, .sbom) and the OCI 1.1 referrers tag schema (no suffix)
+// use to attach artifacts to a manifest.
+var referrerTagRegexp = regexp.MustCompile(`^sha256-([a-f0-9]{64})(\.sig|\.att|\.sbom)?$`)
+
+// isReferrerTag returns the subject digest of a tag that attaches artifacts to a manifest.
+func isReferrerTag(tag string) (digest.Digest, bool) {
+	m := referrerTagRegexp.FindStringSubmatch(tag)
+	if m == nil {
+		return "", false
+	}
+
+	return digest.NewDigestFromEncoded(digest.SHA256, m[1]), true
+}
+
+// withoutReferrerTags removes referrer tags from a list of tags, as they are synced along with their subject.
+func withoutReferrerTags(tags []string) []string {
+	return slices.DeleteFunc(slices.Clone(tags), func(tag string) bool {
+		_, ok := isReferrerTag(tag)
+		return ok
+	})
+}
+
+// onlyReferrerTags returns the referrer tags of a list of tags.
+func onlyReferrerTags(tags []string) []string {
+	return slices.DeleteFunc(slices.Clone(tags), func(tag string) bool {
+		_, ok := isReferrerTag(tag)
+		return !ok
+	})
+}
+
+// referrerImage returns the image used to copy referrers. Artifacts are copied as-is: they are not signed
+// themselves and have no platforms.
+func referrerImage(image *structs.Image) *structs.Image {
+	referrer := *image
+	referrer.Platforms = nil
+	referrer.PlatformIndex = ""
+	referrer.Policy = &structs.SignaturePolicy{Type: "insecureAcceptAnything"}
+
+	return &referrer
+}
+
+// getReferrerTags returns the source referrer tags attached to the manifests of tags, and the digests of those
+// manifests, including the instances of manifest lists. srcReferrerTags holds the referrer tags of the source, and
+// known the source digests of tags already read by the sync.
+func getReferrerTags(ctx context.Context, image *structs.Image, srcReferrerTags []string, tags []string, known map[string]string) ([]string, map[digest.Digest]struct{}, error) {
+	srcCtx, _ := getSourceContext(ctx, image)
+
+	subjects, err := getSubjectDigests(ctx, image, srcCtx, tags, known)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var referrerTags []string
+
+	for _, tag := range srcReferrerTags {
+		d, _ := isReferrerTag(tag)
+		if _, ok := subjects[d]; ok {
+			referrerTags = append(referrerTags, tag)
+		}
+	}
+
+	slices.Sort(referrerTags)
+
+	return referrerTags, subjects, nil
+}
+
+// manifestInstances caches the instances of the source manifest lists by digest, nil for other manifests, as the
+// manifest of a digest never changes.
+var manifestInstances = ttlcache.New(
+	ttlcache.WithTTL[digest.Digest, []digest.Digest](24*time.Hour),
+	ttlcache.WithCapacity[digest.Digest, []digest.Digest](10000),
+)
+
+// getSubjectDigests returns the digests of the manifests of tags, including the instances of manifest lists. The
+// digest of a tag is taken from known or the state, or else asked to the source without reading the manifest,
+// which is only read the first time the digest is seen.
+func getSubjectDigests(ctx context.Context, image *structs.Image, srcCtx *types.SystemContext, tags []string, known map[string]string) (map[digest.Digest]struct{}, error) {
+	subjects := make(map[digest.Digest]struct{})
+	var mutex stdsync.Mutex
+
+	// The digests recorded for rewritten indexes are not those of the source manifests
+	reuse := len(image.Platforms) == 0 || image.PlatformIndex != "rewrite"
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(max(1, config.SyncMaxConcurrentTags.Int()))
+
+	for _, tag := range tags {
+		g.Go(func() error {
+			srcRef, err := getSourceReference(image, tag)
+			if err != nil {
+				return err
+			}
+
+			var d string
+			if reuse {
+				d = known[tag]
+				if d == "" {
+					d = stateSourceDigest(gctx, image, tag)
+				}
+			}
+
+			if d == "" {
+				d, err = getReferenceDigest(gctx, srcRef, srcCtx)
+				if err != nil {
+					return err
+				}
+			}
+
+			digests := []digest.Digest{digest.Digest(d)}
+
+			if item := manifestInstances.Get(digest.Digest(d)); item != nil {
+				digests = append(digests, item.Value()...)
+			} else {
+				digests, err = readSubjectDigests(gctx, srcRef, srcCtx)
+				if err != nil {
+					return err
+				}
+			}
+
+			mutex.Lock()
+			for _, d := range digests {
+				subjects[d] = struct{}{}
+			}
+			mutex.Unlock()
+
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	return subjects, nil
+}
+
+// readSubjectDigests reads the manifest of a reference, and returns its digest followed by its instances, which
+// are cached.
+func readSubjectDigests(ctx context.Context, ref types.ImageReference, sys *types.SystemContext) ([]digest.Digest, error) {
+	src, err := ref.NewImageSource(ctx, sys)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	b, mimeType, err := src.GetManifest(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := manifest.Digest(b)
+	if err != nil {
+		return nil, err
+	}
+
+	var instances []digest.Digest
+
+	if manifest.MIMETypeIsMultiImage(mimeType) {
+		list, err := manifest.ListFromBlob(b, mimeType)
+		if err != nil {
+			return nil, err
+		}
+
+		instances = list.Instances()
+	}
+
+	manifestInstances.Set(d, instances, ttlcache.DefaultTTL)
+
+	return append([]digest.Digest{d}, instances...), nil
+}
+
+// attachedTags returns the referrer tags of the targets whose subject is one of the synced manifests, so purge
+// keeps them.
+func attachedTags(image *structs.Image, dstTags []string, subjects map[digest.Digest]struct{}) []string {
+	var attached []string
+
+	for _, dstTag := range dstTags {
+		for _, dst := range image.Targets {
+			tag, ok := strings.CutPrefix(dstTag, fmt.Sprintf("%s:", dst))
+			if !ok {
+				continue
+			}
+
+			if d, ok := isReferrerTag(tag); ok {
+				if _, ok := subjects[d]; ok {
+					attached = append(attached, tag)
+				}
+			}
+		}
+	}
+
+	slices.Sort(attached)
+
+	return slices.Compact(attached)
+}
+
+// planReferrerTag determines which targets a referrer tag must be pushed to. Referrer tags are mutable, as
+// signing an image again updates them.
+func planReferrerTag(image *structs.Image, tag string, dstTags []string) []Action {
+	var actions []Action
+
+	for _, dst := range image.Targets {
+		action := ActionCopy
+		if slices.Contains(dstTags, fmt.Sprintf("%s:%s", dst, tag)) {
+			action = ActionOverwrite
+		}
+
+		actions = append(actions, Action{
+			Image:  image.Source,
+			Target: dst,
+			Tag:    tag,
+			Type:   action,
+		})
+	}
+
+	return actions
+}
+
+// syncReferrers copies the artifacts attached to the synced tags of an image, and returns the referrer tags
+// purge must keep. srcReferrerTags holds the referrer tags of the source, and known the source digests of tags
+// already read by the sync.
+func syncReferrers(ctx context.Context, image *structs.Image, srcReferrerTags []string, tags []string, known map[string]string, dstTags []string) ([]string, error) {
+	referrerTags, subjects, err := getReferrerTags(ctx, image, srcReferrerTags, tags, known)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(referrerTags) > 0 {
+		log.Info().
+			Str("image", image.Source).
+			Int("referrers", len(referrerTags)).
+			Msg("Found referrers")
+	}
+
+	g, _ := errgroup.WithContext(ctx)
+	g.SetLimit(max(1, config.SyncMaxConcurrentTags.Int()))
+
+	referrer := referrerImage(image)
+
+	for _, tag := range referrerTags {
+		if ctx.Err() != nil {
+			break
+		}
+
+		g.Go(func() error {
+			syncActions(ctx, referrer, tag, checkDigests(ctx, referrer, tag, planReferrerTag(referrer, tag, dstTags)))
+
+			return nil
+		})
+	}
+
+	_ = g.Wait()
+
+	syncAPIReferrers(ctx, referrer, subjects, referrerTags, dstTags)
+
+	return append(referrerTags, attachedTags(image, dstTags, subjects)...), nil
+}
+
+// getAllAPIReferrers returns the referrers of subjects found through the referrers API, per subject.
+func getAllAPIReferrers(ctx context.Context, image *structs.Image, subjects map[digest.Digest]struct{}) (map[digest.Digest][]imgspecv1.Descriptor, error) {
+	referrers := make(map[digest.Digest][]imgspecv1.Descriptor)
+	var mutex stdsync.Mutex
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(max(1, config.SyncMaxConcurrentTags.Int()))
+
+	for subject := range subjects {
+		g.Go(func() error {
+			descriptors, supported, err := getAPIReferrers(gctx, image, subject)
+			if err != nil || !supported || len(descriptors) == 0 {
+				return err
+			}
+
+			mutex.Lock()
+			referrers[subject] = descriptors
+			mutex.Unlock()
+
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	return referrers, nil
+}
+
+// referrersTag returns the tag of the referrers index of a subject in the OCI 1.1 referrers tag schema.
+func referrersTag(subject digest.Digest) string {
+	return fmt.Sprintf("%s-%s", subject.Algorithm(), subject.Encoded())
+}
+
+// getReferrersIndex returns the referrers index of a subject in the referrers tag schema, for the targets without
+// a referrers API. The referrers are sorted, so the index only changes with them. Its instances are read from the
+// source repository of the subject.
+func getReferrersIndex(image *structs.Image, subject digest.Digest, descriptors []imgspecv1.Descriptor) (*indexSourceReference, error) {
+	manifests := slices.Clone(descriptors)
+	slices.SortFunc(manifests, func(a, b imgspecv1.Descriptor) int {
+		return strings.Compare(a.Digest.String(), b.Digest.String())
+	})
+	manifests = slices.CompactFunc(manifests, func(a, b imgspecv1.Descriptor) bool {
+		return a.Digest == b.Digest
+	})
+
+	index, err := json.Marshal(imgspecv1.Index{
+		Versioned: imgspec.Versioned{SchemaVersion: 2},
+		MediaType: imgspecv1.MediaTypeImageIndex,
+		Manifests: manifests,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	srcRef, err := getDigestReference(image.Source, subject)
+	if err != nil {
+		return nil, err
+	}
+
+	return &indexSourceReference{ImageReference: srcRef, index: index}, nil
+}
+
+// planReferrersIndex determines which buckets and layouts the referrers index of a subject must be pushed to, as
+// they have no referrers API. Targets whose referrers tag already has the index are skipped.
+func planReferrersIndex(ctx context.Context, image *structs.Image, subject digest.Digest, index *indexSourceReference, dstTags []string) []Action {
+	tag := referrersTag(subject)
+
+	var actions []Action
+
+	for _, dst := range image.Targets {
+		if getRepositoryType(dst) == OCIRepository {
+			continue
+		}
+
+		action := ActionCopy
+		if slices.Contains(dstTags, fmt.Sprintf("%s:%s", dst, tag)) {
+			if d, err := getTargetDigest(ctx, image, dst, tag); err == nil && d == index.indexDigest() {
+				continue
+			}
+
+			action = ActionOverwrite
+		}
+
+		actions = append(actions, Action{
+			Image:  image.Source,
+			Target: dst,
+			Tag:    tag,
+			Type:   action,
+			Digest: index.indexDigest(),
+		})
+	}
+
+	return actions
+}
+
+// syncAPIReferrers copies the referrers of subjects found through the referrers API. Registry targets get them
+// by digest, and list them through their own API. Buckets and layouts get a referrers index per subject instead,
+// unless the source has a referrers tag for the subject, which is synced with the other referrer tags.
+func syncAPIReferrers(ctx context.Context, referrer *structs.Image, subjects map[digest.Digest]struct{}, referrerTags []string, dstTags []string) {
+	referrers, err := getAllAPIReferrers(ctx, referrer, subjects)
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Str("image", referrer.Source).
+			Msg("Failed to list referrers through the referrers API")
+
+		return
+	}
+
+	if len(referrers) == 0 {
+		return
+	}
+
+	log.Info().
+		Str("image", referrer.Source).
+		Int("subjects", len(referrers)).
+		Msg("Found referrers through the referrers API")
+
+	g, _ := errgroup.WithContext(ctx)
+	g.SetLimit(max(1, config.SyncMaxConcurrentTags.Int()))
+
+	for subject, descriptors := range referrers {
+		if ctx.Err() != nil {
+			break
+		}
+
+		for _, dst := range referrer.Targets {
+			if getRepositoryType(dst) != OCIRepository {
+				continue
+			}
+
+			for _, descriptor := range descriptors {
+				g.Go(func() error {
+					if err := copyReferrer(ctx, referrer, dst, descriptor.Digest); err != nil {
+						log.Error().
+							Err(err).
+							Str("image", referrer.Source).
+							Str("digest", descriptor.Digest.String()).
+							Str("target", dst).
+							Msg("Failed to copy referrer")
+					}
+
+					return nil
+				})
+			}
+		}
+
+		if slices.Contains(referrerTags, referrersTag(subject)) {
+			continue
+		}
+
+		g.Go(func() error {
+			index, err := getReferrersIndex(referrer, subject, descriptors)
+			if err != nil {
+				log.Error().
+					Err(err).
+					Str("image", referrer.Source).
+					Str("subject", subject.String()).
+					Msg("Failed to build referrers index")
+
+				return nil
+			}
+
+			for _, action := range planReferrersIndex(ctx, referrer, subject, index, dstTags) {
+				if err := pushIndex(ctx, referrer, action.Target, action.Tag, index); err != nil {
+					log.Error().
+						Err(err).
+						Str("image", referrer.Source).
+						Str("tag", action.Tag).
+						Str("target", action.Target).
+						Msg("Failed to push referrers index")
+				}
+			}
+
+			return nil
+		})
+	}
+
+	_ = g.Wait()
+}
+
+// planReferrers returns the actions syncReferrers would perform, and the referrer tags purge must keep.
+func planReferrers(ctx context.Context, image *structs.Image, srcReferrerTags []string, tags []string, dstTags []string) ([]Action, []string, error) {
+	referrerTags, subjects, err := getReferrerTags(ctx, image, srcReferrerTags, tags, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	referrer := referrerImage(image)
+
+	var actions []Action
+
+	for _, tag := range referrerTags {
+		actions = append(actions, checkDigests(ctx, referrer, tag, planReferrerTag(referrer, tag, dstTags))...)
+	}
+
+	referrers, err := getAllAPIReferrers(ctx, referrer, subjects)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Referrers found through the referrers API are copied by digest to registries, and indexed in the others
+	for _, subject := range slices.Sorted(maps.Keys(referrers)) {
+		descriptors := referrers[subject]
+
+		for _, dst := range referrer.Targets {
+			if getRepositoryType(dst) != OCIRepository {
+				continue
+			}
+
+			for _, descriptor := range descriptors {
+				if !hasReferrer(ctx, referrer, dst, descriptor.Digest) {
+					actions = append(actions, Action{
+						Image:  referrer.Source,
+						Target: dst,
+						Type:   ActionCopy,
+						Digest: descriptor.Digest.String(),
+					})
+				}
+			}
+		}
+
+		if slices.Contains(referrerTags, referrersTag(subject)) {
+			continue
+		}
+
+		index, err := getReferrersIndex(referrer, subject, descriptors)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		actions = append(actions, planReferrersIndex(ctx, referrer, subject, index, dstTags)...)
+	}
+
+	return actions, append(referrerTags, attachedTags(image, dstTags, subjects)...), nil
+}
@@ -0,0 +1,296 @@
+package sync
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/Altinity/docker-sync/structs"
+	"github.com/containers/image/v5/copy"
+	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/docker/reference"
+	dockerconfig "github.com/containers/image/v5/pkg/docker/config"
+	"github.com/containers/image/v5/pkg/sysregistriesv2"
+	"github.com/containers/image/v5/pkg/tlsclientconfig"
+	"github.com/containers/image/v5/types"
+	"github.com/jellydator/ttlcache/v3"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/errdef"
+	"oras.land/oras-go/v2/registry/remote"
+	"oras.land/oras-go/v2/registry/remote/auth"
+)
+
+// registryCertDirs are the directories holding the per-registry TLS certificates, as used by containers/image.
+var registryCertDirs = []string{"/etc/containers/certs.d", "/etc/docker/certs.d"}
+
+// referrersAPISupport caches whether registries serve the OCI 1.1 referrers API, so those without it are only
+// asked again once it expired.
+var referrersAPISupport = ttlcache.New(
+	ttlcache.WithTTL[string, bool](time.Hour),
+)
+
+// referrersAuthCache caches the tokens of the registries the referrers API is queried on.
+var referrersAuthCache = auth.NewCache()
+
+// copiedReferrers caches the referrers copied to a target by digest. Manifests pushed by digest never change, so
+// they are not checked again until they expire.
+var copiedReferrers = ttlcache.New(
+	ttlcache.WithTTL[string, bool](24*time.Hour),
+	ttlcache.WithCapacity[string, bool](100000),
+)
+
+// registryHost returns the host serving the API of a registry.
+func registryHost(registry string) string {
+	if registry == "docker.io" {
+		return "registry-1.docker.io"
+	}
+
+	return registry
+}
+
+// getPullSources returns the endpoints a reference is pulled from, mirrors first, as configured in registries.conf.
+func getPullSources(sys *types.SystemContext, ref reference.Named) ([]sysregistriesv2.PullSource, error) {
+	registry, err := sysregistriesv2.FindRegistry(sys, ref.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	if registry == nil {
+		return []sysregistriesv2.PullSource{{
+			Endpoint:  sysregistriesv2.Endpoint{Location: reference.Domain(ref)},
+			Reference: ref,
+		}}, nil
+	}
+
+	if registry.Blocked {
+		return nil, fmt.Errorf("registry %s is blocked in registries.conf", registry.Prefix)
+	}
+
+	return registry.PullSourcesFromReference(ref)
+}
+
+// getRegistryCredential returns the credential of a registry endpoint. The authentication of the source applies to
+// its registry, while other endpoints such as mirrors use the auth files and credential helpers of the system.
+func getRegistryCredential(sys *types.SystemContext, registry string, endpoint string) (auth.Credential, error) {
+	creds := sys.DockerAuthConfig
+
+	if endpoint == registry && sys.DockerBearerRegistryToken != "" {
+		return auth.Credential{AccessToken: sys.DockerBearerRegistryToken}, nil
+	}
+
+	if endpoint != registry || creds == nil {
+		c, err := dockerconfig.GetCredentials(nil, endpoint)
+		if err != nil {
+			return auth.EmptyCredential, err
+		}
+		creds = &c
+	}
+
+	return auth.Credential{
+		Username:     creds.Username,
+		Password:     creds.Password,
+		RefreshToken: creds.IdentityToken,
+	}, nil
+}
+
+// newReferrersRepository returns the client of a repository at a pull source of a registry, set up with the
+// credentials, certificates and TLS verification containers/image would use to pull from it.
+func newReferrersRepository(sys *types.SystemContext, registry string, source sysregistriesv2.PullSource) (*remote.Repository, error) {
+	endpoint := reference.Domain(source.Reference)
+
+	repo, err := remote.NewRepository(registryHost(endpoint) + "/" + reference.Path(source.Reference))
+	if err != nil {
+		return nil, err
+	}
+
+	transport := tlsclientconfig.NewTransport()
+	transport.TLSClientConfig = &tls.Config{
+		InsecureSkipVerify: source.Endpoint.Insecure || sys.DockerInsecureSkipTLSVerify == types.OptionalBoolTrue,
+	}
+
+	for _, dir := range registryCertDirs {
+		if err := tlsclientconfig.SetupCertificates(filepath.Join(dir, endpoint), transport.TLSClientConfig); err != nil {
+			return nil, err
+		}
+	}
+
+	credential, err := getRegistryCredential(sys, registry, endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	repo.Client = &auth.Client{
+		Client:     &http.Client{Transport: transport},
+		Cache:      referrersAuthCache,
+		Credential: auth.StaticCredential(registryHost(endpoint), credential),
+	}
+	// Registries without the API are only told apart from those with it, referrer tags are synced separately
+	repo.SetReferrersCapability(true)
+
+	return repo, nil
+}
+
+// getAPIReferrers returns the manifests attached to a subject through the OCI 1.1 referrers API of the source
+// registry, and false if the registry doesn't support it, in which case referrers are only found by their tags.
+// Mirrors configured for the registry are asked first.
+func getAPIReferrers(ctx context.Context, image *structs.Image, subject digest.Digest) ([]imgspecv1.Descriptor, bool, error) {
+	if getRepositoryType(image.Source) != OCIRepository {
+		return nil, false, nil
+	}
+
+	named, err := reference.ParseNormalizedNamed(image.Source)
+	if err != nil {
+		return nil, false, err
+	}
+
+	registry := reference.Domain(named)
+
+	if item := referrersAPISupport.Get(registry); item != nil && !item.Value() {
+		return nil, false, nil
+	}
+
+	digested, err := reference.WithDigest(named, subject)
+	if err != nil {
+		return nil, false, err
+	}
+
+	sys, _ := getSourceContext(ctx, image)
+
+	sources, err := getPullSources(sys, digested)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var errs []error
+
+	for _, source := range sources {
+		repo, err := newReferrersRepository(sys, registry, source)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		var referrers []imgspecv1.Descriptor
+
+		err = repo.Referrers(ctx, imgspecv1.Descriptor{Digest: subject}, "", func(page []imgspecv1.Descriptor) error {
+			referrers = append(referrers, page...)
+			return nil
+		})
+		if err == nil {
+			referrersAPISupport.Set(registry, true, ttlcache.DefaultTTL)
+
+			return referrers, true, nil
+		}
+
+		if !errors.Is(err, errdef.ErrUnsupported) {
+			errs = append(errs, fmt.Errorf("failed to list referrers of %s@%s: %w", image.Source, subject, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, false, errors.Join(errs...)
+	}
+
+	referrersAPISupport.Set(registry, false, ttlcache.DefaultTTL)
+
+	return nil, false, nil
+}
+
+// getDigestReference returns the reference of a manifest of a registry repository, by digest.
+func getDigestReference(repository string, d digest.Digest) (types.ImageReference, error) {
+	named, err := reference.ParseNormalizedNamed(repository)
+	if err != nil {
+		return nil, err
+	}
+
+	digested, err := reference.WithDigest(named, d)
+	if err != nil {
+		return nil, err
+	}
+
+	return docker.NewReference(digested)
+}
+
+// getReferrerReferences returns the source and target references of a referrer copied by digest.
+func getReferrerReferences(image *structs.Image, dst string, d digest.Digest) (types.ImageReference, types.ImageReference, error) {
+	srcRef, err := getDigestReference(image.Source, d)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dstRef, err := getDigestReference(dst, d)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return srcRef, dstRef, nil
+}
+
+// hasReferrer reports whether a referrer was already copied to a registry target.
+func hasReferrer(ctx context.Context, image *structs.Image, dst string, d digest.Digest) bool {
+	key := fmt.Sprintf("%s@%s", dst, d)
+	if copiedReferrers.Has(key) {
+		return true
+	}
+
+	_, dstRef, err := getReferrerReferences(image, dst, d)
+	if err != nil {
+		return false
+	}
+
+	dstCtx, _ := getRegistryContext(ctx, image.GetRegistry(dst), image.GetRepository(dst))
+
+	if _, err := docker.GetDigest(ctx, dstCtx, dstRef); err != nil {
+		return false
+	}
+
+	copiedReferrers.Set(key, true, ttlcache.DefaultTTL)
+
+	return true
+}
+
+// copyReferrer copies a referrer found through the referrers API to a registry target, by digest. Registries
+// with the API list it through its subject.
+func copyReferrer(ctx context.Context, image *structs.Image, dst string, d digest.Digest) error {
+	if hasReferrer(ctx, image, dst, d) {
+		return nil
+	}
+
+	release, err := acquireRegistries(ctx, image, dst)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	srcRef, dstRef, err := getReferrerReferences(image, dst, d)
+	if err != nil {
+		return err
+	}
+
+	srcCtx, _ := getSourceContext(ctx, image)
+
+	dstCtx, _ := getRegistryContext(ctx, image.GetRegistry(dst), image.GetRepository(dst))
+
+	policyContext, destroy, err := newPolicyContext(image, srcCtx)
+	if err != nil {
+		return err
+	}
+	defer destroy()
+
+	if _, err := copy.Image(ctx, policyContext, dstRef, srcRef, &copy.Options{
+		SourceCtx:          srcCtx,
+		DestinationCtx:     dstCtx,
+		ImageListSelection: copy.CopyAllImages,
+	}); err != nil {
+		return err
+	}
+
+	copiedReferrers.Set(fmt.Sprintf("%s@%s", dst, d), true, ttlcache.DefaultTTL)
+
+	return nil
+}
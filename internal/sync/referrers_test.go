@@ -0,0 +1,248 @@
+package sync
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Altinity/docker-sync/structs"
+	"github.com/containers/image/v5/types"
+	"github.com/jellydator/ttlcache/v3"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+const testSubject = "1111111111111111111111111111111111111111111111111111111111111111"
+
+func TestIsReferrerTag(t *testing.T) {
+	tests := []struct {
+		tag      string
+		expected bool
+	}{
+		{"sha256-" + testSubject + ".sig", true},
+		{"sha256-" + testSubject + ".att", true},
+		{"sha256-" + testSubject + ".sbom", true},
+		{"sha256-" + testSubject, true},
+		{"sha256-" + testSubject + ".txt", false},
+		{"sha256-1234.sig", false},
+		{"latest", false},
+	}
+
+	for _, tt := range tests {
+		d, ok := isReferrerTag(tt.tag)
+		assert.Equal(t, tt.expected, ok, tt.tag)
+
+		if ok {
+			assert.Equal(t, digest.Digest("sha256:"+testSubject), d)
+		}
+	}
+}
+
+func TestWithoutReferrerTags(t *testing.T) {
+	tags := []string{"1.0", "sha256-" + testSubject + ".sig", "latest"}
+
+	assert.Equal(t, []string{"1.0", "latest"}, withoutReferrerTags(tags))
+	assert.Equal(t, []string{"sha256-" + testSubject + ".sig"}, onlyReferrerTags(tags))
+	assert.Len(t, tags, 3)
+}
+
+func TestAttachedTags(t *testing.T) {
+	image := &structs.Image{
+		Source:  "altinity/image",
+		Targets: []string{"ghcr.io/altinity/image", "r2:account-id:bucket:altinity/image"},
+	}
+
+	subjects := map[digest.Digest]struct{}{
+		digest.Digest("sha256:" + testSubject): {},
+	}
+
+	dstTags := []string{
+		"ghcr.io/altinity/image:1.0",
+		"ghcr.io/altinity/image:sha256-" + testSubject + ".sig",
+		"ghcr.io/altinity/image:sha256-2222222222222222222222222222222222222222222222222222222222222222.sig",
+		"r2:account-id:bucket:altinity/image:sha256-" + testSubject + ".att",
+		"ghcr.io/other/image:sha256-" + testSubject + ".sbom",
+	}
+
+	assert.Equal(t, []string{
+		"sha256-" + testSubject + ".att",
+		"sha256-" + testSubject + ".sig",
+	}, attachedTags(image, dstTags, subjects))
+}
+
+func TestPlanReferrerTag(t *testing.T) {
+	image := &structs.Image{
+		Source:  "altinity/image",
+		Targets: []string{"ghcr.io/altinity/image", "r2:account-id:bucket:altinity/image"},
+	}
+
+	tag := "sha256-" + testSubject + ".sig"
+	dstTags := []string{"ghcr.io/altinity/image:" + tag}
+
+	assert.Equal(t, []Action{
+		{Image: "altinity/image", Target: "ghcr.io/altinity/image", Tag: tag, Type: ActionOverwrite},
+		{Image: "altinity/image", Target: "r2:account-id:bucket:altinity/image", Tag: tag, Type: ActionCopy},
+	}, planReferrerTag(image, tag, dstTags))
+}
+
+func TestReferrerImage(t *testing.T) {
+	image := &structs.Image{
+		Source:        "altinity/image",
+		Platforms:     []string{"linux/amd64"},
+		PlatformIndex: "rewrite",
+		Policy:        &structs.SignaturePolicy{Type: "sigstoreSigned", KeyPath: "/cosign.pub"},
+	}
+
+	referrer := referrerImage(image)
+	assert.Empty(t, referrer.Platforms)
+	assert.False(t, referrer.Policy.IsEnforced())
+
+	// The original image is left untouched
+	assert.Equal(t, []string{"linux/amd64"}, image.Platforms)
+	assert.True(t, image.Policy.IsEnforced())
+}
+
+func TestGetSubjectDigests(t *testing.T) {
+	path, src := setupTestLayout(t)
+
+	index, err := readOCILayoutIndex(path)
+	assert.NoError(t, err)
+
+	image := &structs.Image{Source: src}
+	tag20 := index.Manifests[1].Digest
+
+	// The digest is read from the source, and the manifest once to find its instances
+	subjects, err := getSubjectDigests(t.Context(), image, &types.SystemContext{}, []string{"2.0"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, map[digest.Digest]struct{}{tag20: {}}, subjects)
+	assert.NotNil(t, manifestInstances.Get(tag20))
+
+	// Known digests are used as is, with the cached instances
+	known := digest.NewDigestFromEncoded(digest.SHA256, testSubject)
+	instance := digest.Digest("sha256:2222222222222222222222222222222222222222222222222222222222222222")
+	manifestInstances.Set(known, []digest.Digest{instance}, ttlcache.DefaultTTL)
+
+	subjects, err = getSubjectDigests(t.Context(), image, &types.SystemContext{}, []string{"2.0"}, map[string]string{"2.0": known.String()})
+	assert.NoError(t, err)
+	assert.Equal(t, map[digest.Digest]struct{}{known: {}, instance: {}}, subjects)
+}
+
+func TestGetAPIReferrers(t *testing.T) {
+	subject := digest.NewDigestFromEncoded(digest.SHA256, testSubject)
+	referrer := digest.Digest("sha256:2222222222222222222222222222222222222222222222222222222222222222")
+
+	var server *httptest.Server
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/token":
+			assert.Regexp(t, `^repository:altinity/\w+:pull$`, r.URL.Query().Get("scope"))
+			_, _ = w.Write([]byte(`{"token":"test-token"}`))
+		case r.Header.Get("Authorization") != "Bearer test-token":
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.URL.Path == "/v2/altinity/image/referrers/"+subject.String() && r.URL.Query().Get("page") == "":
+			w.Header().Set("Content-Type", imgspecv1.MediaTypeImageIndex)
+			w.Header().Set("Link", `</v2/altinity/image/referrers/`+subject.String()+`?page=2>; rel="next"`)
+			_, _ = w.Write([]byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.index.v1+json","manifests":[{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":"` + referrer.String() + `","size":100,"artifactType":"application/vnd.example.sbom"}]}`))
+		case r.URL.Path == "/v2/altinity/image/referrers/"+subject.String():
+			w.Header().Set("Content-Type", imgspecv1.MediaTypeImageIndex)
+			_, _ = w.Write([]byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.index.v1+json","manifests":[]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "https://")
+
+	// The registry certificate is trusted through a certs.d directory, as with containers/image
+	certDir := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(certDir, host), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(certDir, host, "ca.crt"), pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: server.Certificate().Raw,
+	}), 0o644))
+
+	certDirs := registryCertDirs
+	registryCertDirs = []string{certDir}
+	t.Cleanup(func() {
+		registryCertDirs = certDirs
+		referrersAPISupport.DeleteAll()
+	})
+
+	referrers, supported, err := getAPIReferrers(t.Context(), &structs.Image{Source: host + "/altinity/image"}, subject)
+	assert.NoError(t, err)
+	assert.True(t, supported)
+	assert.Len(t, referrers, 1)
+	assert.Equal(t, referrer, referrers[0].Digest)
+
+	// A registry answering 404 has no API, so referrer tags are used and it is not asked again
+	_, supported, err = getAPIReferrers(t.Context(), &structs.Image{Source: host + "/altinity/missing"}, subject)
+	assert.NoError(t, err)
+	assert.False(t, supported)
+
+	_, supported, err = getAPIReferrers(t.Context(), &structs.Image{Source: host + "/altinity/image"}, subject)
+	assert.NoError(t, err)
+	assert.False(t, supported)
+
+	// Other sources have no referrers API
+	_, supported, err = getAPIReferrers(t.Context(), &structs.Image{Source: "oci:/tmp/layout"}, subject)
+	assert.NoError(t, err)
+	assert.False(t, supported)
+}
+
+func TestReferrersIndex(t *testing.T) {
+	subject := digest.NewDigestFromEncoded(digest.SHA256, testSubject)
+	image := &structs.Image{
+		Source:  "altinity/image",
+		Targets: []string{"ghcr.io/altinity/image", "oci:" + filepath.Join(t.TempDir(), "layout"), "r2:account-id:bucket:altinity/image"},
+	}
+
+	sbom := imgspecv1.Descriptor{
+		MediaType:    imgspecv1.MediaTypeImageManifest,
+		Digest:       "sha256:3333333333333333333333333333333333333333333333333333333333333333",
+		Size:         100,
+		ArtifactType: "application/vnd.example.sbom",
+	}
+	signature := imgspecv1.Descriptor{
+		MediaType:    imgspecv1.MediaTypeImageManifest,
+		Digest:       "sha256:2222222222222222222222222222222222222222222222222222222222222222",
+		Size:         200,
+		ArtifactType: "application/vnd.example.signature",
+	}
+
+	// The referrers are sorted and listed once, so the index only changes with them
+	index, err := getReferrersIndex(image, subject, []imgspecv1.Descriptor{sbom, signature, sbom})
+	assert.NoError(t, err)
+
+	other, err := getReferrersIndex(image, subject, []imgspecv1.Descriptor{signature, sbom})
+	assert.NoError(t, err)
+	assert.Equal(t, index.index, other.index)
+
+	var decoded imgspecv1.Index
+	assert.NoError(t, json.Unmarshal(index.index, &decoded))
+	assert.Equal(t, imgspecv1.MediaTypeImageIndex, decoded.MediaType)
+	assert.Equal(t, []imgspecv1.Descriptor{signature, sbom}, decoded.Manifests)
+
+	// The index is read from the subject, but isn't checked against its digest
+	assert.Equal(t, "docker.io/altinity/image@"+subject.String(), index.ImageReference.DockerReference().String())
+	assert.Equal(t, "docker.io/altinity/image", index.DockerReference().String())
+
+	// Registries get the referrers by digest, the others get the index as the referrers tag of the subject
+	tag := "sha256-" + testSubject
+	assert.Equal(t, tag, referrersTag(subject))
+
+	dstTags := []string{image.Targets[2] + ":" + tag}
+
+	assert.Equal(t, []Action{
+		{Image: image.Source, Target: image.Targets[1], Tag: tag, Type: ActionCopy, Digest: index.indexDigest()},
+		{Image: image.Source, Target: image.Targets[2], Tag: tag, Type: ActionOverwrite, Digest: index.indexDigest()},
+	}, planReferrersIndex(t.Context(), image, subject, index, dstTags))
+}
@@ -1,28 +1,26 @@
 package sync
 
 import (
-	"bytes"
 	"context"
 	"crypto/md5"
 	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"io/fs"
 	"net/http"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	awstypes "github.com/aws/aws-sdk-go-v2/service/s3/types"
 
 	"github.com/containers/image/v5/copy"
-	"github.com/containers/image/v5/docker"
-	"github.com/containers/image/v5/signature"
 
 	"github.com/Altinity/docker-sync/config"
 	"github.com/Altinity/docker-sync/structs"
@@ -31,14 +29,15 @@ import (
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/containers/image/v5/directory"
 	"github.com/containers/image/v5/types"
 	"github.com/jellydator/ttlcache/v3"
 	"github.com/rs/zerolog/log"
-	"golang.org/x/sync/errgroup"
 )
 
-var bucketInitCache = make(map[string]struct{})
+var (
+	bucketInitCache      = make(map[string]struct{})
+	bucketInitCacheMutex sync.Mutex
+)
 
 func getS3Session(url string) (*s3.Client, *string, error) {
 	fields := strings.Split(url, ":")
@@ -54,39 +53,93 @@ func getS3Session(url string) (*s3.Client, *string, error) {
 	region := fields[1]
 	bucket := aws.String(fields[2])
 
+	s3Session, err := newS3Client(strings.Join(fields[:3], ":"), region, "", accessKey, secretKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return s3Session, bucket, nil
+}
+
+// newS3Client creates a client for a bucket, applying the S3 options of its registry on top of the given
+// region and endpoint.
+func newS3Client(registry string, region string, endpoint string, accessKey string, secretKey string) (*s3.Client, error) {
+	var opts structs.RepositoryS3
+	if repo := getRepository(registry); repo != nil && repo.S3 != nil {
+		opts = *repo.S3
+	}
+
+	if opts.Region != "" {
+		region = opts.Region
+	}
+	if opts.Endpoint != "" {
+		endpoint = opts.Endpoint
+	}
+
+	httpClient := &http.Client{Timeout: 300 * time.Second}
+
+	if opts.CAFile != "" {
+		pem, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", opts.CAFile)
+		}
+
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+		httpClient.Transport = transport
+	}
+
 	cfg, err := awsconfig.LoadDefaultConfig(context.TODO(),
 		awsconfig.WithRegion(region),
 		awsconfig.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(accessKey, secretKey, "")),
-		awsconfig.WithHTTPClient(&http.Client{Timeout: 300 * time.Second}),
+		awsconfig.WithHTTPClient(httpClient),
 	)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	return s3.NewFromConfig(cfg), bucket, nil
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+		o.UsePathStyle = opts.PathStyle
+	}), nil
 }
 
-func pushS3(ctx context.Context, image *structs.Image, dst string, repository string, tag string) error {
+func pushS3(ctx context.Context, image *structs.Image, dst string, repository string, tag string, index *indexSourceReference) error {
 	s3Session, bucket, err := getS3Session(dst)
 	if err != nil {
 		return err
 	}
 
-	return pushS3WithSession(ctx, s3Session, bucket, dst, repository, image, tag)
+	return pushS3WithSession(ctx, s3Session, bucket, dst, repository, image, tag, index)
 }
 
-func pushS3WithSession(ctx context.Context, s3Session *s3.Client, bucket *string, dst string, repository string, image *structs.Image, tag string) error {
+func pushS3WithSession(ctx context.Context, s3Session *s3.Client, bucket *string, dst string, repository string, image *structs.Image, tag string, index *indexSourceReference) error {
 	s3c := &s3Client{
-		uploader:  manager.NewUploader(s3Session),
-		s3Session: s3Session,
-		dst:       dst,
-		bucket:    bucket,
-		acl:       aws.String("public-read"),
-		baseDir:   filepath.Join("v2", repository),
+		uploader:      manager.NewUploader(s3Session),
+		s3Session:     s3Session,
+		dst:           dst,
+		bucket:        bucket,
+		baseDir:       filepath.Join("v2", repository),
+		sharedBlobs:   isSharedBlobs(dst),
+		objectOptions: getObjectOptions(dst),
 	}
 
 	bucketInitCacheKey := fmt.Sprintf("%s/%s", image.GetRegistry(dst), *bucket)
-	if _, ok := bucketInitCache[bucketInitCacheKey]; !ok {
+	bucketInitCacheMutex.Lock()
+	_, ok := bucketInitCache[bucketInitCacheKey]
+	bucketInitCacheMutex.Unlock()
+	if !ok {
 		if err := syncObject(
 			ctx,
 			s3c,
@@ -97,49 +150,40 @@ func pushS3WithSession(ctx context.Context, s3Session *s3.Client, bucket *string
 		); err != nil {
 			return err
 		}
+		bucketInitCacheMutex.Lock()
 		bucketInitCache[bucketInitCacheKey] = struct{}{}
+		bucketInitCacheMutex.Unlock()
 	}
 
-	tmpDir, err := os.MkdirTemp(os.TempDir(), "docker-sync-*")
+	srcRef, err := getPushReference(image, tag, index)
 	if err != nil {
 		return err
 	}
-	defer os.RemoveAll(tmpDir)
 
-	baseDir, err := os.MkdirTemp(os.TempDir(), "docker-sync-base-*")
-	if err != nil {
-		return err
-	}
-	defer os.RemoveAll(baseDir)
+	srcCtx, _ := getSourceContext(ctx, image)
 
-	baseDir = filepath.Join(baseDir, "v2", repository)
-
-	if err := os.MkdirAll(filepath.Join(baseDir, "blobs"), 0o755); err != nil {
-		return err
-	}
-	if err := os.MkdirAll(filepath.Join(baseDir, "manifests"), 0o755); err != nil {
+	policyContext, destroy, err := newPolicyContext(image, srcCtx)
+	if err != nil {
 		return err
 	}
+	defer destroy()
 
-	dstRef, err := directory.NewReference(tmpDir)
+	selection, err := selectPlatforms(ctx, image, srcRef, srcCtx)
 	if err != nil {
 		return err
 	}
 
-	srcRef, err := docker.ParseReference(fmt.Sprintf("//%s:%s", image.Source, tag))
+	// Garbage collection must not delete the blobs that are uploaded or reused until the tag manifest lands
+	release, err := acquirePushLock(ctx, s3Session, *bucket, repository)
 	if err != nil {
 		return err
 	}
+	defer release()
 
-	srcAuth, _ := getSkopeoAuth(ctx, image.GetSourceRegistry(), image.GetSourceRepository())
-	srcCtx := &types.SystemContext{
-		DockerAuthConfig: srcAuth,
-	}
-
-	policy := &signature.Policy{Default: []signature.PolicyRequirement{signature.NewPRInsecureAcceptAnything()}}
-	policyContext, err := signature.NewPolicyContext(policy)
-	if err != nil {
-		return err
+	dstRef := &s3Reference{
+		s3c:   s3c,
+		tag:   tag,
+		index: selection.index,
 	}
 
 	ch := make(chan types.ProgressProperties)
@@ -149,162 +193,21 @@ func pushS3WithSession(ctx context.Context, s3Session *s3.Client, bucket *string
 	defer cancel()
 	go dockerDataCounter(chCtx, image.Source, "", ch)
 
-	if _, err := copy.Image(ctx, policyContext, dstRef, srcRef, &copy.Options{
-		SourceCtx:          srcCtx,
-		ImageListSelection: copy.CopyAllImages,
-		ProgressInterval:   time.Second,
-		Progress:           ch,
-	}); err != nil {
-		return err
-	}
-
-	var blobs []string
-	var manifests []string
-	var tagManifest string
-
-	// walk all files
-	if err := filepath.WalkDir(tmpDir, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-
-		fi, err := os.Stat(path)
-		if err != nil {
-			return err
-		}
-		if fi.IsDir() {
-			return nil
-		}
-
-		base := filepath.Base(path)
-		switch {
-		case base == "version":
-			os.Remove(path)
-		case base == "manifest.json":
-			f, err := os.Open(path)
-			if err != nil {
-				return err
-			}
-			if err := func() error {
-				defer f.Close()
-
-				newPath := filepath.Join(baseDir, "manifests", tag)
-
-				if err := os.Link(path, newPath); err != nil {
-					return err
-				}
-
-				tagManifest = newPath
-
-				return nil
-			}(); err != nil {
-				return err
-			}
-
-			newPath, err := shamove(baseDir, path, "manifests")
-			if err != nil {
-				return err
-			}
-
-			manifests = append(manifests, newPath)
-		case strings.HasSuffix(path, ".manifest.json"):
-			newPath, err := shamove(baseDir, path, "manifests")
-			if err != nil {
-				return err
-			}
-			manifests = append(manifests, newPath)
-		default:
-			newPath, err := shamove(baseDir, path, "blobs")
-			if err != nil {
-				return err
-			}
-			blobs = append(blobs, newPath)
-		}
-
-		return nil
-	}); err != nil {
-		return err
-	}
-
-	// Adds the tag manifest last to all others are uploaded first
-	manifests = append(manifests, tagManifest)
-
 	log.Info().
 		Str("bucket", *bucket).
 		Str("repository", repository).
 		Str("tag", tag).
-		Int("layers", len(blobs)).
-		Int("manifests", len(manifests)).
 		Msg("Syncing objects")
 
-	// Limit upload concurrency
-	g, _ := errgroup.WithContext(ctx)
-	g.SetLimit(config.SyncS3MaxConcurrentUploads.Int())
-
-	for _, fname := range blobs {
-		g.Go(func() error {
-			f, err := os.Open(fname)
-			if err != nil {
-				return err
-			}
-			defer f.Close()
-
-			key := filepath.Join(s3c.baseDir, "blobs", filepath.Base(fname))
-
-			mediaType := "application/vnd.docker.image.rootfs.diff.tar.gzip"
-
-			if err := syncObject(
-				ctx,
-				s3c,
-				key,
-				aws.String(mediaType),
-				f,
-				false,
-			); err != nil {
-				return err
-			}
-
-			return nil
-		})
-	}
-	if err := g.Wait(); err != nil {
-		return err
-	}
-
-	for _, fname := range manifests {
-		g.Go(func() error {
-			key := filepath.Join(s3c.baseDir, "manifests", filepath.Base(fname))
-
-			b, err := os.ReadFile(fname)
-			if err != nil {
-				return err
-			}
-
-			var mwmt manifestWithMediaType
-
-			if err := json.Unmarshal(b, &mwmt); err != nil {
-				return err
-			}
-
-			if mwmt.MediaType == "" {
-				mwmt.MediaType = "application/vnd.docker.distribution.manifest.v1+prettyjws"
-			}
-
-			if err := syncObject(
-				ctx,
-				s3c,
-				key,
-				aws.String(mwmt.MediaType),
-				bytes.NewReader(b),
-				strings.HasSuffix(key, fmt.Sprintf("/%s", tag)),
-			); err != nil {
-				return err
-			}
-
-			return nil
-		})
-	}
-	if err := g.Wait(); err != nil {
+	if _, err := copy.Image(ctx, policyContext, dstRef, srcRef, &copy.Options{
+		SourceCtx:            srcCtx,
+		ImageListSelection:   selection.selection,
+		Instances:            selection.instances,
+		RemoveSignatures:     true, // Signatures are not part of the bucket layout
+		MaxParallelDownloads: uint(max(1, config.SyncS3MaxConcurrentUploads.Int())),
+		ProgressInterval:     time.Second,
+		Progress:             ch,
+	}); err != nil {
 		return err
 	}
 
@@ -351,7 +254,7 @@ func syncObject(
 		return nil
 	}
 
-	// Blobs can be huge and we need an io.ReadSeeker, so we can't read them all into memory.
+	// The upload needs an io.ReadSeeker, so the object is staged on disk while it is hashed.
 	tmpFile, err := os.CreateTemp("", "blob-*")
 	if err != nil {
 		return fmt.Errorf("failed to create temp file: %w", err)
@@ -403,17 +306,19 @@ func syncObject(
 		f:    tmpFile,
 	}
 
-	if _, err := s3c.uploader.Upload(ctx, &s3.PutObjectInput{
+	input := &s3.PutObjectInput{
 		Bucket:      s3c.bucket,
 		Key:         aws.String(key),
 		Body:        dataCounter,
-		ACL:         awstypes.ObjectCannedACL(*s3c.acl),
 		ContentType: contentType,
 		ContentMD5:  aws.String(contentMD5),
 		Metadata: map[string]string{
 			"x-calculated-digest": calculatedDigest,
 		},
-	}); err != nil {
+	}
+	s3c.objectOptions.apply(input)
+
+	if _, err := s3c.uploader.Upload(ctx, input); err != nil {
 		return fmt.Errorf("failed to upload object: %w", err)
 	}
 
@@ -0,0 +1,377 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/Altinity/docker-sync/config"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	awstypes "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
+	"github.com/jellydator/ttlcache/v3"
+	"github.com/opencontainers/go-digest"
+	"github.com/rs/zerolog/log"
+)
+
+// s3Transport is the transport of bucket references. They are only created from bucket sources and targets, so
+// they can't be parsed.
+type s3Transport struct{}
+
+func (s3Transport) Name() string {
+	return "docker-sync-s3"
+}
+
+func (s3Transport) ParseReference(reference string) (types.ImageReference, error) {
+	return nil, errors.New("bucket references can't be parsed")
+}
+
+func (s3Transport) ValidatePolicyConfigurationScope(scope string) error {
+	return nil
+}
+
+// s3Reference is a tag of a repository in a bucket, which copy.Image reads from or writes to directly.
+type s3Reference struct {
+	s3c *s3Client
+	tag string
+	// index replaces the top-level manifest list, when platforms are filtered with platformIndex: rewrite.
+	index []byte
+}
+
+func (r *s3Reference) Transport() types.ImageTransport {
+	return s3Transport{}
+}
+
+func (r *s3Reference) StringWithinTransport() string {
+	return fmt.Sprintf("%s:%s", r.s3c.dst, r.tag)
+}
+
+func (r *s3Reference) DockerReference() reference.Named {
+	return nil
+}
+
+// PolicyConfigurationIdentity returns <bucket>/<repository>, so policy files can scope bucket sources under the
+// docker-sync-s3 transport. The endpoint and region are not part of it, so buckets of the same name in different
+// registries share their policy.
+func (r *s3Reference) PolicyConfigurationIdentity() string {
+	return path.Join(*r.s3c.bucket, strings.TrimPrefix(r.s3c.baseDir, "v2/"))
+}
+
+// PolicyConfigurationNamespaces returns the parent repositories of the reference, up to its bucket.
+func (r *s3Reference) PolicyConfigurationNamespaces() []string {
+	var namespaces []string
+
+	for name := path.Dir(r.PolicyConfigurationIdentity()); name != "." && name != "/"; name = path.Dir(name) {
+		namespaces = append(namespaces, name)
+	}
+
+	return namespaces
+}
+
+func (r *s3Reference) NewImage(ctx context.Context, sys *types.SystemContext) (types.ImageCloser, error) {
+	return nil, errors.New("bucket references can only be read as image sources")
+}
+
+func (r *s3Reference) NewImageSource(ctx context.Context, sys *types.SystemContext) (types.ImageSource, error) {
+	return &s3Source{ref: r}, nil
+}
+
+func (r *s3Reference) NewImageDestination(ctx context.Context, sys *types.SystemContext) (types.ImageDestination, error) {
+	return &s3Destination{ref: r}, nil
+}
+
+func (r *s3Reference) DeleteImage(ctx context.Context, sys *types.SystemContext) error {
+	return errors.New("bucket references can't be deleted")
+}
+
+// s3Destination streams blobs and manifests to a bucket. The tag manifest is written on commit, once everything
+// it references is uploaded.
+type s3Destination struct {
+	ref *s3Reference
+	// manifest is the top-level manifest, written to the tag on commit.
+	manifest []byte
+}
+
+func (d *s3Destination) Reference() types.ImageReference {
+	return d.ref
+}
+
+func (d *s3Destination) Close() error {
+	return nil
+}
+
+func (d *s3Destination) SupportedManifestMIMETypes() []string {
+	return nil
+}
+
+func (d *s3Destination) SupportsSignatures(ctx context.Context) error {
+	return errors.New("signatures are not part of the bucket layout")
+}
+
+func (d *s3Destination) DesiredLayerCompression() types.LayerCompression {
+	return types.PreserveOriginal
+}
+
+func (d *s3Destination) AcceptsForeignLayerURLs() bool {
+	return false
+}
+
+func (d *s3Destination) MustMatchRuntimeOS() bool {
+	return false
+}
+
+func (d *s3Destination) IgnoresEmbeddedDockerReference() bool {
+	return true
+}
+
+func (d *s3Destination) HasThreadSafePutBlob() bool {
+	return true
+}
+
+func (d *s3Destination) blobKey(blobDigest digest.Digest) string {
+	return path.Join(d.ref.s3c.baseDir, "blobs", blobDigest.String())
+}
+
+// PutBlob streams a blob to the bucket. The digest names the object, so a blob whose digest isn't known up front
+// is staged on disk first.
+func (d *s3Destination) PutBlob(ctx context.Context, stream io.Reader, inputInfo types.BlobInfo, cache types.BlobInfoCache, isConfig bool) (types.BlobInfo, error) {
+	blobDigest := inputInfo.Digest
+
+	if blobDigest == "" {
+		f, err := os.CreateTemp("", "blob-*")
+		if err != nil {
+			return types.BlobInfo{}, fmt.Errorf("failed to create temp file: %w", err)
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+
+		digester := digest.Canonical.Digester()
+		if _, err := io.Copy(io.MultiWriter(f, digester.Hash()), stream); err != nil {
+			return types.BlobInfo{}, fmt.Errorf("failed to copy data to temp file: %w", err)
+		}
+
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return types.BlobInfo{}, fmt.Errorf("failed to seek to start of temp file: %w", err)
+		}
+
+		blobDigest = digester.Digest()
+		stream = f
+	}
+
+	if d.ref.s3c.sharedBlobs {
+		size, err := putBlobS3(ctx, d.ref.s3c, sharedBlobKey(blobDigest), stream, blobDigest)
+		if err != nil {
+			return types.BlobInfo{}, err
+		}
+
+		return types.BlobInfo{Digest: blobDigest, Size: size}, putBlobLink(ctx, d.ref.s3c, blobDigest)
+	}
+
+	size, err := putBlobS3(ctx, d.ref.s3c, d.blobKey(blobDigest), stream, blobDigest)
+	if err != nil {
+		return types.BlobInfo{}, err
+	}
+
+	return types.BlobInfo{Digest: blobDigest, Size: size}, nil
+}
+
+// TryReusingBlob skips blobs that are already stored in the bucket with the same digest. With the shared blob
+// store, blobs uploaded by another repository are linked to the repository instead.
+func (d *s3Destination) TryReusingBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache, canSubstitute bool) (bool, types.BlobInfo, error) {
+	if info.Digest == "" {
+		return false, types.BlobInfo{}, nil
+	}
+
+	s3c := d.ref.s3c
+	key := d.blobKey(info.Digest)
+	cacheKey := fmt.Sprintf("%s/%s", *s3c.bucket, key)
+
+	if info.Size >= 0 && config.SyncS3ObjectCacheEnabled.Bool() && objectCache.Has(cacheKey) {
+		log.Debug().
+			Str("bucket", *s3c.bucket).
+			Str("key", key).
+			Msg("Object seem recently, skipping upload")
+
+		return true, types.BlobInfo{Digest: info.Digest, Size: info.Size}, nil
+	}
+
+	exists, size, err := headBlobS3(ctx, s3c, key, info.Digest)
+	if err != nil {
+		return false, types.BlobInfo{}, err
+	}
+
+	if s3c.sharedBlobs {
+		// Links are empty, so the size comes from the shared blob
+		sharedExists, sharedSize, err := headBlobS3(ctx, s3c, sharedBlobKey(info.Digest), info.Digest)
+		if err != nil {
+			return false, types.BlobInfo{}, err
+		}
+
+		switch {
+		case sharedExists && !exists:
+			if err := putBlobLink(ctx, s3c, info.Digest); err != nil {
+				return false, types.BlobInfo{}, err
+			}
+		case sharedExists:
+		case exists && size > 0:
+			// Blobs stored in the repository before the shared blob store was enabled are kept
+			sharedSize = size
+		default:
+			return false, types.BlobInfo{}, nil
+		}
+
+		exists, size = true, sharedSize
+	}
+
+	if !exists {
+		return false, types.BlobInfo{}, nil
+	}
+
+	log.Debug().
+		Str("bucket", *s3c.bucket).
+		Str("key", key).
+		Msg("Object already exists with same digest, skipping upload")
+
+	if config.SyncS3ObjectCacheEnabled.Bool() {
+		objectCache.Set(cacheKey, true, ttlcache.DefaultTTL)
+	}
+
+	return true, types.BlobInfo{Digest: info.Digest, Size: size}, nil
+}
+
+// headBlobS3 reports whether a blob is stored with the same digest, and its size. Objects uploaded without the
+// digest metadata, or interrupted uploads of other tools, are not reused.
+func headBlobS3(ctx context.Context, s3c *s3Client, key string, blobDigest digest.Digest) (bool, int64, error) {
+	head, err := s3c.s3Session.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: s3c.bucket,
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var nf *awstypes.NotFound
+		if errors.As(err, &nf) {
+			return false, 0, nil
+		}
+
+		return false, 0, err
+	}
+
+	if head.Metadata["x-calculated-digest"] != blobDigest.String() {
+		return false, 0, nil
+	}
+
+	return true, aws.ToInt64(head.ContentLength), nil
+}
+
+// PutManifest uploads a manifest by digest. The top-level manifest is kept to be written to the tag on commit.
+func (d *s3Destination) PutManifest(ctx context.Context, b []byte, instanceDigest *digest.Digest) error {
+	if instanceDigest == nil && d.ref.index != nil {
+		b = d.ref.index
+	}
+
+	key := path.Join(d.ref.s3c.baseDir, "manifests", digest.FromBytes(b).String())
+
+	if err := syncObject(ctx, d.ref.s3c, key, aws.String(manifest.GuessMIMEType(b)), bytes.NewReader(b), false); err != nil {
+		return err
+	}
+
+	if instanceDigest == nil {
+		d.manifest = b
+	}
+
+	return nil
+}
+
+func (d *s3Destination) PutSignatures(ctx context.Context, signatures [][]byte, instanceDigest *digest.Digest) error {
+	if len(signatures) > 0 {
+		return errors.New("signatures are not part of the bucket layout")
+	}
+
+	return nil
+}
+
+// Commit writes the tag manifest, which makes the image visible.
+func (d *s3Destination) Commit(ctx context.Context, unparsedToplevel types.UnparsedImage) error {
+	if d.manifest == nil {
+		return errors.New("no manifest was written")
+	}
+
+	key := path.Join(d.ref.s3c.baseDir, "manifests", d.ref.tag)
+
+	return syncObject(ctx, d.ref.s3c, key, aws.String(manifest.GuessMIMEType(d.manifest)), bytes.NewReader(d.manifest), true)
+}
+
+// verifyingReader fails at the end of a blob stream that doesn't match its digest, instead of returning io.EOF,
+// so the upload reading it is aborted before the object is written.
+type verifyingReader struct {
+	r          io.Reader
+	verifier   digest.Verifier
+	blobDigest digest.Digest
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	if n > 0 {
+		_, _ = v.verifier.Write(p[:n])
+	}
+
+	if errors.Is(err, io.EOF) && !v.verifier.Verified() {
+		return n, fmt.Errorf("blob %s doesn't match its digest", v.blobDigest)
+	}
+
+	return n, err
+}
+
+// putBlobS3 streams a blob to a bucket with a multipart upload, hashing it on the way. A stream that doesn't match
+// the digest fails the upload, which aborts it, so the object is never written.
+func putBlobS3(ctx context.Context, s3c *s3Client, key string, r io.Reader, blobDigest digest.Digest) (int64, error) {
+	counter := &s3StreamCounter{
+		ctx: ctx,
+		r: &verifyingReader{
+			r:          r,
+			verifier:   blobDigest.Verifier(),
+			blobDigest: blobDigest,
+		},
+		dest: s3c.dst,
+	}
+
+	log.Info().
+		Str("bucket", *s3c.bucket).
+		Str("digest", blobDigest.String()).
+		Str("key", key).
+		Msg("Uploading object")
+
+	input := &s3.PutObjectInput{
+		Bucket:      s3c.bucket,
+		Key:         aws.String(key),
+		Body:        counter,
+		ContentType: aws.String("application/vnd.docker.image.rootfs.diff.tar.gzip"),
+		Metadata: map[string]string{
+			"x-calculated-digest": blobDigest.String(),
+		},
+	}
+	s3c.objectOptions.apply(input)
+
+	if _, err := s3c.uploader.Upload(ctx, input); err != nil {
+		return 0, fmt.Errorf("failed to upload object: %w", err)
+	}
+
+	log.Info().
+		Str("bucket", *s3c.bucket).
+		Str("key", key).
+		Int64("size", counter.size).
+		Msg("Uploaded object")
+
+	if config.SyncS3ObjectCacheEnabled.Bool() {
+		objectCache.Set(fmt.Sprintf("%s/%s", *s3c.bucket, key), true, ttlcache.DefaultTTL)
+	}
+
+	return counter.size, nil
+}
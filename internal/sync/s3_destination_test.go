@@ -0,0 +1,111 @@
+package sync
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestS3Destination(t *testing.T) {
+	f := setupFakeS3(t, "dest-bucket")
+
+	s3Session, bucket, err := getS3Session("s3:fake:dest-bucket:altinity/image")
+	assert.NoError(t, err)
+
+	ref := &s3Reference{
+		s3c: &s3Client{
+			uploader:  manager.NewUploader(s3Session),
+			s3Session: s3Session,
+			dst:       "s3:fake:dest-bucket:altinity/image",
+			bucket:    bucket,
+			baseDir:   "v2/altinity/image",
+		},
+		tag: "latest",
+	}
+
+	assert.Equal(t, "dest-bucket/altinity/image", ref.PolicyConfigurationIdentity())
+	assert.Equal(t, []string{"dest-bucket/altinity", "dest-bucket"}, ref.PolicyConfigurationNamespaces())
+
+	dest, err := ref.NewImageDestination(t.Context(), nil)
+	assert.NoError(t, err)
+	defer dest.Close()
+
+	layer := "layer"
+	layerDigest := digest.FromString(layer)
+
+	reused, _, err := dest.TryReusingBlob(t.Context(), types.BlobInfo{Digest: layerDigest, Size: -1}, nil, false)
+	assert.NoError(t, err)
+	assert.False(t, reused)
+
+	info, err := dest.PutBlob(t.Context(), strings.NewReader(layer), types.BlobInfo{Digest: layerDigest, Size: -1}, nil, false)
+	assert.NoError(t, err)
+	assert.Equal(t, types.BlobInfo{Digest: layerDigest, Size: int64(len(layer))}, info)
+	assert.Equal(t, []byte(layer), f.get("dest-bucket", "v2/altinity/image/blobs/"+layerDigest.String()))
+
+	reused, info, err = dest.TryReusingBlob(t.Context(), types.BlobInfo{Digest: layerDigest, Size: -1}, nil, false)
+	assert.NoError(t, err)
+	assert.True(t, reused)
+	assert.Equal(t, int64(len(layer)), info.Size)
+
+	// Blobs whose digest is unknown are hashed before the upload
+	info, err = dest.PutBlob(t.Context(), strings.NewReader("config"), types.BlobInfo{Size: -1}, nil, true)
+	assert.NoError(t, err)
+	assert.Equal(t, digest.FromString("config"), info.Digest)
+	assert.Equal(t, []byte("config"), f.get("dest-bucket", "v2/altinity/image/blobs/"+digest.FromString("config").String()))
+
+	// Streams that don't match their digest are never written
+	corrupted := digest.FromString("expected")
+	f.beforePut = func(key string) {
+		assert.NotEqual(t, "v2/altinity/image/blobs/"+corrupted.String(), key)
+	}
+	_, err = dest.PutBlob(t.Context(), strings.NewReader("actual"), types.BlobInfo{Digest: corrupted, Size: -1}, nil, false)
+	assert.ErrorContains(t, err, "doesn't match its digest")
+	assert.Nil(t, f.get("dest-bucket", "v2/altinity/image/blobs/"+corrupted.String()))
+	f.beforePut = nil
+
+	m := `{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","config":{"digest":"` + info.Digest.String() + `"},"layers":[{"digest":"` + layerDigest.String() + `"}]}`
+	assert.NoError(t, dest.PutManifest(t.Context(), []byte(m), nil))
+	assert.Equal(t, []byte(m), f.get("dest-bucket", "v2/altinity/image/manifests/"+digest.FromString(m).String()))
+
+	// The tag is only written on commit
+	assert.Nil(t, f.get("dest-bucket", "v2/altinity/image/manifests/latest"))
+	assert.NoError(t, dest.Commit(t.Context(), nil))
+	assert.Equal(t, []byte(m), f.get("dest-bucket", "v2/altinity/image/manifests/latest"))
+}
+
+func TestS3DestinationIndex(t *testing.T) {
+	f := setupFakeS3(t, "dest-index-bucket")
+
+	s3Session, bucket, err := getS3Session("s3:fake:dest-index-bucket:altinity/image")
+	assert.NoError(t, err)
+
+	ref := &s3Reference{
+		s3c: &s3Client{
+			uploader:  manager.NewUploader(s3Session),
+			s3Session: s3Session,
+			dst:       "s3:fake:dest-index-bucket:altinity/image",
+			bucket:    bucket,
+			baseDir:   "v2/altinity/image",
+		},
+		tag:   "latest",
+		index: []byte(`{"schemaVersion":2,"manifests":[]}`),
+	}
+
+	dest, err := ref.NewImageDestination(t.Context(), nil)
+	assert.NoError(t, err)
+	defer dest.Close()
+
+	instance := digest.FromString("instance")
+	assert.NoError(t, dest.PutManifest(t.Context(), []byte("instance"), &instance))
+	assert.NoError(t, dest.PutManifest(t.Context(), []byte(testIndex), nil))
+	assert.NoError(t, dest.Commit(t.Context(), nil))
+
+	// The rewritten index replaces the copied one
+	assert.Equal(t, ref.index, f.get("dest-index-bucket", "v2/altinity/image/manifests/latest"))
+	assert.Nil(t, f.get("dest-index-bucket", "v2/altinity/image/manifests/"+digest.FromString(testIndex).String()))
+	assert.NotNil(t, f.get("dest-index-bucket", "v2/altinity/image/manifests/"+instance.String()))
+}
@@ -0,0 +1,218 @@
+package sync
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Altinity/docker-sync/config"
+	"github.com/spf13/viper"
+)
+
+// fakeS3Object is an object stored by fakeS3.
+type fakeS3Object struct {
+	body         []byte
+	metadata     map[string]string
+	headers      http.Header
+	etag         string
+	lastModified time.Time
+}
+
+func newFakeS3Object(body []byte) *fakeS3Object {
+	sum := md5.Sum(body)
+
+	return &fakeS3Object{
+		body:         body,
+		metadata:     make(map[string]string),
+		etag:         fmt.Sprintf("%q", hex.EncodeToString(sum[:])),
+		lastModified: time.Now(),
+	}
+}
+
+// fakeS3 is an in-memory S3 server supporting the path-style object operations docker-sync uses.
+type fakeS3 struct {
+	mutex   sync.Mutex
+	objects map[string]*fakeS3Object
+	// beforePut is called with the key of each object put, before the conditions of the request are checked
+	beforePut func(key string)
+	// beforeList is called with the bucket and prefix of each listing
+	beforeList func(bucket string, prefix string)
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+
+	if key == "" && r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2" {
+		if f.beforeList != nil {
+			f.beforeList(bucket, r.URL.Query().Get("prefix"))
+		}
+
+		f.list(w, bucket, r.URL.Query().Get("prefix"))
+		return
+	}
+
+	id := fmt.Sprintf("%s/%s", bucket, key)
+
+	switch r.Method {
+	case http.MethodPut:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if f.beforePut != nil {
+			f.beforePut(key)
+		}
+
+		existing, exists := f.objects[id]
+		if (r.Header.Get("If-None-Match") == "*" && exists) ||
+			(r.Header.Get("If-Match") != "" && (!exists || existing.etag != r.Header.Get("If-Match"))) {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusPreconditionFailed)
+			fmt.Fprint(w, `<Error><Code>PreconditionFailed</Code><Message>At least one of the pre-conditions you specified did not hold</Message></Error>`)
+			return
+		}
+
+		obj := newFakeS3Object(body)
+		obj.headers = r.Header.Clone()
+		for k, v := range r.Header {
+			if strings.HasPrefix(strings.ToLower(k), "x-amz-meta-") {
+				obj.metadata[strings.ToLower(strings.TrimPrefix(strings.ToLower(k), "x-amz-meta-"))] = v[0]
+			}
+		}
+		f.objects[id] = obj
+
+		w.WriteHeader(http.StatusOK)
+	case http.MethodHead, http.MethodGet:
+		obj, ok := f.objects[id]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == http.MethodGet {
+				fmt.Fprintf(w, `<Error><Code>NoSuchKey</Code><Message>not found</Message><Key>%s</Key></Error>`, key)
+			}
+			return
+		}
+
+		for k, v := range obj.metadata {
+			w.Header().Set("x-amz-meta-"+k, v)
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(obj.body)))
+		w.Header().Set("ETag", obj.etag)
+		w.Header().Set("Last-Modified", obj.lastModified.UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusOK)
+
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(obj.body)
+		}
+	case http.MethodDelete:
+		delete(f.objects, id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, bucket string, prefix string) {
+	type content struct {
+		Key          string    `xml:"Key"`
+		Size         int       `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	}
+
+	result := struct {
+		XMLName     xml.Name  `xml:"ListBucketResult"`
+		Name        string    `xml:"Name"`
+		Prefix      string    `xml:"Prefix"`
+		KeyCount    int       `xml:"KeyCount"`
+		IsTruncated bool      `xml:"IsTruncated"`
+		Contents    []content `xml:"Contents"`
+	}{Name: bucket, Prefix: prefix}
+
+	var keys []string
+	for id := range f.objects {
+		if key, ok := strings.CutPrefix(id, bucket+"/"); ok && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		obj := f.objects[fmt.Sprintf("%s/%s", bucket, key)]
+		result.Contents = append(result.Contents, content{Key: key, Size: len(obj.body), LastModified: obj.lastModified.UTC()})
+	}
+	result.KeyCount = len(result.Contents)
+
+	w.Header().Set("Content-Type", "application/xml")
+	_ = xml.NewEncoder(w).Encode(result)
+}
+
+// get returns the body of an object, or nil.
+func (f *fakeS3) get(bucket string, key string) []byte {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if obj, ok := f.objects[fmt.Sprintf("%s/%s", bucket, key)]; ok {
+		return obj.body
+	}
+
+	return nil
+}
+
+// put stores an object directly.
+func (f *fakeS3) put(bucket string, key string, body []byte) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.objects[fmt.Sprintf("%s/%s", bucket, key)] = newFakeS3Object(body)
+}
+
+// age moves the last modification time of an object back by d.
+func (f *fakeS3) age(bucket string, key string, d time.Duration) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.objects[fmt.Sprintf("%s/%s", bucket, key)].lastModified = time.Now().Add(-d)
+}
+
+// setupFakeS3 starts a fake S3 server and registers it as the s3:fake:<bucket> registry.
+func setupFakeS3(t *testing.T, bucket string) *fakeS3 {
+	f := &fakeS3{objects: make(map[string]*fakeS3Object)}
+
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+
+	viper.Set("sync.registries", []map[string]interface{}{
+		{
+			"name": "fake",
+			"url":  fmt.Sprintf("s3:fake:%s", bucket),
+			"auth": map[string]interface{}{
+				"username": "access-key",
+				"password": "secret-key",
+			},
+			"s3": map[string]interface{}{
+				"endpoint":  server.URL,
+				"pathStyle": true,
+				"region":    "us-east-1",
+			},
+		},
+	})
+	if reloaded := config.SyncRegistries.Update(); reloaded != nil && reloaded.Error != nil {
+		t.Fatalf("Failed to update SyncRegistries config: %v", reloaded.Error)
+	}
+
+	return f
+}
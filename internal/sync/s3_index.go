@@ -0,0 +1,327 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Altinity/docker-sync/config"
+	"github.com/Altinity/docker-sync/structs"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	awstypes "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/cenkalti/backoff/v4"
+	"github.com/opencontainers/go-digest"
+	"github.com/rs/zerolog/log"
+)
+
+const catalogKey = "v2/_catalog"
+
+var (
+	// catalogLocks serializes the read-modify-write of the catalog of a bucket within the process, conditional
+	// writes protect it from other instances.
+	catalogLocks      = make(map[string]*sync.Mutex)
+	catalogLocksMutex sync.Mutex
+)
+
+// tagsList is the response of GET /v2/<name>/tags/list.
+type tagsList struct {
+	Name string   `json:"name"`
+	Tags []string `json:"tags"`
+}
+
+// catalog is the response of GET /v2/_catalog.
+type catalog struct {
+	Repositories []string `json:"repositories"`
+}
+
+func getBucketSession(dst string) (*s3.Client, *string, error) {
+	switch {
+	case strings.HasPrefix(dst, "r2:"):
+		return getR2Session(dst)
+	case strings.HasPrefix(dst, "s3:"):
+		return getS3Session(dst)
+	default:
+		return nil, nil, fmt.Errorf("unsupported bucket destination: %s", dst)
+	}
+}
+
+func lockCatalog(dst string) func() {
+	fields := strings.Split(dst, ":")
+	key := strings.Join(fields[:3], ":")
+
+	catalogLocksMutex.Lock()
+	mutex, ok := catalogLocks[key]
+	if !ok {
+		mutex = &sync.Mutex{}
+		catalogLocks[key] = mutex
+	}
+	catalogLocksMutex.Unlock()
+
+	mutex.Lock()
+
+	return mutex.Unlock
+}
+
+// updateS3Indexes regenerates the index objects of the bucket targets of an image.
+func updateS3Indexes(ctx context.Context, image *structs.Image) {
+	if !config.SyncS3RegistryIndex.Bool() || ctx.Err() != nil {
+		return
+	}
+
+	for _, dst := range image.Targets {
+		if getRepositoryType(dst) != S3CompatibleRepository {
+			continue
+		}
+
+		if err := updateS3Index(ctx, dst); err != nil {
+			log.Error().
+				Err(err).
+				Str("image", image.Source).
+				Str("target", dst).
+				Msg("Failed to update registry index objects")
+		}
+	}
+}
+
+// updateS3Index regenerates the tags/list object of a bucket target and its entry in the bucket catalog, so
+// that clients can list the tags and repositories of the bucket.
+func updateS3Index(ctx context.Context, dst string) error {
+	fields := strings.Split(dst, ":")
+	repository := fields[3]
+
+	s3Session, bucket, err := getBucketSession(dst)
+	if err != nil {
+		return err
+	}
+
+	s3c := &s3Client{
+		uploader:      manager.NewUploader(s3Session),
+		s3Session:     s3Session,
+		dst:           dst,
+		bucket:        bucket,
+		baseDir:       path.Join("v2", repository),
+		objectOptions: getObjectOptions(dst),
+	}
+
+	dstTags, err := listS3Tags(ctx, dst, fields)
+	if err != nil {
+		return err
+	}
+
+	tags := []string{}
+	for _, tag := range dstTags {
+		tags = append(tags, strings.TrimPrefix(tag, fmt.Sprintf("%s:", dst)))
+	}
+	slices.Sort(tags)
+
+	key := path.Join(s3c.baseDir, "tags", "list")
+
+	if len(tags) == 0 {
+		// Unknown repositories have no tag list
+		if err := deleteObject(ctx, s3c, key); err != nil {
+			return err
+		}
+	} else {
+		b, err := json.Marshal(tagsList{Name: repository, Tags: tags})
+		if err != nil {
+			return err
+		}
+
+		if err := putIndexObject(ctx, s3c, key, b); err != nil {
+			return err
+		}
+	}
+
+	return updateS3Catalog(ctx, s3c, repository, len(tags) > 0)
+}
+
+// updateS3Catalog adds or removes a repository from the catalog of a bucket. A missing catalog is regenerated
+// from the manifests stored in the bucket. The catalog is written only if it didn't change since it was read, as
+// other instances may update it at the same time, and read again otherwise.
+func updateS3Catalog(ctx context.Context, s3c *s3Client, repository string, present bool) error {
+	unlock := lockCatalog(s3c.dst)
+	defer unlock()
+
+	return backoff.Retry(func() error {
+		err := tryUpdateS3Catalog(ctx, s3c, repository, present)
+		if err != nil && !isConditionalWriteConflict(err) {
+			return backoff.Permanent(err)
+		}
+
+		if err != nil {
+			log.Debug().
+				Str("bucket", *s3c.bucket).
+				Str("repository", repository).
+				Msg("Catalog changed while it was updated, retrying")
+		}
+
+		return err
+	}, backoff.WithContext(backoff.WithMaxRetries(backoff.NewExponentialBackOff(
+		backoff.WithInitialInterval(100*time.Millisecond),
+	), catalogUpdateRetries), ctx))
+}
+
+// catalogUpdateRetries is the number of times the catalog is read again when another instance updated it first.
+const catalogUpdateRetries = 10
+
+func tryUpdateS3Catalog(ctx context.Context, s3c *s3Client, repository string, present bool) error {
+	var c catalog
+	// etag is the ETag of the catalog read, nil if there was none
+	var etag *string
+
+	resp, err := s3c.s3Session.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: s3c.bucket,
+		Key:    aws.String(catalogKey),
+	})
+	if err != nil {
+		var nsk *awstypes.NoSuchKey
+		if !errors.As(err, &nsk) {
+			return fmt.Errorf("failed to get catalog: %w", err)
+		}
+
+		log.Info().
+			Str("bucket", *s3c.bucket).
+			Msg("Catalog not found, regenerating it")
+
+		c.Repositories, err = listS3Repositories(ctx, s3c.s3Session, s3c.bucket)
+		if err != nil {
+			return err
+		}
+	} else {
+		defer resp.Body.Close()
+
+		etag = resp.ETag
+
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return fmt.Errorf("failed to get catalog: %w", err)
+		}
+
+		if err := json.Unmarshal(b, &c); err != nil {
+			return fmt.Errorf("failed to parse catalog: %w", err)
+		}
+
+		// The catalog already lists the repository as needed
+		if slices.Contains(c.Repositories, repository) == present {
+			return nil
+		}
+	}
+
+	c.Repositories = slices.DeleteFunc(c.Repositories, func(r string) bool {
+		return r == repository
+	})
+	if present {
+		c.Repositories = append(c.Repositories, repository)
+	}
+
+	if c.Repositories == nil {
+		c.Repositories = []string{}
+	}
+	slices.Sort(c.Repositories)
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	input := &s3.PutObjectInput{
+		Bucket:      s3c.bucket,
+		Key:         aws.String(catalogKey),
+		Body:        bytes.NewReader(b),
+		ContentType: aws.String("application/json"),
+		Metadata: map[string]string{
+			"x-calculated-digest": digest.FromBytes(b).String(),
+		},
+	}
+	s3c.objectOptions.apply(input)
+
+	if etag != nil {
+		input.IfMatch = etag
+	} else {
+		input.IfNoneMatch = aws.String("*")
+	}
+
+	_, err = s3c.s3Session.PutObject(ctx, input)
+
+	// Endpoints without conditional writes may reject them instead of ignoring them
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NotImplemented" {
+		input.IfMatch, input.IfNoneMatch = nil, nil
+		input.Body = bytes.NewReader(b)
+
+		_, err = s3c.s3Session.PutObject(ctx, input)
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to put catalog: %w", err)
+	}
+
+	return nil
+}
+
+// isConditionalWriteConflict reports whether a conditional write failed because the object changed.
+func isConditionalWriteConflict(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+
+	return apiErr.ErrorCode() == "PreconditionFailed" || apiErr.ErrorCode() == "ConditionalRequestConflict"
+}
+
+// listS3Repositories returns the repositories of a bucket, which are the prefixes holding manifests.
+func listS3Repositories(ctx context.Context, s3Session *s3.Client, bucket *string) ([]string, error) {
+	p := s3.NewListObjectsV2Paginator(s3Session, &s3.ListObjectsV2Input{
+		Bucket: bucket,
+		Prefix: aws.String("v2/"),
+	})
+
+	repositories := []string{}
+
+	var i int
+	for p.HasMorePages() {
+		i++
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get page %d, %w", i, err)
+		}
+
+		for _, obj := range page.Contents {
+			key := strings.TrimPrefix(*obj.Key, "v2/")
+
+			if repository, _, ok := strings.Cut(key, "/manifests/"); ok {
+				repositories = append(repositories, repository)
+			}
+		}
+	}
+
+	slices.Sort(repositories)
+
+	return slices.Compact(repositories), nil
+}
+
+// putIndexObject uploads an index object unless it is unchanged. Index objects are always compared with the
+// bucket, as the object cache would hide changes.
+func putIndexObject(ctx context.Context, s3c *s3Client, key string, b []byte) error {
+	exists, headDigest, err := s3ObjectExists(ctx, s3c.s3Session, s3c.bucket, key)
+	if err != nil {
+		return err
+	}
+
+	if exists && headDigest == digest.FromBytes(b).String() {
+		return nil
+	}
+
+	return syncObject(ctx, s3c, key, aws.String("application/json"), bytes.NewReader(b), true)
+}
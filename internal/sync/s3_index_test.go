@@ -0,0 +1,62 @@
+package sync
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateS3Index(t *testing.T) {
+	f := setupFakeS3(t, "index-bucket")
+
+	f.put("index-bucket", "v2/altinity/image/manifests/1.0", []byte("{}"))
+	f.put("index-bucket", "v2/altinity/image/manifests/latest", []byte("{}"))
+	f.put("index-bucket", "v2/altinity/image/manifests/sha256:1111111111111111111111111111111111111111111111111111111111111111", []byte("{}"))
+	f.put("index-bucket", "v2/altinity/other/manifests/2.0", []byte("{}"))
+
+	assert.NoError(t, updateS3Index(t.Context(), "s3:fake:index-bucket:altinity/image"))
+
+	assert.JSONEq(t, `{"name":"altinity/image","tags":["1.0","latest"]}`, string(f.get("index-bucket", "v2/altinity/image/tags/list")))
+	// The missing catalog is regenerated from the whole bucket
+	assert.JSONEq(t, `{"repositories":["altinity/image","altinity/other"]}`, string(f.get("index-bucket", "v2/_catalog")))
+
+	// A new repository is added to the catalog
+	f.put("index-bucket", "v2/altinity/new/manifests/3.0", []byte("{}"))
+	assert.NoError(t, updateS3Index(t.Context(), "s3:fake:index-bucket:altinity/new"))
+	assert.JSONEq(t, `{"repositories":["altinity/image","altinity/new","altinity/other"]}`, string(f.get("index-bucket", "v2/_catalog")))
+
+	// A repository without tags is removed from the catalog
+	for _, tag := range []string{"1.0", "latest"} {
+		f.mutex.Lock()
+		delete(f.objects, "index-bucket/v2/altinity/image/manifests/"+tag)
+		f.mutex.Unlock()
+	}
+
+	assert.NoError(t, updateS3Index(t.Context(), "s3:fake:index-bucket:altinity/image"))
+	assert.Nil(t, f.get("index-bucket", "v2/altinity/image/tags/list"))
+	assert.JSONEq(t, `{"repositories":["altinity/new","altinity/other"]}`, string(f.get("index-bucket", "v2/_catalog")))
+}
+
+func TestUpdateS3CatalogConflict(t *testing.T) {
+	f := setupFakeS3(t, "catalog-bucket")
+
+	f.put("catalog-bucket", "v2/_catalog", []byte(`{"repositories":["altinity/image"]}`))
+	f.put("catalog-bucket", "v2/altinity/new/manifests/1.0", []byte("{}"))
+
+	// Another instance adds its repository between the read and the write of the first attempt
+	puts := 0
+	f.beforePut = func(key string) {
+		if key != "v2/_catalog" {
+			return
+		}
+
+		puts++
+		if puts == 1 {
+			f.objects["catalog-bucket/v2/_catalog"] = newFakeS3Object([]byte(`{"repositories":["altinity/image","altinity/other"]}`))
+		}
+	}
+
+	assert.NoError(t, updateS3Index(t.Context(), "s3:fake:catalog-bucket:altinity/new"))
+	assert.Equal(t, 2, puts)
+	assert.JSONEq(t, `{"repositories":["altinity/image","altinity/new","altinity/other"]}`, string(f.get("catalog-bucket", "v2/_catalog")))
+}
@@ -0,0 +1,228 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Altinity/docker-sync/config"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	awstypes "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/cenkalti/backoff/v4"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// errGCInProgress is returned when a push can't start because a garbage collection holds the repository.
+var errGCInProgress = errors.New("garbage collection in progress")
+
+// gcLockKey returns the key of the lock object a garbage collection of a repository holds.
+func gcLockKey(repository string) string {
+	return path.Join("v2", repository, "_locks", "gc")
+}
+
+// pushLocksPrefix returns the prefix of the lock objects held by pushes to a repository.
+func pushLocksPrefix(repository string) string {
+	return path.Join("v2", repository, "_locks", "push")
+}
+
+// isLockActive reports whether a lock object was refreshed recently enough to be held.
+func isLockActive(lastModified time.Time) bool {
+	return time.Since(lastModified) < config.SyncS3GCLockTimeout.Duration()
+}
+
+// s3Lock is a lock object in a bucket. It is rewritten while held, as locks that are not refreshed within
+// sync.s3.gc.lockTimeout are considered abandoned by a crashed instance.
+type s3Lock struct {
+	s3Session *s3.Client
+	bucket    string
+	key       string
+	stop      context.CancelFunc
+	done      chan struct{}
+}
+
+func putLock(ctx context.Context, s3Session *s3.Client, bucket string, key string) error {
+	hostname, _ := os.Hostname()
+
+	_, err := s3Session.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String("text/plain"),
+		Body:        strings.NewReader(fmt.Sprintf("%s/%d", hostname, os.Getpid())),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put lock %s in bucket %s: %w", key, bucket, err)
+	}
+
+	return nil
+}
+
+// newS3Lock writes a lock object and keeps it fresh until it is released.
+func newS3Lock(ctx context.Context, s3Session *s3.Client, bucket string, key string) (*s3Lock, error) {
+	if err := putLock(ctx, s3Session, bucket, key); err != nil {
+		return nil, err
+	}
+
+	refreshCtx, stop := context.WithCancel(ctx)
+
+	l := &s3Lock{
+		s3Session: s3Session,
+		bucket:    bucket,
+		key:       key,
+		stop:      stop,
+		done:      make(chan struct{}),
+	}
+
+	go func() {
+		defer close(l.done)
+
+		ticker := time.NewTicker(max(time.Second, config.SyncS3GCLockTimeout.Duration()/3))
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-refreshCtx.Done():
+				return
+			case <-ticker.C:
+				if err := putLock(refreshCtx, s3Session, bucket, key); err != nil && refreshCtx.Err() == nil {
+					log.Warn().
+						Err(err).
+						Str("bucket", bucket).
+						Str("key", key).
+						Msg("Failed to refresh lock")
+				}
+			}
+		}
+	}()
+
+	return l, nil
+}
+
+// release deletes the lock object, even if the context of its holder was canceled.
+func (l *s3Lock) release(ctx context.Context) {
+	l.stop()
+	<-l.done
+
+	if _, err := l.s3Session.DeleteObject(context.WithoutCancel(ctx), &s3.DeleteObjectInput{
+		Bucket: aws.String(l.bucket),
+		Key:    aws.String(l.key),
+	}); err != nil {
+		log.Warn().
+			Err(err).
+			Str("bucket", l.bucket).
+			Str("key", l.key).
+			Msg("Failed to release lock")
+	}
+}
+
+// gcLockActive reports whether a garbage collection holds a repository, or the shared blob store of the bucket.
+func gcLockActive(ctx context.Context, s3Session *s3.Client, bucket string, repository string) (bool, error) {
+	for _, key := range []string{gcLockKey(repository), gcLockKey(sharedBlobsRepository)} {
+		resp, err := s3Session.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			var nf *awstypes.NotFound
+			if errors.As(err, &nf) {
+				continue
+			}
+
+			return false, fmt.Errorf("failed to get lock %s from bucket %s: %w", key, bucket, err)
+		}
+
+		if isLockActive(aws.ToTime(resp.LastModified)) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// acquirePushLock holds a repository for a push, so garbage collection doesn't delete the objects it uploads or
+// reuses before its tag manifest is written. It waits for a running garbage collection to finish. The returned
+// function releases the lock.
+func acquirePushLock(ctx context.Context, s3Session *s3.Client, bucket string, repository string) (func(), error) {
+	if !config.SyncS3GCLock.Bool() {
+		return func() {}, nil
+	}
+
+	key := path.Join(pushLocksPrefix(repository), uuid.NewString())
+
+	var lock *s3Lock
+
+	if err := backoff.RetryNotify(func() error {
+		l, err := newS3Lock(ctx, s3Session, bucket, key)
+		if err != nil {
+			return backoff.Permanent(err)
+		}
+
+		// The push lock is written before checking for a collection, so that a collection starting
+		// concurrently sees it and backs off
+		active, err := gcLockActive(ctx, s3Session, bucket, repository)
+		if err != nil {
+			l.release(ctx)
+			return backoff.Permanent(err)
+		}
+
+		if active {
+			l.release(ctx)
+			return errGCInProgress
+		}
+
+		lock = l
+
+		return nil
+	}, backoff.WithContext(backoff.NewExponentialBackOff(
+		backoff.WithInitialInterval(5*time.Second),
+		backoff.WithMaxElapsedTime(config.SyncS3GCLockTimeout.Duration()),
+	), ctx), func(err error, dur time.Duration) {
+		log.Info().
+			Err(err).
+			Dur("backoff", dur).
+			Str("bucket", bucket).
+			Str("repository", repository).
+			Msg("Waiting for garbage collection to finish")
+	}); err != nil {
+		return nil, err
+	}
+
+	return func() {
+		lock.release(ctx)
+	}, nil
+}
+
+// acquireGCLock holds a repository for a garbage collection. It reports false if pushes to the repository are
+// in progress, in which case the collection must be skipped. The returned function releases the lock.
+func acquireGCLock(ctx context.Context, s3Session *s3.Client, bucket string, repository string) (func(), bool, error) {
+	if !config.SyncS3GCLock.Bool() {
+		return func() {}, true, nil
+	}
+
+	lock, err := newS3Lock(ctx, s3Session, bucket, gcLockKey(repository))
+	if err != nil {
+		return nil, false, err
+	}
+
+	pushes, err := listS3Objects(ctx, s3Session, bucket, pushLocksPrefix(repository))
+	if err != nil {
+		lock.release(ctx)
+		return nil, false, fmt.Errorf("failed to list push locks: %w", err)
+	}
+
+	for _, obj := range pushes {
+		if isLockActive(obj.lastModified) {
+			lock.release(ctx)
+			return nil, false, nil
+		}
+	}
+
+	return func() {
+		lock.release(ctx)
+	}, true, nil
+}
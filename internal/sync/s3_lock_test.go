@@ -0,0 +1,74 @@
+package sync
+
+import (
+	"context"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/Altinity/docker-sync/config"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGCLock(t *testing.T) {
+	viper.Set("sync.s3.gc.lock", true)
+	viper.Set("sync.s3.gc.lockTimeout", "1m")
+	config.SyncS3GCLock.Update()
+	config.SyncS3GCLockTimeout.Update()
+	defer func() {
+		viper.Set("sync.s3.gc.lock", false)
+		viper.Set("sync.s3.gc.lockTimeout", "10m")
+		config.SyncS3GCLock.Update()
+		config.SyncS3GCLockTimeout.Update()
+	}()
+
+	f := setupFakeS3(t, "lock-bucket")
+
+	s3Session, bucket, err := getS3Session("s3:fake:lock-bucket:altinity/image")
+	assert.NoError(t, err)
+
+	pushLocks := func() map[string]s3Object {
+		objects, err := listS3Objects(t.Context(), s3Session, *bucket, pushLocksPrefix("altinity/image"))
+		assert.NoError(t, err)
+		return objects
+	}
+
+	// A push in progress makes garbage collection skip the repository
+	releasePush, err := acquirePushLock(t.Context(), s3Session, *bucket, "altinity/image")
+	assert.NoError(t, err)
+	assert.Len(t, pushLocks(), 1)
+
+	_, ok, err := acquireGCLock(t.Context(), s3Session, *bucket, "altinity/image")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.Nil(t, f.get("lock-bucket", gcLockKey("altinity/image")))
+
+	releasePush()
+	assert.Empty(t, pushLocks())
+
+	// Abandoned push locks are ignored
+	abandoned := path.Join(pushLocksPrefix("altinity/image"), "abandoned")
+	f.put("lock-bucket", abandoned, []byte("host/1"))
+	f.age("lock-bucket", abandoned, 2*time.Minute)
+
+	releaseGC, ok, err := acquireGCLock(t.Context(), s3Session, *bucket, "altinity/image")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.NotNil(t, f.get("lock-bucket", gcLockKey("altinity/image")))
+
+	// Pushes wait for the collection to finish
+	ctx, cancel := context.WithTimeout(t.Context(), 100*time.Millisecond)
+	defer cancel()
+
+	_, err = acquirePushLock(ctx, s3Session, *bucket, "altinity/image")
+	assert.Error(t, err)
+	assert.Len(t, pushLocks(), 1)
+
+	releaseGC()
+	assert.Nil(t, f.get("lock-bucket", gcLockKey("altinity/image")))
+
+	releasePush, err = acquirePushLock(t.Context(), s3Session, *bucket, "altinity/image")
+	assert.NoError(t, err)
+	releasePush()
+}
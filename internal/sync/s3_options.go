@@ -0,0 +1,92 @@
+package sync
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/Altinity/docker-sync/structs"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	awstypes "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// defaultObjectACL keeps buckets readable by anonymous clients, as expected by the registry layout.
+const defaultObjectACL = "public-read"
+
+// getObjectOptions returns the object settings of a bucket target, from the S3 options of its registry.
+func getObjectOptions(dst string) objectOptions {
+	opts := objectOptions{acl: aws.String(defaultObjectACL)}
+
+	fields := strings.Split(dst, ":")
+	if len(fields) < 3 {
+		return opts
+	}
+
+	repo := getRepository(strings.Join(fields[:3], ":"))
+	if repo == nil || repo.S3 == nil {
+		return opts
+	}
+
+	return newObjectOptions(repo.S3)
+}
+
+func newObjectOptions(s3Opts *structs.RepositoryS3) objectOptions {
+	opts := objectOptions{
+		acl:                  aws.String(defaultObjectACL),
+		storageClass:         s3Opts.StorageClass,
+		serverSideEncryption: s3Opts.ServerSideEncryption,
+		metadata:             s3Opts.Metadata,
+	}
+
+	switch s3Opts.ACL {
+	case "":
+	case "none":
+		opts.acl = nil
+	default:
+		opts.acl = aws.String(s3Opts.ACL)
+	}
+
+	if s3Opts.KMSKeyID != "" {
+		opts.kmsKeyID = aws.String(s3Opts.KMSKeyID)
+	}
+
+	if len(s3Opts.Tags) > 0 {
+		tags := url.Values{}
+		for k, v := range s3Opts.Tags {
+			tags.Set(k, v)
+		}
+		opts.tagging = aws.String(tags.Encode())
+	}
+
+	return opts
+}
+
+// apply sets the object settings on an upload. The digest metadata is always kept, as it is used to skip
+// unchanged objects.
+func (o objectOptions) apply(input *s3.PutObjectInput) {
+	if o.acl != nil {
+		input.ACL = awstypes.ObjectCannedACL(*o.acl)
+	}
+
+	if o.storageClass != "" {
+		input.StorageClass = awstypes.StorageClass(o.storageClass)
+	}
+
+	if o.serverSideEncryption != "" {
+		input.ServerSideEncryption = awstypes.ServerSideEncryption(o.serverSideEncryption)
+		input.SSEKMSKeyId = o.kmsKeyID
+	}
+
+	if len(o.metadata) > 0 {
+		metadata := make(map[string]string, len(o.metadata)+len(input.Metadata))
+		for k, v := range o.metadata {
+			metadata[k] = v
+		}
+		for k, v := range input.Metadata {
+			metadata[k] = v
+		}
+		input.Metadata = metadata
+	}
+
+	input.Tagging = o.tagging
+}
@@ -0,0 +1,66 @@
+package sync
+
+import (
+	"testing"
+
+	"github.com/Altinity/docker-sync/structs"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	awstypes "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObjectOptions(t *testing.T) {
+	newInput := func() *s3.PutObjectInput {
+		return &s3.PutObjectInput{
+			Metadata: map[string]string{
+				"x-calculated-digest": "sha256:1111111111111111111111111111111111111111111111111111111111111111",
+			},
+		}
+	}
+
+	t.Run("default", func(t *testing.T) {
+		input := newInput()
+		newObjectOptions(&structs.RepositoryS3{}).apply(input)
+
+		assert.Equal(t, awstypes.ObjectCannedACLPublicRead, input.ACL)
+		assert.Empty(t, input.StorageClass)
+		assert.Empty(t, input.ServerSideEncryption)
+		assert.Nil(t, input.Tagging)
+	})
+
+	t.Run("no acl", func(t *testing.T) {
+		input := newInput()
+		newObjectOptions(&structs.RepositoryS3{ACL: "none"}).apply(input)
+
+		assert.Empty(t, input.ACL)
+	})
+
+	t.Run("all options", func(t *testing.T) {
+		input := newInput()
+		newObjectOptions(&structs.RepositoryS3{
+			ACL:                  "private",
+			StorageClass:         "STANDARD_IA",
+			ServerSideEncryption: "aws:kms",
+			KMSKeyID:             "alias/docker-sync",
+			Metadata: map[string]string{
+				"team":                "platform",
+				"x-calculated-digest": "overridden",
+			},
+			Tags: map[string]string{
+				"env":   "prod",
+				"owner": "platform team",
+			},
+		}).apply(input)
+
+		assert.Equal(t, awstypes.ObjectCannedACLPrivate, input.ACL)
+		assert.Equal(t, awstypes.StorageClassStandardIa, input.StorageClass)
+		assert.Equal(t, awstypes.ServerSideEncryptionAwsKms, input.ServerSideEncryption)
+		assert.Equal(t, aws.String("alias/docker-sync"), input.SSEKMSKeyId)
+		assert.Equal(t, map[string]string{
+			"team":                "platform",
+			"x-calculated-digest": "sha256:1111111111111111111111111111111111111111111111111111111111111111",
+		}, input.Metadata)
+		assert.Equal(t, aws.String("env=prod&owner=platform+team"), input.Tagging)
+	})
+}
@@ -4,16 +4,19 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"path"
 	"path/filepath"
-	"regexp"
 	"slices"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/Altinity/docker-sync/config"
 	"github.com/Altinity/docker-sync/structs"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/docker/go-units"
+	"github.com/opencontainers/go-digest"
 	"github.com/rs/zerolog/log"
 	"golang.org/x/sync/errgroup"
 )
@@ -64,17 +67,26 @@ func deleteObject(ctx context.Context, s3c *s3Client, key string) error {
 	return nil
 }
 
-func getAllRepositoryBlobsS3(ctx context.Context, s3Session *s3.Client, bucket string, repository string) ([]string, error) {
-	log.Info().
-		Str("bucket", bucket).
-		Str("repository", repository).
-		Msg("Getting all blobs in repository")
+// gcSummary counts what a garbage collection of a repository removed.
+type gcSummary struct {
+	manifests int
+	blobs     int
+	bytes     int64
+}
+
+// s3Object is an object listed by listS3Objects.
+type s3Object struct {
+	size         int64
+	lastModified time.Time
+}
 
-	blobs := []string{}
+// listS3Objects returns the objects under a prefix, keyed by file name.
+func listS3Objects(ctx context.Context, s3Session *s3.Client, bucket string, prefix string) (map[string]s3Object, error) {
+	objects := make(map[string]s3Object)
 
 	p := s3.NewListObjectsV2Paginator(s3Session, &s3.ListObjectsV2Input{
 		Bucket: aws.String(bucket),
-		Prefix: aws.String(filepath.Join("v2", repository, "blobs")),
+		Prefix: aws.String(prefix + "/"),
 	})
 
 	var i int
@@ -85,154 +97,209 @@ func getAllRepositoryBlobsS3(ctx context.Context, s3Session *s3.Client, bucket s
 			return nil, fmt.Errorf("failed to get page %d, %w", i, err)
 		}
 		for _, obj := range page.Contents {
-			fname := filepath.Base(*obj.Key)
-			if strings.HasPrefix(fname, "sha256:") {
-				blobs = append(blobs, fname)
+			objects[path.Base(*obj.Key)] = s3Object{
+				size:         aws.ToInt64(obj.Size),
+				lastModified: aws.ToTime(obj.LastModified),
 			}
 		}
 	}
 
-	slices.Sort(blobs)
-
-	return slices.Compact(blobs), nil
+	return objects, nil
 }
 
-func getAllReferencedBlobsS3(ctx context.Context, s3Session *s3.Client, bucket string, repository string) ([]string, error) {
-	log.Info().
-		Str("bucket", bucket).
-		Str("repository", repository).
-		Msg("Getting all referenced blobs")
-
-	blobs := []string{}
-
-	p := s3.NewListObjectsV2Paginator(s3Session, &s3.ListObjectsV2Input{
-		Bucket: aws.String(bucket),
-		Prefix: aws.String(filepath.Join("v2", repository, "manifests")),
-	})
-
-	var blobsMutex sync.Mutex
+// isRecent reports whether an object is younger than sync.s3.gc.minAge, and may belong to a push in progress.
+func isRecent(obj s3Object) bool {
+	return time.Since(obj.lastModified) < config.SyncS3GCMinAge.Duration()
+}
 
-	var i int
-	for p.HasMorePages() {
-		i++
-		page, err := p.NextPage(ctx)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get page %d, %w", i, err)
+// markS3Repository walks from the tag manifests of a repository down to their child manifests and blobs, and
+// returns the manifests and blobs that are reachable.
+func markS3Repository(ctx context.Context, s3Session *s3.Client, bucket string, repository string, manifests map[string]s3Object) (map[digest.Digest]struct{}, map[digest.Digest]struct{}, error) {
+	reachableManifests := make(map[digest.Digest]struct{})
+	reachableBlobs := make(map[digest.Digest]struct{})
+	var mutex sync.Mutex
+
+	// Tag manifests are the roots, along with recent manifests whose tag may not be uploaded yet
+	var frontier []string
+	for name, obj := range manifests {
+		if !strings.HasPrefix(name, "sha256:") || isRecent(obj) {
+			frontier = append(frontier, name)
 		}
+	}
 
-		log.Debug().
-			Str("bucket", bucket).
-			Int("page", i).
-			Int("objects", len(page.Contents)).
-			Msg("Processing page of objects")
+	for len(frontier) > 0 {
+		var next []string
 
-		g, _ := errgroup.WithContext(ctx)
-		g.SetLimit(config.SyncS3MaxPurgeConcurrency.Int())
+		g, gctx := errgroup.WithContext(ctx)
+		g.SetLimit(max(1, config.SyncS3MaxPurgeConcurrency.Int()))
 
-		for _, obj := range page.Contents {
+		for _, name := range frontier {
 			g.Go(func() error {
-				log.Debug().
-					Str("bucket", bucket).
-					Str("key", *obj.Key).
-					Msg("Processing object")
+				key := path.Join("v2", repository, "manifests", name)
 
-				// We need to read the manifest to find out which blobs it references
-				resp, err := s3Session.GetObject(ctx, &s3.GetObjectInput{
+				resp, err := s3Session.GetObject(gctx, &s3.GetObjectInput{
 					Bucket: aws.String(bucket),
-					Key:    obj.Key,
+					Key:    aws.String(key),
 				})
 				if err != nil {
-					log.Error().
-						Err(err).
-						Str("bucket", bucket).
-						Str("key", *obj.Key).
-						Msg("Failed to get object")
-
-					return fmt.Errorf("failed to get object %s from bucket %s: %w", *obj.Key, bucket, err)
+					return fmt.Errorf("failed to get object %s from bucket %s: %w", key, bucket, err)
 				}
 				defer resp.Body.Close()
 
-				// To avoid having to parse the manifest, we can just read the body and look for "sha256:<64 chars>" patterns using regex
 				buf := new(bytes.Buffer)
 				if _, err := buf.ReadFrom(resp.Body); err != nil {
-					// Don't proceed because we can miss blobs
+					// Don't proceed because we can miss references
 					return fmt.Errorf("failed to read object body: %w", err)
 				}
 
-				body := buf.String()
-				// Find all sha256 hashes in the body
-				re := regexp.MustCompile(`sha256:[a-f0-9]{64}`)
-				matches := re.FindAllString(body, -1)
+				children, blobs, err := parseManifestReferences(buf.Bytes())
+				if err != nil {
+					return fmt.Errorf("failed to parse manifest %s: %w", key, err)
+				}
 
-				blobsMutex.Lock()
-				blobs = append(blobs, matches...)
-				blobsMutex.Unlock()
+				mutex.Lock()
+				defer mutex.Unlock()
+
+				// Tag manifests are also stored by digest
+				reachableManifests[digest.FromBytes(buf.Bytes())] = struct{}{}
+
+				for _, d := range children {
+					if _, ok := reachableManifests[d]; ok {
+						continue
+					}
+					reachableManifests[d] = struct{}{}
+
+					// Instances that were not selected are listed in preserved indexes but not stored
+					if _, ok := manifests[d.String()]; ok {
+						next = append(next, d.String())
+					}
+				}
+
+				for _, d := range blobs {
+					reachableBlobs[d] = struct{}{}
+				}
 
 				return nil
 			})
 		}
 
 		if err := g.Wait(); err != nil {
-			return nil, fmt.Errorf("failed to process page %d: %w", i, err)
+			return nil, nil, err
 		}
-	}
 
-	slices.Sort(blobs)
+		frontier = next
+	}
 
-	return slices.Compact(blobs), nil
+	return reachableManifests, reachableBlobs, nil
 }
 
-func deleteOrphanedBlobsS3(ctx context.Context, s3Session *s3.Client, bucket string, repository string) error {
-	// Get all blobs in the repository
-	allBlobs, err := getAllRepositoryBlobsS3(ctx, s3Session, bucket, repository)
+// deleteOrphansS3 is a mark-and-sweep garbage collection of a repository: it deletes the manifests and blobs
+// that are not reachable from a tag.
+func deleteOrphansS3(ctx context.Context, s3Session *s3.Client, bucket string, repository string) (*gcSummary, error) {
+	release, ok, err := acquireGCLock(ctx, s3Session, bucket, repository)
 	if err != nil {
-		return fmt.Errorf("failed to get all blobs: %w", err)
+		return nil, err
+	}
+	if !ok {
+		log.Info().
+			Str("bucket", bucket).
+			Str("repository", repository).
+			Msg("Pushes in progress, skipping garbage collection")
+
+		return &gcSummary{}, nil
+	}
+	defer release()
+
+	manifests, err := listS3Objects(ctx, s3Session, bucket, path.Join("v2", repository, "manifests"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list manifests: %w", err)
 	}
-	log.Info().
-		Str("bucket", bucket).
-		Str("repository", repository).
-		Int("blobs", len(allBlobs)).
-		Msg("Retrieved all blobs in repository")
 
-	// Get all referenced blobs in the repository
-	referencedBlobs, err := getAllReferencedBlobsS3(ctx, s3Session, bucket, repository)
+	blobs, err := listS3Objects(ctx, s3Session, bucket, path.Join("v2", repository, "blobs"))
 	if err != nil {
-		return fmt.Errorf("failed to get all referenced blobs: %w", err)
+		return nil, fmt.Errorf("failed to list blobs: %w", err)
 	}
+
 	log.Info().
 		Str("bucket", bucket).
 		Str("repository", repository).
-		Int("referenced_blobs", len(referencedBlobs)).
-		Msg("Retrieved all referenced blobs in repository")
-
-	// Find orphaned blobs
-	var orphanedBlobs []string
-	for _, blob := range allBlobs {
-		if !slices.Contains(referencedBlobs, blob) {
-			orphanedBlobs = append(orphanedBlobs, blob)
+		Int("manifests", len(manifests)).
+		Int("blobs", len(blobs)).
+		Msg("Retrieved all objects in repository")
+
+	reachableManifests, reachableBlobs, err := markS3Repository(ctx, s3Session, bucket, repository, manifests)
+	if err != nil {
+		return nil, fmt.Errorf("failed to mark reachable objects: %w", err)
+	}
+
+	var orphans []string
+	var recent int
+	summary := &gcSummary{}
+
+	for name, obj := range manifests {
+		if !strings.HasPrefix(name, "sha256:") {
+			continue
+		}
+		if _, ok := reachableManifests[digest.Digest(name)]; !ok {
+			if isRecent(obj) {
+				recent++
+				continue
+			}
+
+			orphans = append(orphans, path.Join("v2", repository, "manifests", name))
+			summary.manifests++
+			summary.bytes += obj.size
 		}
 	}
 
-	if len(orphanedBlobs) == 0 {
+	for name, obj := range blobs {
+		if !strings.HasPrefix(name, "sha256:") {
+			continue
+		}
+		if _, ok := reachableBlobs[digest.Digest(name)]; !ok {
+			if isRecent(obj) {
+				recent++
+				continue
+			}
+
+			orphans = append(orphans, path.Join("v2", repository, "blobs", name))
+			summary.blobs++
+			summary.bytes += obj.size
+		}
+	}
+
+	if recent > 0 {
 		log.Info().
 			Str("bucket", bucket).
 			Str("repository", repository).
-			Msg("No orphaned blobs found")
-		return nil
+			Int("objects", recent).
+			Dur("min_age", config.SyncS3GCMinAge.Duration()).
+			Msg("Keeping recent unreachable objects")
 	}
 
+	if len(orphans) == 0 {
+		log.Info().
+			Str("bucket", bucket).
+			Str("repository", repository).
+			Msg("No orphaned objects found")
+
+		return summary, nil
+	}
+
+	slices.Sort(orphans)
+
 	log.Info().
 		Str("bucket", bucket).
 		Str("repository", repository).
-		Int("orphaned_blobs", len(orphanedBlobs)).
-		Msg("Found orphaned blobs")
+		Int("orphaned_manifests", summary.manifests).
+		Int("orphaned_blobs", summary.blobs).
+		Msg("Found orphaned objects")
 
 	g, _ := errgroup.WithContext(ctx)
-	g.SetLimit(config.SyncS3MaxPurgeConcurrency.Int())
+	g.SetLimit(max(1, config.SyncS3MaxPurgeConcurrency.Int()))
 
-	for _, blob := range orphanedBlobs {
+	for _, key := range orphans {
 		g.Go(func() error {
-			key := filepath.Join("v2", repository, "blobs", blob)
 			if err := deleteObject(ctx, &s3Client{
 				s3Session: s3Session,
 				bucket:    aws.String(bucket),
@@ -241,7 +308,7 @@ func deleteOrphanedBlobsS3(ctx context.Context, s3Session *s3.Client, bucket str
 					Err(err).
 					Str("bucket", bucket).
 					Str("key", key).
-					Msg("Failed to delete orphaned blob")
+					Msg("Failed to delete orphaned object")
 
 				return err
 			}
@@ -250,7 +317,18 @@ func deleteOrphanedBlobsS3(ctx context.Context, s3Session *s3.Client, bucket str
 		})
 	}
 
-	_ = g.Wait()
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
 
-	return nil
+	log.Info().
+		Str("bucket", bucket).
+		Str("repository", repository).
+		Int("manifests", summary.manifests).
+		Int("blobs", summary.blobs).
+		Int64("bytes", summary.bytes).
+		Str("reclaimed", units.BytesSize(float64(summary.bytes))).
+		Msg("Garbage collection finished")
+
+	return summary, nil
 }
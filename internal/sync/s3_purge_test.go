@@ -0,0 +1,106 @@
+package sync
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Altinity/docker-sync/config"
+	"github.com/opencontainers/go-digest"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeleteOrphansS3(t *testing.T) {
+	f := setupFakeS3(t, "gc-bucket")
+
+	s3Session, bucket, err := getS3Session("s3:fake:gc-bucket:altinity/image")
+	assert.NoError(t, err)
+
+	blob := func(s string) digest.Digest {
+		d := digest.FromString(s)
+		f.put("gc-bucket", "v2/altinity/image/blobs/"+d.String(), []byte(s))
+		return d
+	}
+	put := func(name string, body string) digest.Digest {
+		f.put("gc-bucket", "v2/altinity/image/manifests/"+name, []byte(body))
+		return digest.FromString(body)
+	}
+
+	cfg := blob("config")
+	layer := blob("layer")
+	orphanedBlob := blob("orphaned")
+
+	image := fmt.Sprintf(`{"schemaVersion":2,"config":{"digest":%q},"layers":[{"digest":%q}]}`, cfg, layer)
+	put(digest.FromString(image).String(), image)
+
+	// The index references an instance that was not copied
+	index := fmt.Sprintf(`{"schemaVersion":2,"manifests":[{"digest":%q},{"digest":"sha256:1111111111111111111111111111111111111111111111111111111111111111"}]}`, digest.FromString(image))
+	put("latest", index)
+	put(digest.FromString(index).String(), index)
+
+	orphaned := fmt.Sprintf(`{"schemaVersion":2,"config":{"digest":%q},"layers":[]}`, orphanedBlob)
+	orphanedManifest := put(digest.FromString(orphaned).String(), orphaned)
+
+	summary, err := deleteOrphansS3(t.Context(), s3Session, *bucket, "altinity/image")
+	assert.NoError(t, err)
+	assert.Equal(t, &gcSummary{manifests: 1, blobs: 1, bytes: int64(len(orphaned) + len("orphaned"))}, summary)
+
+	assert.Nil(t, f.get("gc-bucket", "v2/altinity/image/manifests/"+orphanedManifest.String()))
+	assert.Nil(t, f.get("gc-bucket", "v2/altinity/image/blobs/"+orphanedBlob.String()))
+
+	assert.NotNil(t, f.get("gc-bucket", "v2/altinity/image/manifests/latest"))
+	assert.NotNil(t, f.get("gc-bucket", "v2/altinity/image/manifests/"+digest.FromString(index).String()))
+	assert.NotNil(t, f.get("gc-bucket", "v2/altinity/image/manifests/"+digest.FromString(image).String()))
+	assert.NotNil(t, f.get("gc-bucket", "v2/altinity/image/blobs/"+cfg.String()))
+	assert.NotNil(t, f.get("gc-bucket", "v2/altinity/image/blobs/"+layer.String()))
+
+	// A second run has nothing left to collect
+	summary, err = deleteOrphansS3(t.Context(), s3Session, *bucket, "altinity/image")
+	assert.NoError(t, err)
+	assert.Equal(t, &gcSummary{}, summary)
+
+	// A manifest that can't be parsed aborts the collection, as its references are unknown
+	put("broken", "not json")
+	_, err = deleteOrphansS3(t.Context(), s3Session, *bucket, "altinity/image")
+	assert.Error(t, err)
+}
+
+func TestDeleteOrphansS3MinAge(t *testing.T) {
+	viper.Set("sync.s3.gc.minAge", "1h")
+	config.SyncS3GCMinAge.Update()
+	defer func() {
+		viper.Set("sync.s3.gc.minAge", "0s")
+		config.SyncS3GCMinAge.Update()
+	}()
+
+	f := setupFakeS3(t, "gc-age-bucket")
+
+	s3Session, bucket, err := getS3Session("s3:fake:gc-age-bucket:altinity/image")
+	assert.NoError(t, err)
+
+	blob := func(s string, age time.Duration) digest.Digest {
+		d := digest.FromString(s)
+		key := "v2/altinity/image/blobs/" + d.String()
+		f.put("gc-age-bucket", key, []byte(s))
+		f.age("gc-age-bucket", key, age)
+		return d
+	}
+
+	old := blob("old", 2*time.Hour)
+	fresh := blob("fresh", 0)
+	reused := blob("reused", 2*time.Hour)
+
+	// A push in progress has uploaded a manifest reusing an old blob, but not its tag yet
+	pending := fmt.Sprintf(`{"schemaVersion":2,"config":{"digest":%q},"layers":[{"digest":%q}]}`, fresh, reused)
+	f.put("gc-age-bucket", "v2/altinity/image/manifests/"+digest.FromString(pending).String(), []byte(pending))
+
+	summary, err := deleteOrphansS3(t.Context(), s3Session, *bucket, "altinity/image")
+	assert.NoError(t, err)
+	assert.Equal(t, &gcSummary{blobs: 1, bytes: int64(len("old"))}, summary)
+
+	assert.Nil(t, f.get("gc-age-bucket", "v2/altinity/image/blobs/"+old.String()))
+	assert.NotNil(t, f.get("gc-age-bucket", "v2/altinity/image/blobs/"+fresh.String()))
+	assert.NotNil(t, f.get("gc-age-bucket", "v2/altinity/image/blobs/"+reused.String()))
+	assert.NotNil(t, f.get("gc-age-bucket", "v2/altinity/image/manifests/"+digest.FromString(pending).String()))
+}
@@ -0,0 +1,246 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/Altinity/docker-sync/config"
+	"github.com/Altinity/docker-sync/internal/telemetry"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/docker/go-units"
+	"github.com/jellydator/ttlcache/v3"
+	"github.com/opencontainers/go-digest"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"golang.org/x/sync/errgroup"
+)
+
+// sharedBlobsRepository is the prefix of the shared blob store under v2/. Blobs are stored there once per bucket,
+// and v2/<repository>/blobs/<digest> are empty objects redirecting to them.
+const sharedBlobsRepository = "_blobs"
+
+// isSharedBlobs reports whether the registry of a bucket target uses the shared blob store.
+func isSharedBlobs(dst string) bool {
+	fields := strings.Split(dst, ":")
+	if len(fields) < 3 {
+		return false
+	}
+
+	repo := getRepository(strings.Join(fields[:3], ":"))
+
+	return repo != nil && repo.S3 != nil && repo.S3.SharedBlobs
+}
+
+func sharedBlobKey(blobDigest digest.Digest) string {
+	return path.Join("v2", sharedBlobsRepository, blobDigest.String())
+}
+
+// putBlobLink writes the object of a repository that redirects to a blob of the shared store. S3 website endpoints
+// follow the redirect; other setups rewrite v2/<repository>/blobs/<digest> to v2/_blobs/<digest>.
+func putBlobLink(ctx context.Context, s3c *s3Client, blobDigest digest.Digest) error {
+	key := path.Join(s3c.baseDir, "blobs", blobDigest.String())
+
+	input := &s3.PutObjectInput{
+		Bucket:                  s3c.bucket,
+		Key:                     aws.String(key),
+		Body:                    strings.NewReader(""),
+		ContentType:             aws.String("application/vnd.docker.image.rootfs.diff.tar.gzip"),
+		WebsiteRedirectLocation: aws.String("/" + sharedBlobKey(blobDigest)),
+		Metadata: map[string]string{
+			"x-calculated-digest": blobDigest.String(),
+		},
+	}
+	s3c.objectOptions.apply(input)
+
+	if _, err := s3c.s3Session.PutObject(ctx, input); err != nil {
+		return fmt.Errorf("failed to link blob %s in bucket %s: %w", blobDigest, *s3c.bucket, err)
+	}
+
+	if config.SyncS3ObjectCacheEnabled.Bool() {
+		objectCache.Set(fmt.Sprintf("%s/%s", *s3c.bucket, key), true, ttlcache.DefaultTTL)
+	}
+
+	return nil
+}
+
+// sharedGCBucket is a bucket whose shared blob store must be garbage collected.
+type sharedGCBucket struct {
+	s3Session *s3.Client
+	bucket    string
+}
+
+var (
+	pendingSharedGCMutex sync.Mutex
+	// pendingSharedGC are the buckets with a shared blob store whose repositories were garbage collected since
+	// their shared blobs were, keyed by bucket registry
+	pendingSharedGC = make(map[string]sharedGCBucket)
+)
+
+// scheduleSharedGC records that the links of a repository of a bucket target were garbage collected, so the
+// shared blobs of the bucket are collected by the next CollectSharedBlobs.
+func scheduleSharedGC(dst string, s3Session *s3.Client, bucket string) {
+	fields := strings.Split(dst, ":")
+
+	pendingSharedGCMutex.Lock()
+	defer pendingSharedGCMutex.Unlock()
+
+	pendingSharedGC[strings.Join(fields[:3], ":")] = sharedGCBucket{
+		s3Session: s3Session,
+		bucket:    bucket,
+	}
+}
+
+// CollectSharedBlobs garbage collects the shared blob stores of the buckets whose repositories were garbage
+// collected since the last call. It is called once the images of a sync were all synced, so each bucket is listed
+// once after the links of all its repositories were collected. It requires sync.s3.gc.lock, as the age of a
+// shared blob doesn't tell whether a push in progress just linked it.
+func CollectSharedBlobs(ctx context.Context) {
+	pendingSharedGCMutex.Lock()
+	pending := pendingSharedGC
+	pendingSharedGC = make(map[string]sharedGCBucket)
+	pendingSharedGCMutex.Unlock()
+
+	for registry, b := range pending {
+		if ctx.Err() != nil {
+			return
+		}
+
+		if !config.SyncS3GCLock.Bool() {
+			log.Warn().
+				Str("bucket", b.bucket).
+				Msg("Garbage collection of shared blobs requires sync.s3.gc.lock, skipping it")
+
+			continue
+		}
+
+		if _, err := deleteOrphansSharedS3(ctx, b.s3Session, b.bucket); err != nil {
+			log.Error().
+				Err(err).
+				Str("bucket", b.bucket).
+				Msg("Failed to delete orphaned shared blobs")
+
+			telemetry.PurgeErrors.Add(ctx, 1,
+				metric.WithAttributes(
+					attribute.KeyValue{
+						Key:   "target",
+						Value: attribute.StringValue(registry),
+					},
+					attribute.KeyValue{
+						Key:   "error",
+						Value: attribute.StringValue(err.Error()),
+					},
+				),
+			)
+		}
+	}
+}
+
+// deleteOrphansSharedS3 deletes the blobs of the shared store that no repository of the bucket links to anymore.
+// It holds the lock of the shared store, and is skipped while pushes to the bucket are in progress.
+func deleteOrphansSharedS3(ctx context.Context, s3Session *s3.Client, bucket string) (*gcSummary, error) {
+	lock, err := newS3Lock(ctx, s3Session, bucket, gcLockKey(sharedBlobsRepository))
+	if err != nil {
+		return nil, err
+	}
+	defer lock.release(ctx)
+
+	shared := make(map[digest.Digest]s3Object)
+	// references counts the repositories linking to each blob
+	references := make(map[digest.Digest]int)
+	var pushes int
+
+	p := s3.NewListObjectsV2Paginator(s3Session, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String("v2/"),
+	})
+
+	var i int
+	for p.HasMorePages() {
+		i++
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get page %d, %w", i, err)
+		}
+
+		for _, obj := range page.Contents {
+			key := aws.ToString(obj.Key)
+			name := path.Base(key)
+
+			switch {
+			case key == sharedBlobKey(digest.Digest(name)):
+				shared[digest.Digest(name)] = s3Object{
+					size:         aws.ToInt64(obj.Size),
+					lastModified: aws.ToTime(obj.LastModified),
+				}
+			case strings.HasSuffix(path.Dir(key), "/blobs") && strings.HasPrefix(name, "sha256:"):
+				references[digest.Digest(name)]++
+			case strings.Contains(key, "/_locks/push/") && isLockActive(aws.ToTime(obj.LastModified)):
+				pushes++
+			}
+		}
+	}
+
+	if pushes > 0 {
+		log.Info().
+			Str("bucket", bucket).
+			Int("pushes", pushes).
+			Msg("Pushes in progress, skipping garbage collection of shared blobs")
+
+		return &gcSummary{}, nil
+	}
+
+	var orphans []string
+	summary := &gcSummary{}
+
+	for d, obj := range shared {
+		if references[d] > 0 || isRecent(obj) {
+			continue
+		}
+
+		orphans = append(orphans, sharedBlobKey(d))
+		summary.blobs++
+		summary.bytes += obj.size
+	}
+
+	if len(orphans) == 0 {
+		log.Info().
+			Str("bucket", bucket).
+			Int("blobs", len(shared)).
+			Msg("No orphaned shared blobs found")
+
+		return summary, nil
+	}
+
+	slices.Sort(orphans)
+
+	g, _ := errgroup.WithContext(ctx)
+	g.SetLimit(max(1, config.SyncS3MaxPurgeConcurrency.Int()))
+
+	for _, key := range orphans {
+		g.Go(func() error {
+			return deleteObject(ctx, &s3Client{
+				s3Session: s3Session,
+				bucket:    aws.String(bucket),
+			}, key)
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	log.Info().
+		Str("bucket", bucket).
+		Int("blobs", summary.blobs).
+		Int64("bytes", summary.bytes).
+		Str("reclaimed", units.BytesSize(float64(summary.bytes))).
+		Msg("Garbage collection of shared blobs finished")
+
+	return summary, nil
+}
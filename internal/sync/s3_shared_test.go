@@ -0,0 +1,138 @@
+package sync
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Altinity/docker-sync/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSharedBlobs(t *testing.T) {
+	f := setupFakeS3(t, "shared-bucket")
+
+	s3Session, bucket, err := getS3Session("s3:fake:shared-bucket:altinity/a")
+	assert.NoError(t, err)
+
+	destination := func(repository string) types.ImageDestination {
+		ref := &s3Reference{
+			s3c: &s3Client{
+				uploader:    manager.NewUploader(s3Session),
+				s3Session:   s3Session,
+				dst:         "s3:fake:shared-bucket:" + repository,
+				bucket:      bucket,
+				baseDir:     "v2/" + repository,
+				sharedBlobs: true,
+			},
+			tag: "latest",
+		}
+
+		dest, err := ref.NewImageDestination(t.Context(), nil)
+		assert.NoError(t, err)
+
+		return dest
+	}
+
+	layer := "layer"
+	layerDigest := digest.FromString(layer)
+
+	info, err := destination("altinity/a").PutBlob(t.Context(), strings.NewReader(layer), types.BlobInfo{Digest: layerDigest, Size: -1}, nil, false)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(layer)), info.Size)
+
+	assert.Equal(t, []byte(layer), f.get("shared-bucket", "v2/_blobs/"+layerDigest.String()))
+	assert.Empty(t, f.get("shared-bucket", "v2/altinity/a/blobs/"+layerDigest.String()))
+	assert.Equal(t, "/v2/_blobs/"+layerDigest.String(), f.objects["shared-bucket/v2/altinity/a/blobs/"+layerDigest.String()].headers.Get("X-Amz-Website-Redirect-Location"))
+
+	// Another repository links to the blob instead of uploading it again
+	reused, info, err := destination("altinity/b").TryReusingBlob(t.Context(), types.BlobInfo{Digest: layerDigest, Size: -1}, nil, false)
+	assert.NoError(t, err)
+	assert.True(t, reused)
+	assert.Equal(t, int64(len(layer)), info.Size)
+	assert.NotNil(t, f.get("shared-bucket", "v2/altinity/b/blobs/"+layerDigest.String()))
+
+	reused, _, err = destination("altinity/b").TryReusingBlob(t.Context(), types.BlobInfo{Digest: digest.FromString("missing"), Size: -1}, nil, false)
+	assert.NoError(t, err)
+	assert.False(t, reused)
+}
+
+func TestDeleteOrphansSharedS3(t *testing.T) {
+	viper.Set("sync.s3.gc.minAge", "1h")
+	config.SyncS3GCMinAge.Update()
+	defer func() {
+		viper.Set("sync.s3.gc.minAge", "0s")
+		config.SyncS3GCMinAge.Update()
+	}()
+
+	f := setupFakeS3(t, "shared-gc-bucket")
+
+	s3Session, bucket, err := getS3Session("s3:fake:shared-gc-bucket:altinity/a")
+	assert.NoError(t, err)
+
+	blob := func(s string, age time.Duration) digest.Digest {
+		d := digest.FromString(s)
+		f.put("shared-gc-bucket", "v2/_blobs/"+d.String(), []byte(s))
+		f.age("shared-gc-bucket", "v2/_blobs/"+d.String(), age)
+		return d
+	}
+
+	linked := blob("linked", 2*time.Hour)
+	orphaned := blob("orphaned", 2*time.Hour)
+	fresh := blob("fresh", 0)
+
+	// The blob is kept as long as one repository links to it
+	f.put("shared-gc-bucket", "v2/altinity/b/blobs/"+linked.String(), nil)
+
+	summary, err := deleteOrphansSharedS3(t.Context(), s3Session, *bucket)
+	assert.NoError(t, err)
+	assert.Equal(t, &gcSummary{blobs: 1, bytes: int64(len("orphaned"))}, summary)
+
+	assert.NotNil(t, f.get("shared-gc-bucket", "v2/_blobs/"+linked.String()))
+	assert.Nil(t, f.get("shared-gc-bucket", "v2/_blobs/"+orphaned.String()))
+	assert.NotNil(t, f.get("shared-gc-bucket", "v2/_blobs/"+fresh.String()))
+	assert.Nil(t, f.get("shared-gc-bucket", gcLockKey(sharedBlobsRepository)))
+}
+
+func TestCollectSharedBlobs(t *testing.T) {
+	f := setupFakeS3(t, "shared-collect-bucket")
+
+	s3Session, bucket, err := getS3Session("s3:fake:shared-collect-bucket:altinity/a")
+	assert.NoError(t, err)
+
+	orphaned := digest.FromString("orphaned")
+	f.put("shared-collect-bucket", "v2/_blobs/"+orphaned.String(), []byte("orphaned"))
+
+	// Repositories of the same bucket are collected with a single listing
+	lists := 0
+	f.beforeList = func(bucket string, prefix string) {
+		lists++
+	}
+
+	// Shared blobs are kept without the lock
+	scheduleSharedGC("s3:fake:shared-collect-bucket:altinity/a", s3Session, *bucket)
+	CollectSharedBlobs(t.Context())
+	assert.NotNil(t, f.get("shared-collect-bucket", "v2/_blobs/"+orphaned.String()))
+	assert.Zero(t, lists)
+
+	viper.Set("sync.s3.gc.lock", true)
+	config.SyncS3GCLock.Update()
+	t.Cleanup(func() {
+		viper.Set("sync.s3.gc.lock", false)
+		config.SyncS3GCLock.Update()
+	})
+
+	scheduleSharedGC("s3:fake:shared-collect-bucket:altinity/a", s3Session, *bucket)
+	scheduleSharedGC("s3:fake:shared-collect-bucket:altinity/b", s3Session, *bucket)
+	CollectSharedBlobs(t.Context())
+	assert.Nil(t, f.get("shared-collect-bucket", "v2/_blobs/"+orphaned.String()))
+	assert.Equal(t, 1, lists)
+
+	// Nothing is collected again until a repository was
+	CollectSharedBlobs(t.Context())
+	assert.Equal(t, 1, lists)
+}
@@ -0,0 +1,98 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+)
+
+// s3Source reads an image from the bucket layout written by s3Destination, so buckets can be used as sources.
+type s3Source struct {
+	ref *s3Reference
+}
+
+func (s *s3Source) Reference() types.ImageReference {
+	return s.ref
+}
+
+func (s *s3Source) Close() error {
+	return nil
+}
+
+func (s *s3Source) getObject(ctx context.Context, key string) (*s3.GetObjectOutput, error) {
+	s3c := s.ref.s3c
+
+	resp, err := s3c.s3Session.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: s3c.bucket,
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object %s from bucket %s: %w", key, *s3c.bucket, err)
+	}
+
+	// Blobs of the shared blob store are linked with empty redirect objects
+	if location := aws.ToString(resp.WebsiteRedirectLocation); location != "" && aws.ToInt64(resp.ContentLength) == 0 {
+		resp.Body.Close()
+
+		return s.getObject(ctx, strings.TrimPrefix(location, "/"))
+	}
+
+	return resp, nil
+}
+
+// GetManifest returns the manifest of the tag, or of an instance of its manifest list.
+func (s *s3Source) GetManifest(ctx context.Context, instanceDigest *digest.Digest) ([]byte, string, error) {
+	name := s.ref.tag
+	if instanceDigest != nil {
+		name = instanceDigest.String()
+	}
+
+	if name == "" {
+		return nil, "", errors.New("no tag or digest to read the manifest of")
+	}
+
+	resp, err := s.getObject(ctx, path.Join(s.ref.s3c.baseDir, "manifests", name))
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	buf := new(bytes.Buffer)
+	if _, err := buf.ReadFrom(resp.Body); err != nil {
+		return nil, "", fmt.Errorf("failed to read object body: %w", err)
+	}
+
+	return buf.Bytes(), manifest.GuessMIMEType(buf.Bytes()), nil
+}
+
+func (s *s3Source) GetBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache) (io.ReadCloser, int64, error) {
+	resp, err := s.getObject(ctx, path.Join(s.ref.s3c.baseDir, "blobs", info.Digest.String()))
+	if err != nil {
+		return nil, -1, err
+	}
+
+	return resp.Body, aws.ToInt64(resp.ContentLength), nil
+}
+
+func (s *s3Source) HasThreadSafeGetBlob() bool {
+	return true
+}
+
+// GetSignatures returns no signatures, as they are not part of the bucket layout.
+func (s *s3Source) GetSignatures(ctx context.Context, instanceDigest *digest.Digest) ([][]byte, error) {
+	return nil, nil
+}
+
+func (s *s3Source) LayerInfosForCopy(ctx context.Context, instanceDigest *digest.Digest) ([]types.BlobInfo, error) {
+	return nil, nil
+}
@@ -0,0 +1,89 @@
+package sync
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Altinity/docker-sync/config"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetS3Session(t *testing.T) {
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	assert.NoError(t, os.WriteFile(caFile, []byte("not a certificate"), 0o644))
+
+	mockRepos := []map[string]interface{}{
+		{
+			"name": "aws",
+			"url":  "s3:eu-west-1:bucket-name",
+			"auth": map[string]interface{}{
+				"username": "s3-access-key",
+				"password": "s3-secret-key",
+			},
+		},
+		{
+			"name": "minio",
+			"url":  "s3:minio:bucket-name",
+			"auth": map[string]interface{}{
+				"username": "minio-access-key",
+				"password": "minio-secret-key",
+			},
+			"s3": map[string]interface{}{
+				"endpoint":  "http://localhost:9000",
+				"pathStyle": true,
+				"region":    "us-east-1",
+			},
+		},
+		{
+			"name": "ceph",
+			"url":  "s3:ceph:bucket-name",
+			"auth": map[string]interface{}{
+				"username": "ceph-access-key",
+				"password": "ceph-secret-key",
+			},
+			"s3": map[string]interface{}{
+				"endpoint": "https://rgw.example.com",
+				"caFile":   caFile,
+			},
+		},
+	}
+
+	viper.Set("sync.registries", mockRepos)
+	if reloaded := config.SyncRegistries.Update(); reloaded != nil && reloaded.Error != nil {
+		t.Fatalf("Failed to update SyncRegistries config: %v", reloaded.Error)
+	}
+
+	t.Run("AWS", func(t *testing.T) {
+		client, bucket, err := getS3Session("s3:eu-west-1:bucket-name:image")
+		assert.NoError(t, err)
+		assert.Equal(t, "bucket-name", *bucket)
+
+		cfg := client.Options()
+		assert.Nil(t, cfg.BaseEndpoint)
+		assert.False(t, cfg.UsePathStyle)
+		assert.Equal(t, "eu-west-1", cfg.Region)
+	})
+
+	t.Run("Custom endpoint", func(t *testing.T) {
+		client, bucket, err := getS3Session("s3:minio:bucket-name:image")
+		assert.NoError(t, err)
+		assert.Equal(t, "bucket-name", *bucket)
+
+		cfg := client.Options()
+		assert.Equal(t, "http://localhost:9000", *cfg.BaseEndpoint)
+		assert.True(t, cfg.UsePathStyle)
+		assert.Equal(t, "us-east-1", cfg.Region)
+
+		creds, err := cfg.Credentials.Retrieve(t.Context())
+		assert.NoError(t, err)
+		assert.Equal(t, "minio-access-key", creds.AccessKeyID)
+	})
+
+	t.Run("Invalid CA file", func(t *testing.T) {
+		_, _, err := getS3Session("s3:ceph:bucket-name:image")
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "no certificates found")
+	})
+}
@@ -0,0 +1,59 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Altinity/docker-sync/config"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrDrainTimeout is the cause of the sync context when syncs in progress did not finish within sync.drainTimeout
+// of a shutdown.
+var ErrDrainTimeout = errors.New("syncs in progress did not finish within the drain timeout")
+
+type shutdownKey struct{}
+
+// WithShutdown returns the context syncs run with. Canceling ctx requests a shutdown: no new image or tag is
+// started, and the syncs in progress are given sync.drainTimeout to finish before the returned context is
+// canceled with ErrDrainTimeout.
+func WithShutdown(ctx context.Context) (context.Context, context.CancelFunc) {
+	syncCtx, cancel := context.WithCancelCause(context.WithValue(context.WithoutCancel(ctx), shutdownKey{}, ctx))
+
+	stop := context.AfterFunc(ctx, func() {
+		drainTimeout := config.SyncDrainTimeout.Duration()
+
+		log.Info().
+			Dur("drainTimeout", drainTimeout).
+			Msg("Shutdown requested, waiting for syncs in progress")
+
+		timer := time.NewTimer(drainTimeout)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+			log.Warn().
+				Msg("Drain timeout reached, aborting syncs in progress")
+
+			cancel(ErrDrainTimeout)
+		case <-syncCtx.Done():
+		}
+	})
+
+	return syncCtx, func() {
+		stop()
+		cancel(context.Canceled)
+	}
+}
+
+// ShuttingDown reports whether a shutdown was requested, in which case no new image or tag is started.
+func ShuttingDown(ctx context.Context) bool {
+	if ctx.Err() != nil {
+		return true
+	}
+
+	parent, ok := ctx.Value(shutdownKey{}).(context.Context)
+
+	return ok && parent.Err() != nil
+}
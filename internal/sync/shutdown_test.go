@@ -0,0 +1,52 @@
+package sync
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Altinity/docker-sync/config"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithShutdown(t *testing.T) {
+	viper.Set("sync.drainTimeout", "50ms")
+	config.SyncDrainTimeout.Update()
+	defer func() {
+		viper.Set("sync.drainTimeout", "5m")
+		config.SyncDrainTimeout.Update()
+	}()
+
+	t.Run("Drained", func(t *testing.T) {
+		ctx, stop := context.WithCancel(t.Context())
+
+		syncCtx, cancel := WithShutdown(ctx)
+		assert.False(t, ShuttingDown(syncCtx))
+
+		// Syncs in progress keep running after a shutdown is requested
+		stop()
+		assert.True(t, ShuttingDown(syncCtx))
+		assert.NoError(t, syncCtx.Err())
+
+		cancel()
+		assert.ErrorIs(t, context.Cause(syncCtx), context.Canceled)
+	})
+
+	t.Run("Drain timeout", func(t *testing.T) {
+		ctx, stop := context.WithCancel(t.Context())
+
+		syncCtx, cancel := WithShutdown(ctx)
+		defer cancel()
+
+		stop()
+
+		select {
+		case <-syncCtx.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatal("syncs in progress were not aborted")
+		}
+
+		assert.ErrorIs(t, context.Cause(syncCtx), ErrDrainTimeout)
+	})
+}
@@ -0,0 +1,122 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/Altinity/docker-sync/structs"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/oci/layout"
+	"github.com/containers/image/v5/types"
+)
+
+// getSourceReference returns the reference of a source tag, or of the source repository if tag is empty. Besides
+// registries, images can be read from the buckets and layouts written by docker-sync.
+func getSourceReference(image *structs.Image, tag string) (types.ImageReference, error) {
+	switch getRepositoryType(image.Source) {
+	case S3CompatibleRepository:
+		s3Session, bucket, err := getBucketSession(image.Source)
+		if err != nil {
+			return nil, err
+		}
+
+		return &s3Reference{
+			s3c: &s3Client{
+				uploader:  manager.NewUploader(s3Session),
+				s3Session: s3Session,
+				dst:       image.Source,
+				bucket:    bucket,
+				baseDir:   path.Join("v2", image.GetSourceRepository()),
+			},
+			tag: tag,
+		}, nil
+	case OCILayoutRepository:
+		return layout.NewReference(getOCILayoutPath(image.Source), tag)
+	}
+
+	if tag == "" {
+		return docker.ParseReference(fmt.Sprintf("//%s", image.Source))
+	}
+
+	return docker.ParseReference(fmt.Sprintf("//%s:%s", image.Source, tag))
+}
+
+// getPushReference returns the reference a tag is pushed from: index if it is set, or else the source tag.
+func getPushReference(image *structs.Image, tag string, index *indexSourceReference) (types.ImageReference, error) {
+	if index != nil {
+		return index, nil
+	}
+
+	return getSourceReference(image, tag)
+}
+
+// getSourceContext returns the system context to read the source with, and the name of its authentication method.
+// Buckets are authenticated by their session, and layouts need no authentication.
+func getSourceContext(ctx context.Context, image *structs.Image) (*types.SystemContext, string) {
+	if getRepositoryType(image.Source) != OCIRepository {
+		return &types.SystemContext{}, "default"
+	}
+
+	return getRegistryContext(ctx, image.GetSourceRegistry(), image.GetSourceRepository())
+}
+
+// listSourceTags returns all the tags of the source repository.
+func listSourceTags(ctx context.Context, image *structs.Image, srcCtx *types.SystemContext, srcRef types.ImageReference) ([]string, error) {
+	var prefixed []string
+	var err error
+
+	switch getRepositoryType(image.Source) {
+	case S3CompatibleRepository:
+		prefixed, err = listS3Tags(ctx, image.Source, strings.Split(image.Source, ":"))
+	case OCILayoutRepository:
+		prefixed, err = listOCILayoutTags(image.Source)
+	default:
+		return docker.GetRepositoryTags(ctx, srcCtx, srcRef)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Buckets and layouts list tags in the <source>:<tag> format used for targets
+	tags := make([]string, 0, len(prefixed))
+	for _, tag := range prefixed {
+		tags = append(tags, strings.TrimPrefix(tag, image.Source+":"))
+	}
+
+	return tags, nil
+}
+
+// getReferenceDigest returns the manifest digest of a reference. Registries are asked for the digest directly,
+// other sources have their manifest read.
+func getReferenceDigest(ctx context.Context, ref types.ImageReference, sys *types.SystemContext) (string, error) {
+	if ref.Transport().Name() == docker.Transport.Name() {
+		d, err := docker.GetDigest(ctx, sys, ref)
+		if err != nil {
+			return "", err
+		}
+
+		return d.String(), nil
+	}
+
+	src, err := ref.NewImageSource(ctx, sys)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	b, _, err := src.GetManifest(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+
+	d, err := manifest.Digest(b)
+	if err != nil {
+		return "", err
+	}
+
+	return d.String(), nil
+}
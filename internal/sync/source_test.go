@@ -0,0 +1,80 @@
+package sync
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/Altinity/docker-sync/structs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBucketAndLayoutSources(t *testing.T) {
+	f := setupFakeS3(t, "source-bucket")
+
+	path, src := setupTestLayout(t)
+
+	index, err := readOCILayoutIndex(path)
+	assert.NoError(t, err)
+
+	// A layout source is copied to a bucket
+	image := &structs.Image{Source: src}
+
+	srcRef, err := getSourceReference(image, "")
+	assert.NoError(t, err)
+
+	srcCtx, _ := getSourceContext(t.Context(), image)
+
+	tags, err := listSourceTags(t.Context(), image, srcCtx, srcRef)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1.0", "2.0"}, tags)
+
+	d, err := getSourceDigest(t.Context(), image, "2.0")
+	assert.NoError(t, err)
+	assert.Equal(t, index.Manifests[1].Digest.String(), d)
+
+	assert.NoError(t, pushS3(t.Context(), image, "s3:fake:source-bucket:altinity/image", "altinity/image", "2.0", nil))
+	assert.NotNil(t, f.get("source-bucket", "v2/altinity/image/manifests/2.0"))
+
+	// The bucket is then used as a source
+	image = &structs.Image{Source: "s3:fake:source-bucket:altinity/image"}
+
+	srcRef, err = getSourceReference(image, "")
+	assert.NoError(t, err)
+
+	tags, err = listSourceTags(t.Context(), image, srcCtx, srcRef)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"2.0"}, tags)
+
+	d, err = getSourceDigest(t.Context(), image, "2.0")
+	assert.NoError(t, err)
+	assert.Equal(t, index.Manifests[1].Digest.String(), d)
+
+	dst := fmt.Sprintf("oci:%s", t.TempDir())
+	assert.NoError(t, pushOCILayout(t.Context(), image, dst, "2.0", nil))
+
+	d, err = getOCILayoutDigest(dst, "2.0")
+	assert.NoError(t, err)
+	assert.Equal(t, index.Manifests[1].Digest.String(), d)
+}
+
+func TestSyncImageTag(t *testing.T) {
+	_, src := setupTestLayout(t)
+	dst := fmt.Sprintf("oci:%s", t.TempDir())
+
+	image := &structs.Image{
+		Source:  src,
+		Targets: []string{dst},
+		Tags:    []string{"2.*"},
+	}
+
+	assert.Error(t, SyncImageTag(t.Context(), image, "1.0"))
+	assert.NoError(t, SyncImageTag(t.Context(), image, "2.0"))
+
+	tags, err := listOCILayoutTags(dst)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{dst + ":2.0"}, tags)
+
+	// Failed pushes fail the tag, and the image shared with the scheduled syncs is left untouched
+	assert.ErrorContains(t, SyncImageTag(t.Context(), image, "2.1"), "failed to sync tag 2.1")
+	assert.Nil(t, image.SrcRef)
+}
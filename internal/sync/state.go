@@ -0,0 +1,379 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Altinity/docker-sync/config"
+	"github.com/Altinity/docker-sync/structs"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	awstypes "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/rs/zerolog/log"
+)
+
+// stateEntry is the digest last synced for a tag to a target. An empty digest records that an immutable tag is
+// present in the target.
+type stateEntry struct {
+	Digest  string    `json:"digest"`
+	Checked time.Time `json:"checked"`
+}
+
+// syncState records the digests synced per source, tag and target, so tags that did not move are skipped without
+// listing the targets again.
+type syncState struct {
+	Images map[string]map[string]map[string]stateEntry `json:"images"`
+}
+
+// stateBackend stores the encoded sync state.
+type stateBackend interface {
+	// load returns nil if no state was saved yet.
+	load(ctx context.Context) ([]byte, error)
+	save(ctx context.Context, b []byte) error
+}
+
+type fileStateBackend struct {
+	path string
+}
+
+func (b *fileStateBackend) load(ctx context.Context) ([]byte, error) {
+	data, err := os.ReadFile(b.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	return data, err
+}
+
+// save writes the state to a temporary file first, so an interrupted save doesn't corrupt it.
+func (b *fileStateBackend) save(ctx context.Context, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(b.path), filepath.Base(b.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), b.path)
+}
+
+type bucketStateBackend struct {
+	url string
+}
+
+func (b *bucketStateBackend) load(ctx context.Context) ([]byte, error) {
+	s3Session, bucket, err := getBucketSession(b.url)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s3Session.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: bucket,
+		Key:    aws.String(strings.Split(b.url, ":")[3]),
+	})
+	if err != nil {
+		var nsk *awstypes.NoSuchKey
+		if errors.As(err, &nsk) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return io.ReadAll(resp.Body)
+}
+
+func (b *bucketStateBackend) save(ctx context.Context, data []byte) error {
+	s3Session, bucket, err := getBucketSession(b.url)
+	if err != nil {
+		return err
+	}
+
+	_, err = s3Session.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      bucket,
+		Key:         aws.String(strings.Split(b.url, ":")[3]),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	})
+
+	return err
+}
+
+var (
+	state        *syncState
+	stateDirty   bool
+	stateMutex   sync.Mutex
+	stateStorage stateBackend
+	// stateLocation is the backend and path the state was loaded from
+	stateLocation string
+)
+
+func stateEnabled() bool {
+	backend := config.SyncStateBackend.String()
+
+	return backend != "" && backend != "none"
+}
+
+// getStateLocation returns the configured backend and path of the state.
+func getStateLocation() string {
+	return config.SyncStateBackend.String() + ":" + config.SyncStatePath.String()
+}
+
+func newStateBackend() (stateBackend, error) {
+	path := config.SyncStatePath.String()
+
+	switch config.SyncStateBackend.String() {
+	case "file":
+		return &fileStateBackend{path: path}, nil
+	case "bucket":
+		if getRepositoryType(path) != S3CompatibleRepository {
+			return nil, fmt.Errorf("invalid state path %q, format is <r2|s3>:<region/endpoint>:<bucket>:<key>", path)
+		}
+
+		return &bucketStateBackend{url: path}, nil
+	default:
+		return nil, fmt.Errorf("unsupported state backend: %s", config.SyncStateBackend.String())
+	}
+}
+
+// loadState loads the state on first use. A state that can't be loaded is replaced with an empty one, which only
+// makes the next sync list all targets.
+// Must be called with stateMutex held.
+func loadState(ctx context.Context) *syncState {
+	if state != nil {
+		return state
+	}
+
+	state = &syncState{
+		Images: make(map[string]map[string]map[string]stateEntry),
+	}
+	stateLocation = getStateLocation()
+
+	backend, err := newStateBackend()
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msg("Failed to configure state store")
+
+		return state
+	}
+	stateStorage = backend
+
+	data, err := backend.load(ctx)
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Str("path", config.SyncStatePath.String()).
+			Msg("Failed to load state, starting with an empty state")
+
+		return state
+	}
+
+	if data == nil {
+		return state
+	}
+
+	loaded := &syncState{}
+	if err := json.Unmarshal(data, loaded); err != nil || loaded.Images == nil {
+		log.Warn().
+			Err(err).
+			Str("path", config.SyncStatePath.String()).
+			Msg("Failed to decode state, starting with an empty state")
+
+		return state
+	}
+
+	state = loaded
+
+	log.Info().
+		Str("path", config.SyncStatePath.String()).
+		Int("images", len(state.Images)).
+		Msg("Loaded state")
+
+	return state
+}
+
+// ReloadState switches to the state store of a reloaded configuration, if it changed. Changes not saved yet are
+// saved to the previous store first, and the state is loaded from the new store on next use.
+func ReloadState(ctx context.Context) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	if state == nil || stateLocation == getStateLocation() {
+		return
+	}
+
+	if stateDirty && stateStorage != nil {
+		data, err := json.Marshal(state)
+		if err == nil {
+			err = stateStorage.save(ctx, data)
+		}
+
+		if err != nil {
+			log.Warn().
+				Err(err).
+				Msg("Failed to save state to the previous store")
+		}
+	}
+
+	log.Info().
+		Str("backend", config.SyncStateBackend.String()).
+		Str("path", config.SyncStatePath.String()).
+		Msg("State store changed")
+
+	state = nil
+	stateDirty = false
+	stateStorage = nil
+}
+
+// getStateEntry returns the entry of a tag in a target, unless it is older than sync.state.ttl.
+func getStateEntry(ctx context.Context, source string, tag string, dst string) (stateEntry, bool) {
+	if !stateEnabled() {
+		return stateEntry{}, false
+	}
+
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	entry, ok := loadState(ctx).Images[source][tag][dst]
+	if !ok {
+		return stateEntry{}, false
+	}
+
+	if ttl := config.SyncStateTTL.Duration(); ttl > 0 && time.Since(entry.Checked) > ttl {
+		return stateEntry{}, false
+	}
+
+	return entry, true
+}
+
+// setStateEntry records the digest of a tag in a target.
+func setStateEntry(ctx context.Context, source string, tag string, dst string, digest string) {
+	if !stateEnabled() {
+		return
+	}
+
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	s := loadState(ctx)
+
+	if s.Images[source] == nil {
+		s.Images[source] = make(map[string]map[string]stateEntry)
+	}
+	if s.Images[source][tag] == nil {
+		s.Images[source][tag] = make(map[string]stateEntry)
+	}
+
+	s.Images[source][tag][dst] = stateEntry{
+		Digest:  digest,
+		Checked: time.Now().UTC(),
+	}
+	stateDirty = true
+}
+
+// stateSourceDigest returns the source digest recorded for a tag in any target, empty if there is none.
+func stateSourceDigest(ctx context.Context, image *structs.Image, tag string) string {
+	for _, dst := range image.Targets {
+		if entry, ok := getStateEntry(ctx, image.Source, tag, dst); ok && entry.Digest != "" {
+			return entry.Digest
+		}
+	}
+
+	return ""
+}
+
+// deleteStateEntry forgets a tag deleted from a target.
+func deleteStateEntry(ctx context.Context, source string, tag string, dst string) {
+	if !stateEnabled() {
+		return
+	}
+
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	s := loadState(ctx)
+
+	if _, ok := s.Images[source][tag][dst]; !ok {
+		return
+	}
+
+	delete(s.Images[source][tag], dst)
+	if len(s.Images[source][tag]) == 0 {
+		delete(s.Images[source], tag)
+	}
+	if len(s.Images[source]) == 0 {
+		delete(s.Images, source)
+	}
+	stateDirty = true
+}
+
+// saveState persists the state if it changed since it was last saved.
+func saveState(ctx context.Context) error {
+	if !stateEnabled() {
+		return nil
+	}
+
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	if !stateDirty || stateStorage == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	if err := stateStorage.save(ctx, data); err != nil {
+		return fmt.Errorf("failed to save state to %s: %w", config.SyncStatePath.String(), err)
+	}
+
+	stateDirty = false
+
+	return nil
+}
+
+// stateDstTags returns the target tags recorded in the state, in the format of getDstTags, and whether every
+// source tag is recorded for every target.
+func stateDstTags(ctx context.Context, image *structs.Image, srcTags []string) ([]string, bool) {
+	if !stateEnabled() {
+		return nil, false
+	}
+
+	var dstTags []string
+
+	for _, tag := range srcTags {
+		for _, dst := range image.Targets {
+			if _, ok := getStateEntry(ctx, image.Source, tag, dst); !ok {
+				return nil, false
+			}
+
+			dstTags = append(dstTags, fmt.Sprintf("%s:%s", dst, tag))
+		}
+	}
+
+	slices.Sort(dstTags)
+
+	return dstTags, true
+}
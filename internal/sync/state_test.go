@@ -0,0 +1,179 @@
+package sync
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Altinity/docker-sync/config"
+	"github.com/Altinity/docker-sync/structs"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+// setupState configures the state store and resets the loaded state.
+func setupState(t *testing.T, backend string, path string) {
+	reset := func() {
+		stateMutex.Lock()
+		state, stateDirty, stateStorage = nil, false, nil
+		stateMutex.Unlock()
+	}
+
+	viper.Set("sync.state.backend", backend)
+	viper.Set("sync.state.path", path)
+	viper.Set("sync.state.ttl", "1h")
+	config.SyncStateBackend.Update()
+	config.SyncStatePath.Update()
+	config.SyncStateTTL.Update()
+	reset()
+
+	t.Cleanup(func() {
+		viper.Set("sync.state.backend", "none")
+		viper.Set("sync.state.path", "docker-sync-state.json")
+		viper.Set("sync.state.ttl", "24h")
+		config.SyncStateBackend.Update()
+		config.SyncStatePath.Update()
+		config.SyncStateTTL.Update()
+		reset()
+	})
+}
+
+// reloadState drops the loaded state, so the next access loads it from the backend.
+func reloadState() {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	state, stateStorage = nil, nil
+}
+
+func TestFileState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	setupState(t, "file", path)
+
+	image := &structs.Image{
+		Source:  "altinity/image",
+		Targets: []string{"ghcr.io/altinity/image", "r2:account-id:bucket:altinity/image"},
+	}
+
+	// Nothing is recorded yet
+	_, ok := stateDstTags(t.Context(), image, []string{"1.0"})
+	assert.False(t, ok)
+
+	setStateEntry(t.Context(), image.Source, "1.0", "ghcr.io/altinity/image", "sha256:1")
+	setStateEntry(t.Context(), image.Source, "1.0", "r2:account-id:bucket:altinity/image", "")
+	assert.NoError(t, saveState(t.Context()))
+
+	reloadState()
+
+	entry, ok := getStateEntry(t.Context(), image.Source, "1.0", "ghcr.io/altinity/image")
+	assert.True(t, ok)
+	assert.Equal(t, "sha256:1", entry.Digest)
+
+	dstTags, ok := stateDstTags(t.Context(), image, []string{"1.0"})
+	assert.True(t, ok)
+	assert.Equal(t, []string{"ghcr.io/altinity/image:1.0", "r2:account-id:bucket:altinity/image:1.0"}, dstTags)
+
+	_, ok = stateDstTags(t.Context(), image, []string{"1.0", "2.0"})
+	assert.False(t, ok)
+
+	// Entries older than the TTL are checked against the target again
+	stateMutex.Lock()
+	entry = state.Images[image.Source]["1.0"]["ghcr.io/altinity/image"]
+	entry.Checked = time.Now().Add(-2 * time.Hour)
+	state.Images[image.Source]["1.0"]["ghcr.io/altinity/image"] = entry
+	stateMutex.Unlock()
+
+	_, ok = getStateEntry(t.Context(), image.Source, "1.0", "ghcr.io/altinity/image")
+	assert.False(t, ok)
+
+	// Purged tags are forgotten
+	deleteStateEntry(t.Context(), image.Source, "1.0", "ghcr.io/altinity/image")
+	deleteStateEntry(t.Context(), image.Source, "1.0", "r2:account-id:bucket:altinity/image")
+	assert.NoError(t, saveState(t.Context()))
+
+	reloadState()
+
+	_, ok = getStateEntry(t.Context(), image.Source, "1.0", "r2:account-id:bucket:altinity/image")
+	assert.False(t, ok)
+	assert.Empty(t, loadState(t.Context()).Images)
+}
+
+func TestReloadState(t *testing.T) {
+	dir := t.TempDir()
+	setupState(t, "file", filepath.Join(dir, "old.json"))
+
+	setStateEntry(t.Context(), "altinity/image", "1.0", "ghcr.io/altinity/image", "sha256:1")
+
+	// An unchanged store keeps the loaded state
+	ReloadState(t.Context())
+	_, ok := getStateEntry(t.Context(), "altinity/image", "1.0", "ghcr.io/altinity/image")
+	assert.True(t, ok)
+
+	// Unsaved changes go to the previous store, and the state is loaded from the new one
+	viper.Set("sync.state.path", filepath.Join(dir, "new.json"))
+	config.SyncStatePath.Update()
+	ReloadState(t.Context())
+
+	_, ok = getStateEntry(t.Context(), "altinity/image", "1.0", "ghcr.io/altinity/image")
+	assert.False(t, ok)
+	assert.FileExists(t, filepath.Join(dir, "old.json"))
+}
+
+func TestBucketState(t *testing.T) {
+	f := setupFakeS3(t, "state-bucket")
+	setupState(t, "bucket", "s3:fake:state-bucket:docker-sync/state.json")
+
+	// A missing state object starts an empty state
+	_, ok := getStateEntry(t.Context(), "altinity/image", "1.0", "ghcr.io/altinity/image")
+	assert.False(t, ok)
+
+	setStateEntry(t.Context(), "altinity/image", "1.0", "ghcr.io/altinity/image", "sha256:1")
+	assert.NoError(t, saveState(t.Context()))
+	assert.NotNil(t, f.get("state-bucket", "docker-sync/state.json"))
+
+	reloadState()
+
+	entry, ok := getStateEntry(t.Context(), "altinity/image", "1.0", "ghcr.io/altinity/image")
+	assert.True(t, ok)
+	assert.Equal(t, "sha256:1", entry.Digest)
+}
+
+func TestCheckDigestsState(t *testing.T) {
+	viper.Set("sync.compareDigests", true)
+	config.SyncCompareDigests.Update()
+
+	setupState(t, "file", filepath.Join(t.TempDir(), "state.json"))
+
+	path, src := setupTestLayout(t)
+
+	index, err := readOCILayoutIndex(path)
+	assert.NoError(t, err)
+
+	image := &structs.Image{Source: src}
+
+	// The target can't be read, so the digests can't be compared. The source digest is passed on to record the push.
+	actions := []Action{
+		{Image: src, Target: "oci:" + filepath.Join(t.TempDir(), "missing"), Tag: "1.0", Type: ActionOverwrite},
+	}
+
+	checked := slices.Clone(actions)
+	checked[0].Digest = index.Manifests[0].Digest.String()
+
+	assert.Equal(t, checked, checkDigests(t.Context(), image, "1.0", actions))
+
+	// The state records that the target has the source digest, so the target isn't read
+	setStateEntry(t.Context(), src, "1.0", actions[0].Target, index.Manifests[0].Digest.String())
+	assert.Empty(t, checkDigests(t.Context(), image, "1.0", actions))
+
+	// The source moved since the last sync
+	setStateEntry(t.Context(), src, "1.0", actions[0].Target, "sha256:moved")
+	assert.Equal(t, checked, checkDigests(t.Context(), image, "1.0", actions))
+
+	// A target that already has the source digest is skipped, but only pushes are recorded, as plans compare digests too
+	unchanged := []Action{{Image: src, Target: src, Tag: "2.0", Type: ActionOverwrite}}
+	assert.Empty(t, checkDigests(t.Context(), image, "2.0", unchanged))
+
+	_, ok := getStateEntry(t.Context(), src, "2.0", src)
+	assert.False(t, ok)
+}
@@ -5,15 +5,25 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 )
 
-type manifestWithMediaType struct {
-	MediaType string `json:"mediaType"`
-}
-
 type s3Client struct {
 	uploader  *manager.Uploader
 	s3Session *s3.Client
 	dst       string
 	bucket    *string
-	acl       *string
 	baseDir   string
+	// sharedBlobs stores blobs in the shared blob store of the bucket, see s3_shared.go.
+	sharedBlobs bool
+	objectOptions
+}
+
+// objectOptions are the settings applied to every object uploaded to a bucket.
+type objectOptions struct {
+	// acl is the canned ACL of objects, nil to send none.
+	acl                  *string
+	storageClass         string
+	serverSideEncryption string
+	kmsKeyID             *string
+	metadata             map[string]string
+	// tagging is the URL-encoded object tag set.
+	tagging *string
 }
@@ -1,6 +1,10 @@
 package sync
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/Altinity/docker-sync/structs"
+)
 
 func TestIsSemVerTag(t *testing.T) {
 	tests := []struct {
@@ -25,3 +29,31 @@ func TestIsSemVerTag(t *testing.T) {
 		}
 	}
 }
+
+func TestSelectsTag(t *testing.T) {
+	image := &structs.Image{
+		Tags:        []string{"latest", "2.*", "@semver"},
+		IgnoredTags: []string{"2.0-rc"},
+	}
+
+	tests := []struct {
+		image    *structs.Image
+		tag      string
+		expected bool
+	}{
+		{image, "latest", true},
+		{image, "2.1-alpine", true},
+		{image, "v1.2.3", true},
+		{image, "2.0-rc", false},
+		{image, "nightly", false},
+		{&structs.Image{}, "nightly", true},
+		{&structs.Image{IncludeReferrers: true}, "sha256-1111111111111111111111111111111111111111111111111111111111111111.sig", false},
+	}
+
+	for _, test := range tests {
+		result := SelectsTag(test.image, test.tag)
+		if result != test.expected {
+			t.Errorf("SelectsTag(%v, %q) = %v; want %v", test.image.Tags, test.tag, result, test.expected)
+		}
+	}
+}
@@ -1,10 +1,6 @@
 package sync
 
 import (
-	"crypto/sha256"
-	"fmt"
-	"os"
-	"path/filepath"
 	"testing"
 )
 
@@ -15,6 +11,7 @@ func TestGetRepositoryType(t *testing.T) {
 	}{
 		{"s3:us-east-1:mybucket:myimage", S3CompatibleRepository},
 		{"docker.io/library/nginx", OCIRepository},
+		{"oci:/srv/mirror/library/nginx", OCILayoutRepository},
 	}
 
 	for _, test := range tests {
@@ -24,51 +21,3 @@ func TestGetRepositoryType(t *testing.T) {
 		}
 	}
 }
-
-func TestShamove(t *testing.T) {
-	// Setup temporary base directory for testing
-	baseDir := t.TempDir()
-
-	// Create a temporary file for testing
-	oldFile, err := os.CreateTemp(t.TempDir(), "shamove_old")
-	if err != nil {
-		t.Fatalf("unable to create temp file: %v", err)
-	}
-	defer os.Remove(oldFile.Name())
-
-	content := []byte("this is a test file")
-	if _, err := oldFile.Write(content); err != nil {
-		t.Fatalf("unable to write to temp file: %v", err)
-	}
-	if err := oldFile.Close(); err != nil {
-		t.Fatalf("unable to close temp file: %v", err)
-	}
-
-	folder := "dest"
-	// Ensure the destination directory exists
-	destDir := filepath.Join(baseDir, folder)
-	if err := os.MkdirAll(destDir, os.ModePerm); err != nil {
-		t.Fatalf("unable to create destination directory: %v", err)
-	}
-
-	newPath, err := shamove(baseDir, oldFile.Name(), folder)
-	if err != nil {
-		t.Fatalf("shamove failed: %v", err)
-	}
-
-	// Calculate expected digest
-	h := sha256.New()
-	h.Write(content)
-	expectedDigest := fmt.Sprintf("sha256:%x", h.Sum(nil))
-
-	expectedNewPath := filepath.Join(baseDir, folder, expectedDigest)
-
-	if newPath != expectedNewPath {
-		t.Errorf("shamove path = %q; want %q", newPath, expectedNewPath)
-	}
-
-	// Check if the file exists at new path
-	if _, err := os.Stat(newPath); os.IsNotExist(err) {
-		t.Errorf("file not found at new path: %q", newPath)
-	}
-}
@@ -19,6 +19,14 @@ var PurgeErrors = must(meter.Int64Counter("purge_errors",
 	metric.WithDescription("Total number of purge errors"),
 ))
 
+var ImmutableTagChanges = must(meter.Int64Counter("immutable_tag_changes",
+	metric.WithDescription("Total number of immutable tags whose digest changed in the source"),
+))
+
+var SignatureVerificationFailures = must(meter.Int64Counter("signature_verification_failures",
+	metric.WithDescription("Total number of tags refused by the signature policy"),
+))
+
 var Pushes = must(meter.Int64Counter("pushes",
 	metric.WithDescription("Total number of pushes"),
 ))
@@ -38,3 +46,7 @@ var MonitoredImages = must(meter.Int64Gauge("monitored_images",
 var MonitoredTags = must(meter.Int64Gauge("monitored_tags",
 	metric.WithDescription("Total number of monitored tags"),
 ))
+
+var NextSync = must(meter.Int64Gauge("next_sync_timestamp_seconds",
+	metric.WithDescription("Unix time of the next scheduled sync of an image"),
+))
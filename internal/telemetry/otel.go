@@ -44,7 +44,8 @@ func Start(ctx context.Context) error {
 		return err
 	}
 	defer func() {
-		if err := meterProvider.Shutdown(ctx); err != nil {
+		// Flush the metrics recorded before the context was canceled
+		if err := meterProvider.Shutdown(context.WithoutCancel(ctx)); err != nil {
 			logger.Error().
 				Err(err).
 				Msg("Error shutting down meter provider")
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/Altinity/docker-sync/config"
+	"github.com/Altinity/docker-sync/internal/admin"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog"
 )
@@ -23,6 +24,10 @@ func startPrometheusServer(ctx context.Context) error {
 	mux := http.NewServeMux()
 	mux.Handle(path, promhttp.Handler())
 
+	if config.AdminEnabled.Bool() && admin.SharesPrometheusServer() {
+		admin.Register(mux)
+	}
+
 	server := &http.Server{
 		BaseContext: func(net.Listener) context.Context {
 			return ctx
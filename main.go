@@ -2,9 +2,14 @@ package dockersync
 
 import (
 	"context"
+	"errors"
+	"maps"
+	"slices"
+	stdsync "sync"
 	"time"
 
 	"github.com/Altinity/docker-sync/config"
+	"github.com/Altinity/docker-sync/internal/admin"
 	"github.com/Altinity/docker-sync/internal/sync"
 	"github.com/Altinity/docker-sync/internal/telemetry"
 	"github.com/Altinity/docker-sync/structs"
@@ -12,83 +17,337 @@ import (
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/metric"
 	"go.uber.org/multierr"
+	"golang.org/x/sync/errgroup"
 )
 
+// Run syncs the images on their schedules until ctx is canceled. Syncs in progress are then given
+// sync.drainTimeout to finish, and an error is returned if they had to be aborted.
 func Run(ctx context.Context) error {
+	syncCtx, cancel := sync.WithShutdown(ctx)
+	defer cancel()
+
+	// Telemetry runs until the syncs are drained, and is flushed before returning
+	telemetryDone := make(chan struct{})
 	if config.TelemetryEnabled.Bool() {
 		go func() {
-			if err := telemetry.Start(ctx); err != nil {
+			defer close(telemetryDone)
+
+			if err := telemetry.Start(syncCtx); err != nil {
 				log.Error().
 					Err(err).
 					Msg("Failed to start telemetry")
 			}
 		}()
+	} else {
+		close(telemetryDone)
+	}
+
+	if config.AdminEnabled.Bool() && !admin.SharesPrometheusServer() {
+		go func() {
+			if err := admin.Start(syncCtx); err != nil {
+				log.Error().
+					Err(err).
+					Msg("Failed to start admin server")
+			}
+		}()
 	}
 
-	images := config.SyncImages.Images()
+	// Not ready anymore once shutting down, while the syncs in progress are drained
+	stopReady := context.AfterFunc(ctx, func() {
+		admin.SetReady(false)
+	})
+	defer stopReady()
+
+	sum := newSummary()
+
+	err := runScheduler(ctx, syncCtx, sum)
+	err = withDrainError(syncCtx, err)
+
+	sum.log(err)
+
+	cancel()
+	<-telemetryDone
+
+	return err
+}
+
+// runScheduler syncs each image when it is due, up to sync.maxConcurrentImages at once, until ctx is canceled or
+// sync.maxErrors images are failing.
+func runScheduler(ctx context.Context, syncCtx context.Context, sum *summary) error {
+	allImages := config.SyncImages.Images()
+	telemetry.MonitoredImages.Record(syncCtx, int64(len(allImages)))
+	admin.SetImages(allImages)
+
+	s := newScheduler(allImages, time.Now())
+
+	c := newController(ctx, syncCtx, s)
+	defer c.wait()
+
+	admin.SetController(c)
+	defer admin.SetController(nil)
+
+	// Configuration changes are applied between syncs
+	changes, err := config.Watch(ctx)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msg("Failed to watch configuration, changes won't be reloaded")
+	}
+
+	admin.SetReady(ctx.Err() == nil)
+
+	// imagesCtx stops the syncs in progress once sync.maxErrors is reached
+	imagesCtx, stop := context.WithCancelCause(syncCtx)
+	defer stop(nil)
+
+	var wg stdsync.WaitGroup
+	defer wg.Wait()
+
+	failing := newFailures()
+	maxErrors := make(chan error, 1)
 
 	for {
-		if err := RunOnce(ctx, images); err != nil {
+		for _, image := range s.due(time.Now()) {
+			// A reload replaces the semaphore, images already waiting for a slot keep the previous one
+			sem := s.semaphore()
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				// Shared blobs are only collected once the links of all the repositories of their bucket were,
+				// when no image is being synced
+				defer func() {
+					if s.finish(syncCtx, image) && !sync.ShuttingDown(imagesCtx) {
+						sync.CollectSharedBlobs(imagesCtx)
+					}
+				}()
+
+				select {
+				case sem <- struct{}{}:
+				case <-imagesCtx.Done():
+					return
+				}
+				defer func() { <-sem }()
+
+				if sync.ShuttingDown(imagesCtx) {
+					return
+				}
+				s.start(image)
+
+				if err := failing.record(image.Source, syncImage(imagesCtx, image, sum)); err != nil {
+					select {
+					case maxErrors <- err:
+					default:
+					}
+				}
+			}()
+		}
+
+		// Tags pushed while their image was being synced are synced once it finished
+		for _, pushed := range s.duePushed() {
+			c.syncPushed(pushed)
+		}
+
+		next := s.wakeup()
+		log.Info().Time("next", next).Dur("wait", time.Until(next)).Msg("Waiting for next sync")
+		admin.Waiting(next)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-maxErrors:
+			stop(err)
 			return err
+		case <-changes:
+			if reloadConfig() {
+				images := config.SyncImages.Images()
+				telemetry.MonitoredImages.Record(syncCtx, int64(len(images)))
+				admin.SetImages(images)
+
+				s.update(images)
+				s.setLimit(max(1, config.SyncMaxConcurrentImages.Int()))
+				sync.ReloadState(syncCtx)
+			}
+		case <-s.wake:
+		case <-time.After(time.Until(next)):
 		}
+	}
+}
+
+// failures tracks the images whose last sync failed.
+type failures struct {
+	mutex  stdsync.Mutex
+	errors map[string]error
+}
+
+func newFailures() *failures {
+	return &failures{errors: make(map[string]error)}
+}
+
+// record records the result of the last sync of an image, and returns the errors of the failing images once
+// sync.maxErrors of them are failing.
+func (f *failures) record(source string, err error) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if err == nil {
+		delete(f.errors, source)
+		return nil
+	}
 
-		dur := config.SyncInterval.Duration()
-		log.Info().Dur("interval", dur).Msg("Waiting for next sync")
-		time.Sleep(dur)
+	f.errors[source] = err
+
+	if config.SyncMaxErrors.Int() <= 0 || len(f.errors) < config.SyncMaxErrors.Int() {
+		return nil
+	}
+
+	var merr error
+	for _, source := range slices.Sorted(maps.Keys(f.errors)) {
+		merr = multierr.Append(merr, f.errors[source])
 	}
+
+	return merr
 }
 
+// RunOnce syncs the images once. If ctx is canceled, syncs in progress are given sync.drainTimeout to finish.
 func RunOnce(ctx context.Context, images []*structs.Image) error {
+	syncCtx, cancel := sync.WithShutdown(ctx)
+	defer cancel()
+
+	telemetry.MonitoredImages.Record(syncCtx, int64(len(images)))
+	admin.SetImages(images)
+
+	sum := newSummary()
+
+	err := syncImages(syncCtx, images, sum)
+	err = withDrainError(syncCtx, err)
+
+	sum.log(err)
+
+	return err
+}
+
+// withDrainError adds ErrDrainTimeout to err if syncs in progress were aborted on shutdown.
+func withDrainError(syncCtx context.Context, err error) error {
+	if cause := context.Cause(syncCtx); errors.Is(cause, sync.ErrDrainTimeout) {
+		return multierr.Append(err, cause)
+	}
+
+	return err
+}
+
+// syncImages syncs the images once, stopping once sync.maxErrors is reached.
+func syncImages(ctx context.Context, images []*structs.Image, sum *summary) error {
 	var merr error
+	var merrMutex stdsync.Mutex
+
+	// The group context is canceled once maxErrors is reached, stopping the remaining images
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(max(1, config.SyncMaxConcurrentImages.Int()))
 
 	for k := range images {
-		telemetry.MonitoredImages.Record(ctx, int64(len(images)))
+		if sync.ShuttingDown(gctx) {
+			break
+		}
 
-		select {
-		case <-ctx.Done():
-			return nil
-		default:
-			image := images[k]
-
-			// Initialize telemetry for the image
-			telemetry.ImageSyncErrors.Add(ctx, 0,
-				metric.WithAttributes(
-					attribute.KeyValue{
-						Key:   "image",
-						Value: attribute.StringValue(image.Source),
-					},
-				),
-			)
-
-			if err := sync.SyncImage(ctx, image); err != nil {
-				log.Error().
-					Err(err).
-					Str("source", image.Source).
-					Msg("Failed to sync image")
-
-				telemetry.ImageSyncErrors.Add(ctx, 1,
-					metric.WithAttributes(
-						attribute.KeyValue{
-							Key:   "image",
-							Value: attribute.StringValue(image.Source),
-						},
-						attribute.KeyValue{
-							Key:   "error",
-							Value: attribute.StringValue(err.Error()),
-						},
-					),
-				)
-
-				merr = multierr.Append(merr, err)
-
-				if config.SyncMaxErrors.Int() > 0 {
-					if len(multierr.Errors(merr)) >= config.SyncMaxErrors.Int() {
-						return merr
-					}
+		image := images[k]
+
+		g.Go(func() error {
+			err := syncImage(gctx, image, sum)
+			if err == nil {
+				return nil
+			}
+
+			merrMutex.Lock()
+			defer merrMutex.Unlock()
+
+			merr = multierr.Append(merr, err)
+
+			if config.SyncMaxErrors.Int() > 0 {
+				if len(multierr.Errors(merr)) >= config.SyncMaxErrors.Int() {
+					return merr
 				}
 			}
-		}
+
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		merrMutex.Lock()
+		defer merrMutex.Unlock()
+
+		// Include errors from images that finished after the limit was reached
+		return merr
+	}
+
+	// Shared blobs are only collected once the links of all the repositories of their bucket were synced
+	if !sync.ShuttingDown(ctx) {
+		sync.CollectSharedBlobs(ctx)
 	}
 
 	return nil
 }
+
+// syncImage syncs an image, tracking it for the admin API and the summary. Syncs canceled through the admin API
+// are not errors, and don't count towards sync.maxErrors.
+func syncImage(ctx context.Context, image *structs.Image, sum *summary) error {
+	// Initialize telemetry for the image
+	telemetry.ImageSyncErrors.Add(ctx, 0,
+		metric.WithAttributes(
+			attribute.KeyValue{
+				Key:   "image",
+				Value: attribute.StringValue(image.Source),
+			},
+		),
+	)
+
+	imageCtx, finish := syncs.start(ctx, image.Source)
+	admin.ImageStarted(image.Source)
+
+	err := sync.SyncImage(imageCtx, image)
+	if canceled(imageCtx) {
+		err = context.Cause(imageCtx)
+	}
+
+	// The status is recorded before notifying the syncs waited for through the admin API
+	admin.ImageFinished(image.Source, err)
+	finish()
+
+	if canceled(imageCtx) {
+		log.Warn().
+			Str("source", image.Source).
+			Msg("Image sync canceled")
+
+		return nil
+	}
+
+	if err != nil {
+		sum.failed.Add(1)
+
+		log.Error().
+			Err(err).
+			Str("source", image.Source).
+			Msg("Failed to sync image")
+
+		telemetry.ImageSyncErrors.Add(ctx, 1,
+			metric.WithAttributes(
+				attribute.KeyValue{
+					Key:   "image",
+					Value: attribute.StringValue(image.Source),
+				},
+				attribute.KeyValue{
+					Key:   "error",
+					Value: attribute.StringValue(err.Error()),
+				},
+			),
+		)
+
+		return err
+	}
+
+	sum.synced.Add(1)
+
+	return nil
+}
@@ -0,0 +1,38 @@
+package dockersync
+
+import (
+	"context"
+
+	"github.com/Altinity/docker-sync/internal/sync"
+	"github.com/Altinity/docker-sync/structs"
+	"github.com/rs/zerolog/log"
+	"go.uber.org/multierr"
+)
+
+// Plan returns the actions a sync of the images would perform, without performing them.
+func Plan(ctx context.Context, images []*structs.Image) ([]sync.Action, error) {
+	var actions []sync.Action
+	var merr error
+
+	for _, image := range images {
+		if ctx.Err() != nil {
+			return actions, ctx.Err()
+		}
+
+		imageActions, err := sync.PlanImage(ctx, image)
+		if err != nil {
+			log.Error().
+				Err(err).
+				Str("source", image.Source).
+				Msg("Failed to plan image")
+
+			merr = multierr.Append(merr, err)
+
+			continue
+		}
+
+		actions = append(actions, imageActions...)
+	}
+
+	return actions, merr
+}
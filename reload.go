@@ -1,7 +1,12 @@
 package dockersync
 
 import (
+	"encoding/json"
+	"slices"
+
 	"github.com/Altinity/docker-sync/config"
+	"github.com/Altinity/docker-sync/logging"
+	"github.com/Altinity/docker-sync/structs"
 	"github.com/rs/zerolog/log"
 )
 
@@ -29,3 +34,92 @@ func Reload() {
 		}
 	}
 }
+
+// reloadConfig reads the configuration files again and applies them, logging the images and registries that
+// changed. An invalid configuration is rejected and the current one stays active.
+func reloadConfig() bool {
+	oldImages := config.SyncImages.Images()
+	oldRegistries := config.SyncRegistries.Repositories()
+
+	if err := config.ReadConfig(); err != nil {
+		log.Error().
+			Err(err).
+			Msg("Invalid configuration, keeping the current configuration")
+
+		return false
+	}
+
+	Reload()
+	logging.ReloadGlobalLogger()
+
+	added, removed, changed := diffConfig(oldImages, config.SyncImages.Images(), func(image *structs.Image) string {
+		return image.Source
+	})
+	for _, source := range added {
+		log.Info().Str("image", source).Msg("Image added")
+	}
+	for _, source := range removed {
+		log.Info().Str("image", source).Msg("Image removed")
+	}
+	for _, source := range changed {
+		log.Info().Str("image", source).Msg("Image changed")
+	}
+
+	// Registries are only logged by URL, as they hold credentials
+	added, removed, changed = diffConfig(oldRegistries, config.SyncRegistries.Repositories(), func(repo *structs.Repository) string {
+		return repo.URL
+	})
+	for _, url := range added {
+		log.Info().Str("registry", url).Msg("Registry added")
+	}
+	for _, url := range removed {
+		log.Info().Str("registry", url).Msg("Registry removed")
+	}
+	for _, url := range changed {
+		log.Info().Str("registry", url).Msg("Registry changed")
+	}
+
+	log.Info().Msg("Configuration reloaded")
+
+	return true
+}
+
+// diffConfig returns the keys of the items added, removed and changed between two configurations.
+func diffConfig[T any](oldItems []T, newItems []T, key func(T) string) ([]string, []string, []string) {
+	var added, removed, changed []string
+
+	index := func(items []T) map[string]string {
+		m := make(map[string]string)
+		for _, item := range items {
+			b, _ := json.Marshal(item)
+			m[key(item)] = string(b)
+		}
+
+		return m
+	}
+
+	oldIndex := index(oldItems)
+	newIndex := index(newItems)
+
+	for k, v := range newIndex {
+		old, ok := oldIndex[k]
+		switch {
+		case !ok:
+			added = append(added, k)
+		case old != v:
+			changed = append(changed, k)
+		}
+	}
+
+	for k := range oldIndex {
+		if _, ok := newIndex[k]; !ok {
+			removed = append(removed, k)
+		}
+	}
+
+	slices.Sort(added)
+	slices.Sort(removed)
+	slices.Sort(changed)
+
+	return added, removed, changed
+}
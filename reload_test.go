@@ -0,0 +1,30 @@
+package dockersync
+
+import (
+	"testing"
+
+	"github.com/Altinity/docker-sync/structs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffConfig(t *testing.T) {
+	oldImages := []*structs.Image{
+		{Source: "altinity/kept", Targets: []string{"ghcr.io/altinity/kept"}},
+		{Source: "altinity/changed", Targets: []string{"ghcr.io/altinity/changed"}},
+		{Source: "altinity/removed", Targets: []string{"ghcr.io/altinity/removed"}},
+	}
+
+	newImages := []*structs.Image{
+		{Source: "altinity/kept", Targets: []string{"ghcr.io/altinity/kept"}},
+		{Source: "altinity/changed", Targets: []string{"ghcr.io/altinity/changed"}, Interval: "5m"},
+		{Source: "altinity/added", Targets: []string{"ghcr.io/altinity/added"}},
+	}
+
+	added, removed, changed := diffConfig(oldImages, newImages, func(image *structs.Image) string {
+		return image.Source
+	})
+
+	assert.Equal(t, []string{"altinity/added"}, added)
+	assert.Equal(t, []string{"altinity/removed"}, removed)
+	assert.Equal(t, []string{"altinity/changed"}, changed)
+}
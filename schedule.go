@@ -0,0 +1,534 @@
+package dockersync
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"slices"
+	stdsync "sync"
+	"time"
+
+	"github.com/Altinity/docker-sync/config"
+	"github.com/Altinity/docker-sync/internal/admin"
+	"github.com/Altinity/docker-sync/internal/telemetry"
+	"github.com/Altinity/docker-sync/structs"
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/robfig/cron/v3"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// scheduler tracks when each image is due for its next sync. Images can be triggered and paused through the
+// admin API while syncs are in progress, so its methods are safe for concurrent use.
+type scheduler struct {
+	mutex  stdsync.Mutex
+	images []*structs.Image
+	next   map[*structs.Image]time.Time
+	// running images are being synced, with the time they were due
+	running map[*structs.Image]time.Time
+	// queued images are running but wait for a sync slot, so triggering them again is not needed
+	queued map[*structs.Image]bool
+	// triggered images are due on the next wakeup, regardless of their schedule
+	triggered map[*structs.Image]bool
+	// tagSyncs are images whose pushed tags are being synced
+	tagSyncs map[*structs.Image]bool
+	// pushed are the tags pushed while their image was being synced, synced once it finishes
+	pushed map[*structs.Image][]string
+	// paused sources are not synced until they are resumed
+	paused map[string]bool
+	// slots limits the syncs of images and pushed tags to sync.maxConcurrentImages
+	slots chan struct{}
+	// wake is signaled when images are triggered, resumed or finished syncing while waiting for the next sync
+	wake chan struct{}
+}
+
+// pushedTags are tags pushed to an image, synced without syncing the whole image.
+type pushedTags struct {
+	image *structs.Image
+	tags  []string
+}
+
+// newScheduler returns a scheduler with the images due immediately, or at the next time of their schedule.
+func newScheduler(images []*structs.Image, now time.Time) *scheduler {
+	s := &scheduler{
+		images:    images,
+		next:      make(map[*structs.Image]time.Time),
+		running:   make(map[*structs.Image]time.Time),
+		queued:    make(map[*structs.Image]bool),
+		triggered: make(map[*structs.Image]bool),
+		tagSyncs:  make(map[*structs.Image]bool),
+		pushed:    make(map[*structs.Image][]string),
+		paused:    make(map[string]bool),
+		slots:     make(chan struct{}, max(1, config.SyncMaxConcurrentImages.Int())),
+		wake:      make(chan struct{}, 1),
+	}
+
+	for _, image := range images {
+		s.scheduleFirst(image, now)
+	}
+
+	return s
+}
+
+// scheduleFirst sets the first sync of an image: images with a schedule wait for its next time, others are due
+// immediately. Must be called with mutex held.
+func (s *scheduler) scheduleFirst(image *structs.Image, now time.Time) {
+	if image.Schedule == "" {
+		return
+	}
+
+	next := nextSync(image, now)
+	s.next[image] = next
+	admin.SetNextSync(image.Source, next)
+}
+
+// update replaces the images after a configuration reload. Unchanged images keep their next sync, new and
+// changed images are scheduled as on start.
+func (s *scheduler) update(images []*structs.Image) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	next := make(map[*structs.Image]time.Time)
+	running := make(map[*structs.Image]time.Time)
+	queued := make(map[*structs.Image]bool)
+	triggered := make(map[*structs.Image]bool)
+	tagSyncs := maps.Clone(s.tagSyncs)
+	pushed := maps.Clone(s.pushed)
+
+	for old, t := range s.running {
+		running[old] = t
+	}
+	for old := range s.queued {
+		queued[old] = true
+	}
+
+	for _, image := range images {
+		i := slices.IndexFunc(s.images, func(old *structs.Image) bool {
+			return sameImage(old, image)
+		})
+		if i < 0 {
+			s.scheduleFirst(image, time.Now())
+			next[image] = s.next[image]
+			continue
+		}
+
+		old := s.images[i]
+		next[image] = s.next[old]
+		if s.triggered[old] {
+			triggered[image] = true
+		}
+		if t, ok := running[old]; ok {
+			delete(running, old)
+			running[image] = t
+		}
+		if queued[old] {
+			delete(queued, old)
+			queued[image] = true
+		}
+		if tagSyncs[old] {
+			delete(tagSyncs, old)
+			tagSyncs[image] = true
+		}
+		if tags, ok := pushed[old]; ok {
+			delete(pushed, old)
+			pushed[image] = tags
+		}
+	}
+
+	// The tags pushed to removed or changed images are left to their next sync
+	for image := range pushed {
+		if !slices.Contains(images, image) {
+			delete(pushed, image)
+		}
+	}
+
+	s.images = images
+	s.next = next
+	s.running = running
+	s.queued = queued
+	s.triggered = triggered
+	s.tagSyncs = tagSyncs
+	s.pushed = pushed
+
+	// Paused sources stay paused as long as they are configured
+	for source := range s.paused {
+		if !s.hasSource(source) {
+			delete(s.paused, source)
+		}
+	}
+}
+
+func sameImage(a *structs.Image, b *structs.Image) bool {
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+
+	return string(ja) == string(jb)
+}
+
+// busy reports whether an image is being synced, or its pushed tags are. Must be called with mutex held.
+func (s *scheduler) busy(image *structs.Image) bool {
+	_, ok := s.running[image]
+
+	return ok || s.tagSyncs[image]
+}
+
+// due returns the images that are triggered or whose next sync is at or before now, in configuration order, and
+// marks them running until they finish. Paused and busy images are never due.
+func (s *scheduler) due(now time.Time) []*structs.Image {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var images []*structs.Image
+
+	for _, image := range s.images {
+		if s.busy(image) || s.paused[image.Source] {
+			continue
+		}
+
+		if s.triggered[image] || !s.next[image].After(now) {
+			images = append(images, image)
+			delete(s.triggered, image)
+			s.running[image] = now
+			s.queued[image] = true
+		}
+	}
+
+	return images
+}
+
+// start records that a due image got a sync slot and starts syncing.
+func (s *scheduler) start(image *structs.Image) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.queued, image)
+	if current := s.current(image); current != nil {
+		delete(s.queued, current)
+	}
+}
+
+// current returns the image an image was replaced with by a reload, itself if it was not, or nil if it was removed
+// or changed. Must be called with mutex held.
+func (s *scheduler) current(image *structs.Image) *structs.Image {
+	if slices.Contains(s.images, image) {
+		return image
+	}
+
+	i := slices.IndexFunc(s.images, func(current *structs.Image) bool {
+		return sameImage(current, image)
+	})
+	if i < 0 {
+		return nil
+	}
+
+	return s.images[i]
+}
+
+// finish sets the next sync of an image that finished syncing, from the time it was due, and reports whether no
+// image nor pushed tag is being synced anymore. An image replaced by a reload while syncing schedules its unchanged
+// replacement. Tags pushed meanwhile are returned by duePushed.
+func (s *scheduler) finish(ctx context.Context, image *structs.Image) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Wake the loop up, as the image may have been triggered while syncing
+	defer s.signal()
+
+	start, ok := s.running[image]
+	delete(s.running, image)
+	delete(s.queued, image)
+
+	current := s.current(image)
+	if current == nil {
+		return s.idle()
+	}
+
+	if t, found := s.running[current]; found && !ok {
+		start, ok = t, true
+	}
+	delete(s.running, current)
+	delete(s.queued, current)
+	image = current
+
+	if !ok {
+		start = time.Now()
+	}
+
+	next := nextSync(image, start)
+	s.next[image] = next
+	admin.SetNextSync(image.Source, next)
+
+	log.Info().
+		Str("image", image.Source).
+		Time("next", next).
+		Msg("Scheduled next sync")
+
+	telemetry.NextSync.Record(ctx, next.Unix(),
+		metric.WithAttributes(
+			attribute.KeyValue{
+				Key:   "image",
+				Value: attribute.StringValue(image.Source),
+			},
+		),
+	)
+
+	return s.idle()
+}
+
+// idle reports whether no image and no pushed tag is being synced. Must be called with mutex held.
+func (s *scheduler) idle() bool {
+	return len(s.running) == 0 && len(s.tagSyncs) == 0
+}
+
+// startTag marks an image as syncing a pushed tag, unless it is already being synced, in which case the tag is
+// queued until it finishes and false is returned. Scheduled syncs of the image wait for the tag sync.
+func (s *scheduler) startTag(image *structs.Image, tag string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if current := s.current(image); current != nil {
+		image = current
+	}
+
+	if s.busy(image) {
+		if !slices.Contains(s.pushed[image], tag) {
+			s.pushed[image] = append(s.pushed[image], tag)
+		}
+
+		return false
+	}
+
+	s.tagSyncs[image] = true
+
+	return true
+}
+
+// finishTag records that the pushed tags of an image were synced. Tags pushed meanwhile are returned by
+// duePushed.
+func (s *scheduler) finishTag(image *structs.Image) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	defer s.signal()
+
+	delete(s.tagSyncs, image)
+	if current := s.current(image); current != nil {
+		delete(s.tagSyncs, current)
+	}
+}
+
+// duePushed returns the tags queued for images that finished syncing, and marks the images as syncing them.
+// Paused images keep their tags until they are resumed.
+func (s *scheduler) duePushed() []pushedTags {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var due []pushedTags
+
+	for _, image := range s.images {
+		tags, ok := s.pushed[image]
+		if !ok || s.busy(image) || s.paused[image.Source] {
+			continue
+		}
+
+		due = append(due, pushedTags{image: image, tags: tags})
+		delete(s.pushed, image)
+		s.tagSyncs[image] = true
+	}
+
+	return due
+}
+
+// semaphore returns the sync slots, which sync.maxConcurrentImages limits.
+func (s *scheduler) semaphore() chan struct{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.slots
+}
+
+// setLimit replaces the sync slots after a reload changed sync.maxConcurrentImages. Syncs already waiting for a
+// slot keep the previous ones.
+func (s *scheduler) setLimit(limit int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if limit != cap(s.slots) {
+		s.slots = make(chan struct{}, limit)
+	}
+}
+
+// wakeup returns the time the first image or pushed tag is due, ignoring paused and busy images.
+func (s *scheduler) wakeup() time.Time {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var times []time.Time
+
+	for _, image := range s.images {
+		if s.busy(image) || s.paused[image.Source] {
+			continue
+		}
+
+		if _, ok := s.pushed[image]; ok || s.triggered[image] {
+			return time.Now()
+		}
+
+		times = append(times, s.next[image])
+	}
+
+	if len(times) == 0 {
+		return time.Now().Add(config.SyncInterval.Duration())
+	}
+
+	return slices.MinFunc(times, time.Time.Compare)
+}
+
+// match returns the sources of the images matching source, or of all images if source is empty. Paused images
+// are skipped when matching all images, and are an error when matched by source.
+func (s *scheduler) match(source string) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var sources []string
+
+	for _, image := range s.images {
+		if source != "" && image.Source != source {
+			continue
+		}
+
+		if s.paused[image.Source] {
+			if source != "" {
+				return nil, admin.ErrImagePaused
+			}
+
+			continue
+		}
+
+		if !slices.Contains(sources, image.Source) {
+			sources = append(sources, image.Source)
+		}
+	}
+
+	if source != "" && len(sources) == 0 {
+		return nil, admin.ErrUnknownImage
+	}
+
+	return sources, nil
+}
+
+// matchRepository returns the images that are not paused whose source is repository, named as
+// reference.ParseNormalizedNamed does. Buckets and layouts never match.
+func (s *scheduler) matchRepository(repository string) []*structs.Image {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var images []*structs.Image
+
+	for _, image := range s.images {
+		if s.paused[image.Source] {
+			continue
+		}
+
+		named, err := reference.ParseNormalizedNamed(image.Source)
+		if err != nil || named.Name() != repository {
+			continue
+		}
+
+		images = append(images, image)
+	}
+
+	return images
+}
+
+// trigger makes the images of sources due immediately, waking the scheduler up so they start as soon as a sync
+// slot is free. Images being synced are synced again once they finish, images waiting for a slot are not.
+func (s *scheduler) trigger(sources []string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, image := range s.images {
+		if slices.Contains(sources, image.Source) && !s.paused[image.Source] && !s.queued[image] {
+			s.triggered[image] = true
+		}
+	}
+
+	s.signal()
+}
+
+// pause stops scheduling the images of a source. Syncs in progress are not canceled.
+func (s *scheduler) pause(source string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.hasSource(source) {
+		return admin.ErrUnknownImage
+	}
+
+	s.paused[source] = true
+
+	return nil
+}
+
+// resume schedules the images of a source again. Images whose sync was due while paused are synced immediately.
+func (s *scheduler) resume(source string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.hasSource(source) {
+		return admin.ErrUnknownImage
+	}
+
+	delete(s.paused, source)
+	s.signal()
+
+	return nil
+}
+
+// Must be called with mutex held.
+func (s *scheduler) hasSource(source string) bool {
+	return slices.ContainsFunc(s.images, func(image *structs.Image) bool {
+		return image.Source == source
+	})
+}
+
+// signal wakes the scheduler up to check the due images again.
+func (s *scheduler) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// nextSync returns the next sync of an image whose sync was due at now: the next time of its schedule, or now plus
+// its interval or sync.interval.
+func nextSync(image *structs.Image, now time.Time) time.Time {
+	if image.Schedule != "" {
+		schedule, err := cron.ParseStandard(image.Schedule)
+		if err == nil {
+			return schedule.Next(now)
+		}
+
+		log.Error().
+			Err(err).
+			Str("image", image.Source).
+			Str("schedule", image.Schedule).
+			Msg("Invalid schedule, using the sync interval")
+	}
+
+	if image.Interval != "" {
+		interval, err := time.ParseDuration(image.Interval)
+		if err == nil && interval > 0 {
+			return now.Add(interval)
+		}
+
+		log.Error().
+			Err(err).
+			Str("image", image.Source).
+			Str("interval", image.Interval).
+			Msg("Invalid interval, using the sync interval")
+	}
+
+	return now.Add(config.SyncInterval.Duration())
+}
@@ -0,0 +1,225 @@
+package dockersync
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Altinity/docker-sync/config"
+	"github.com/Altinity/docker-sync/internal/admin"
+	"github.com/Altinity/docker-sync/structs"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNextSync(t *testing.T) {
+	viper.Set("sync.interval", "30m")
+	config.SyncInterval.Update()
+
+	now := time.Date(2025, 1, 1, 10, 15, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name     string
+		image    *structs.Image
+		expected time.Time
+	}{
+		{
+			name:     "Global interval",
+			image:    &structs.Image{Source: "altinity/image"},
+			expected: now.Add(30 * time.Minute),
+		},
+		{
+			name:     "Image interval",
+			image:    &structs.Image{Source: "altinity/image", Interval: "5m"},
+			expected: now.Add(5 * time.Minute),
+		},
+		{
+			name:     "Schedule",
+			image:    &structs.Image{Source: "altinity/image", Schedule: "0 */6 * * *"},
+			expected: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "Invalid schedule",
+			image:    &structs.Image{Source: "altinity/image", Schedule: "every day"},
+			expected: now.Add(30 * time.Minute),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, nextSync(tc.image, now))
+		})
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	hot := &structs.Image{Source: "altinity/hot", Interval: "5m"}
+	archive := &structs.Image{Source: "altinity/archive", Schedule: "@weekly"}
+
+	// Images are due on start, or at the next time of their schedule
+	now := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)
+	s := newScheduler([]*structs.Image{hot, archive}, now)
+	assert.Equal(t, []*structs.Image{hot}, s.due(now))
+
+	// Running images are not due again
+	assert.Empty(t, s.due(now.Add(10*time.Minute)))
+	assert.Equal(t, time.Date(2025, 1, 5, 0, 0, 0, 0, time.UTC), s.wakeup())
+
+	// The next sync is scheduled from the time the image was due, not the time it finished
+	assert.True(t, s.finish(t.Context(), hot))
+	assert.Equal(t, now.Add(5*time.Minute), s.wakeup())
+
+	// Only the hot image is due after its interval
+	now = now.Add(5 * time.Minute)
+	assert.Equal(t, []*structs.Image{hot}, s.due(now))
+
+	// The archive image is due on Sunday at midnight, while the hot image is still syncing
+	sunday := time.Date(2025, 1, 5, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, []*structs.Image{archive}, s.due(sunday))
+	assert.False(t, s.finish(t.Context(), hot))
+	assert.True(t, s.finish(t.Context(), archive))
+	assert.Equal(t, time.Date(2025, 1, 12, 0, 0, 0, 0, time.UTC), s.next[archive])
+
+	// After a reload, unchanged images keep their next sync and changed images are scheduled as on start
+	reloadedHot := &structs.Image{Source: "altinity/hot", Interval: "5m"}
+	reloadedArchive := &structs.Image{Source: "altinity/archive", Interval: "1h"}
+
+	s.update([]*structs.Image{reloadedHot, reloadedArchive})
+	assert.Equal(t, []*structs.Image{reloadedArchive}, s.due(now))
+	assert.Equal(t, now.Add(5*time.Minute), s.next[reloadedHot])
+
+	// Images replaced while syncing schedule their unchanged replacement
+	assert.Equal(t, []*structs.Image{reloadedHot}, s.due(now.Add(5*time.Minute)))
+	s.update([]*structs.Image{{Source: "altinity/hot", Interval: "5m"}, reloadedArchive})
+	assert.Empty(t, s.due(now.Add(5*time.Minute)))
+	assert.False(t, s.finish(t.Context(), reloadedHot))
+	assert.Equal(t, now.Add(10*time.Minute), s.next[s.images[0]])
+}
+
+func TestSchedulerControl(t *testing.T) {
+	viper.Set("sync.interval", "30m")
+	config.SyncInterval.Update()
+
+	hot := &structs.Image{Source: "altinity/hot", Interval: "5m"}
+	archive := &structs.Image{Source: "altinity/archive", Schedule: "@weekly"}
+
+	now := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)
+	s := newScheduler([]*structs.Image{hot, archive}, now)
+	for _, image := range s.due(now) {
+		s.finish(t.Context(), image)
+	}
+	<-s.wake
+
+	// Triggered images are due immediately, once
+	sources, err := s.match("altinity/archive")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"altinity/archive"}, sources)
+
+	s.trigger(sources)
+	assert.Len(t, s.wake, 1)
+	assert.WithinDuration(t, time.Now(), s.wakeup(), time.Second)
+	assert.Equal(t, []*structs.Image{archive}, s.due(now))
+	assert.Empty(t, s.due(now))
+	s.finish(t.Context(), archive)
+
+	// Triggered images start while other images are syncing
+	assert.Equal(t, []*structs.Image{hot}, s.due(now.Add(5*time.Minute)))
+	s.start(hot)
+	s.trigger([]string{"altinity/archive"})
+	assert.Equal(t, []*structs.Image{archive}, s.due(now))
+
+	// Images waiting for a sync slot are not triggered again, syncing images are once they finish
+	s.trigger([]string{"altinity/hot", "altinity/archive"})
+	s.finish(t.Context(), archive)
+	s.finish(t.Context(), hot)
+	assert.Equal(t, []*structs.Image{hot}, s.due(now))
+	s.finish(t.Context(), hot)
+
+	_, err = s.match("altinity/unknown")
+	assert.ErrorIs(t, err, admin.ErrUnknownImage)
+
+	// Paused images are neither due nor triggered
+	assert.NoError(t, s.pause("altinity/hot"))
+	assert.ErrorIs(t, s.pause("altinity/unknown"), admin.ErrUnknownImage)
+
+	_, err = s.match("altinity/hot")
+	assert.ErrorIs(t, err, admin.ErrImagePaused)
+
+	sources, err = s.match("")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"altinity/archive"}, sources)
+
+	now = now.Add(10 * time.Minute)
+	assert.Empty(t, s.due(now))
+	assert.Equal(t, time.Date(2025, 1, 5, 0, 0, 0, 0, time.UTC), s.wakeup())
+
+	// Pauses survive reloads of the image, and resumed images that missed their sync are due immediately
+	s.update([]*structs.Image{hot, archive})
+	assert.True(t, s.paused["altinity/hot"])
+
+	assert.NoError(t, s.resume("altinity/hot"))
+	assert.Equal(t, []*structs.Image{hot}, s.due(now))
+
+	// Pauses of images removed from the configuration are forgotten
+	assert.NoError(t, s.pause("altinity/archive"))
+	s.update([]*structs.Image{hot})
+	assert.Empty(t, s.paused)
+}
+
+func TestSchedulerMatchRepository(t *testing.T) {
+	ubuntu := &structs.Image{Source: "ubuntu"}
+	mirror := &structs.Image{Source: "docker.io/library/ubuntu", Tags: []string{"24.04"}}
+	ghcr := &structs.Image{Source: "ghcr.io/altinity/image"}
+	bucket := &structs.Image{Source: "s3:us-east-1:bucket:altinity/image"}
+
+	s := newScheduler([]*structs.Image{ubuntu, mirror, ghcr, bucket}, time.Now())
+
+	assert.Equal(t, []*structs.Image{ubuntu, mirror}, s.matchRepository("docker.io/library/ubuntu"))
+	assert.Equal(t, []*structs.Image{ghcr}, s.matchRepository("ghcr.io/altinity/image"))
+	assert.Empty(t, s.matchRepository("altinity/image"))
+
+	assert.NoError(t, s.pause("ubuntu"))
+	assert.Equal(t, []*structs.Image{mirror}, s.matchRepository("docker.io/library/ubuntu"))
+}
+
+func TestSchedulerPushedTags(t *testing.T) {
+	hot := &structs.Image{Source: "altinity/hot", Interval: "5m"}
+
+	now := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)
+	s := newScheduler([]*structs.Image{hot}, now)
+
+	// Images syncing a pushed tag are not due until it was synced, and further tags are queued
+	assert.True(t, s.startTag(hot, "1.0"))
+	assert.Empty(t, s.due(now))
+	assert.False(t, s.startTag(hot, "1.1"))
+	assert.False(t, s.startTag(hot, "1.1"))
+	assert.Empty(t, s.duePushed())
+
+	s.finishTag(hot)
+	<-s.wake
+	assert.WithinDuration(t, time.Now(), s.wakeup(), time.Second)
+	assert.Equal(t, []pushedTags{{image: hot, tags: []string{"1.1"}}}, s.duePushed())
+	assert.Empty(t, s.due(now))
+	s.finishTag(hot)
+
+	// Tags pushed while the image is synced wait for the sync to finish, and don't delay the next one
+	assert.Equal(t, []*structs.Image{hot}, s.due(now))
+	assert.False(t, s.startTag(hot, "1.2"))
+	assert.Empty(t, s.duePushed())
+	assert.True(t, s.finish(t.Context(), hot))
+	assert.Equal(t, now.Add(5*time.Minute), s.next[hot])
+
+	// Paused images keep their tags until they are resumed
+	assert.NoError(t, s.pause("altinity/hot"))
+	assert.Empty(t, s.duePushed())
+	assert.NoError(t, s.resume("altinity/hot"))
+	assert.Equal(t, []pushedTags{{image: hot, tags: []string{"1.2"}}}, s.duePushed())
+
+	// The tags of images replaced by a reload follow them
+	assert.False(t, s.startTag(hot, "1.3"))
+	reloadedHot := &structs.Image{Source: "altinity/hot", Interval: "5m"}
+	s.update([]*structs.Image{reloadedHot})
+	s.finishTag(hot)
+	assert.Equal(t, []pushedTags{{image: reloadedHot, tags: []string{"1.3"}}}, s.duePushed())
+	s.finishTag(reloadedHot)
+	assert.Empty(t, s.tagSyncs)
+}
@@ -15,6 +15,23 @@ type Image struct {
 	Tags        []string             `json:"tags" yaml:"tags"`
 	SrcRef      types.ImageReference `json:"-" yaml:"-"`
 	Purge       bool                 `json:"purge" yaml:"purge"`
+	// ImmutableTagDrift controls what happens when an immutable tag already present in a target has a
+	// different digest than in the source: "ignore" (default, the digest is not checked), "warn" or "resync".
+	ImmutableTagDrift string `json:"immutableTagDrift" yaml:"immutableTagDrift"`
+	// Platforms limits the instances of a manifest list that are copied, e.g. "linux/amd64" or "linux/arm64/v8".
+	Platforms []string `json:"platforms" yaml:"platforms"`
+	// PlatformIndex controls the index pushed when Platforms is set: "preserve" (default) keeps the original
+	// index and its digest, "rewrite" pushes an index that only lists the selected platforms.
+	PlatformIndex string `json:"platformIndex" yaml:"platformIndex"`
+	// IncludeReferrers copies the signatures, attestations and SBOMs attached to the synced tags, published as
+	// sha256-<digest>(.sig|.att|.sbom) tags.
+	IncludeReferrers bool `json:"includeReferrers" yaml:"includeReferrers"`
+	// Policy overrides the global signature policy (sync.policy) for this image.
+	Policy *SignaturePolicy `json:"policy" yaml:"policy"`
+	// Interval overrides the global sync interval (sync.interval) for this image, e.g. "5m" or "168h".
+	Interval string `json:"interval" yaml:"interval"`
+	// Schedule syncs the image on a cron expression instead of an interval, e.g. "0 */6 * * *" or "@weekly".
+	Schedule string `json:"schedule" yaml:"schedule"`
 }
 
 func (i *Image) GetSource() string {
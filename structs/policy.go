@@ -0,0 +1,19 @@
+package structs
+
+// SignaturePolicy describes the signatures a source image must carry before it is copied.
+type SignaturePolicy struct {
+	// Path is a containers-policy.json file. When set, Type and KeyPath are ignored.
+	Path string `json:"path" yaml:"path"`
+	// Type is "insecureAcceptAnything" (default), "sigstoreSigned" (cosign public key) or "signedBy" (GPG keyring).
+	Type string `json:"type" yaml:"type"`
+	// KeyPath is the cosign public key or the GPG keyring used to verify signatures.
+	KeyPath string `json:"keyPath" yaml:"keyPath"`
+	// RegistriesDir is a registries.d directory describing where signatures are stored. When empty,
+	// sigstore signatures are looked up as attachments next to the image.
+	RegistriesDir string `json:"registriesDir" yaml:"registriesDir"`
+}
+
+// IsEnforced reports whether the policy requires any signature.
+func (p *SignaturePolicy) IsEnforced() bool {
+	return p != nil && (p.Path != "" || (p.Type != "" && p.Type != "insecureAcceptAnything"))
+}
@@ -5,10 +5,39 @@ type RepositoryAuth struct {
 	Password string `json:"password" yaml:"password"`
 	Token    string `json:"token" yaml:"token"`
 	Helper   string `json:"helper" yaml:"helper"`
+	AuthFile string `json:"authFile" yaml:"authFile"`
+}
+
+// RepositoryS3 configures the connection to an S3-compatible bucket, such as MinIO or Ceph RGW.
+type RepositoryS3 struct {
+	// Endpoint overrides the endpoint URL, e.g. "https://minio.example.com:9000".
+	Endpoint string `json:"endpoint" yaml:"endpoint"`
+	// PathStyle addresses buckets as <endpoint>/<bucket> instead of <bucket>.<endpoint>.
+	PathStyle bool `json:"pathStyle" yaml:"pathStyle"`
+	// CAFile is a PEM bundle of additional certificate authorities trusted by the endpoint.
+	CAFile string `json:"caFile" yaml:"caFile"`
+	// Region overrides the region requests are signed for.
+	Region string `json:"region" yaml:"region"`
+	// ACL is the canned ACL of uploaded objects, "public-read" by default. "none" sends no ACL, as required by
+	// buckets with Object Ownership enforced.
+	ACL string `json:"acl" yaml:"acl"`
+	// StorageClass is the storage class of uploaded objects, e.g. "STANDARD_IA".
+	StorageClass string `json:"storageClass" yaml:"storageClass"`
+	// ServerSideEncryption is "AES256" (SSE-S3) or "aws:kms" (SSE-KMS).
+	ServerSideEncryption string `json:"serverSideEncryption" yaml:"serverSideEncryption"`
+	// KMSKeyID is the KMS key used with SSE-KMS. The bucket default key is used when empty.
+	KMSKeyID string `json:"kmsKeyId" yaml:"kmsKeyId"`
+	// Metadata is added to every uploaded object.
+	Metadata map[string]string `json:"metadata" yaml:"metadata"`
+	// Tags are set on every uploaded object.
+	Tags map[string]string `json:"tags" yaml:"tags"`
+	// SharedBlobs stores blobs once per bucket in v2/_blobs, and repositories redirect to them.
+	SharedBlobs bool `json:"sharedBlobs" yaml:"sharedBlobs"`
 }
 
 type Repository struct {
 	Name string         `json:"name" yaml:"name"`
 	URL  string         `json:"url" yaml:"url"`
 	Auth RepositoryAuth `json:"auth" yaml:"auth"`
+	S3   *RepositoryS3  `json:"s3,omitempty" yaml:"s3,omitempty"`
 }
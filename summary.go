@@ -0,0 +1,34 @@
+package dockersync
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// summary counts the image syncs of a run, logged when the run ends.
+type summary struct {
+	start  time.Time
+	synced atomic.Int64
+	failed atomic.Int64
+}
+
+func newSummary() *summary {
+	return &summary{
+		start: time.Now(),
+	}
+}
+
+func (s *summary) log(err error) {
+	event := log.Info()
+	if err != nil {
+		event = log.Error().Err(err)
+	}
+
+	event.
+		Int64("synced", s.synced.Load()).
+		Int64("failed", s.failed.Load()).
+		Dur("duration", time.Since(s.start)).
+		Msg("Sync summary")
+}